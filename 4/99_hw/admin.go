@@ -0,0 +1,286 @@
+package hw4
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// adminTokens - токены, которым открыт /admin
+var adminTokens = map[string]bool{}
+
+// токен считается активным, если с ним приходили запросы за последние activeTokenWindow
+const activeTokenWindow = 15 * time.Minute
+
+// больше стольких токенов за окно не запоминаем, чтобы поток случайных токенов не съел память
+const maxTrackedTokens = 10000
+
+// tokenTracker помнит, когда последний раз приходил каждый токен. Хранятся только отпечатки
+type tokenTracker struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+	pruned   time.Time
+}
+
+var activeTokens = &tokenTracker{}
+
+// seen отмечает токен, прошедший авторизацию. Старые токены забываются здесь же, не реже раза в минуту
+func (tt *tokenTracker) seen(token string) {
+	if token == "" {
+		return
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.lastSeen == nil {
+		tt.lastSeen = map[string]time.Time{}
+	}
+	now := time.Now()
+	if now.Sub(tt.pruned) > time.Minute || len(tt.lastSeen) >= maxTrackedTokens {
+		tt.prune(now)
+	}
+	key := redactToken(token)
+	if _, ok := tt.lastSeen[key]; !ok && len(tt.lastSeen) >= maxTrackedTokens {
+		return
+	}
+	tt.lastSeen[key] = now
+}
+
+func (tt *tokenTracker) prune(now time.Time) {
+	for token, at := range tt.lastSeen {
+		if now.Sub(at) > activeTokenWindow {
+			delete(tt.lastSeen, token)
+		}
+	}
+	tt.pruned = now
+}
+
+// count считает активные токены и заодно забывает старые
+func (tt *tokenTracker) count() int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.prune(time.Now())
+	return len(tt.lastSeen)
+}
+
+type adminStatus struct {
+	Dataset      adminDataset `json:"dataset"`
+	Memory       adminMemory  `json:"memory"`
+	ActiveTokens int          `json:"active_tokens"`
+}
+
+type adminDataset struct {
+	Path              string    `json:"path"`
+	Snapshot          string    `json:"snapshot"`
+	PreviousSnapshots []string  `json:"previous_snapshots"`
+	LoadedAt          time.Time `json:"loaded_at"`
+	Records           int       `json:"records"`
+	Indexes           []string  `json:"indexes"`
+	IndexBuildMs      float64   `json:"index_build_ms"`
+}
+
+type adminMemory struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	Goroutines     int    `json:"goroutines"`
+}
+
+// adminConfig - настройки, с которыми реально работает SearchServer. Сами токены не отдаются
+type adminConfig struct {
+	DatasetPath     string    `json:"dataset_path"`
+	KeepSnapshots   int       `json:"keep_snapshots"`
+	SnapshotTTL     string    `json:"snapshot_ttl"`
+	OrderFields     []string  `json:"order_fields"`
+	DefaultOrder    string    `json:"default_order_field"`
+	ContentTypes    []string  `json:"content_types"`
+	ExportBatchSize int       `json:"export_batch_size"`
+	LatencyBuckets  []float64 `json:"latency_buckets"`
+	WriteTokens     int       `json:"write_tokens"`
+	AdminTokens     int       `json:"admin_tokens"`
+	// политика для токенов без своей и сколько токенов со своей
+	DefaultRedaction  RedactionPolicy `json:"default_redaction"`
+	RedactionPolicies int             `json:"redaction_policies"`
+	// файл журнала аудита, пустой - журнал не пишется
+	AuditLog string `json:"audit_log"`
+}
+
+// adminReloadPreview - что поменяется при POST /admin/reload
+type adminReloadPreview struct {
+	Snapshot     string      `json:"snapshot"`
+	NextSnapshot string      `json:"next_snapshot"`
+	Summary      DiffSummary `json:"summary"`
+	// почему reload с такими же лимитами откажется, пусто - загрузится
+	Rejected string `json:"rejected,omitempty"`
+}
+
+func previewReload(store *datasetStore, limits DiffLimits) (adminReloadPreview, error) {
+	cur, err := store.current()
+	if err != nil {
+		return adminReloadPreview{}, err
+	}
+	next, err := LoadDataset(store.path)
+	if err != nil {
+		return adminReloadPreview{}, err
+	}
+	preview := adminReloadPreview{
+		Snapshot:     cur.Snapshot,
+		NextSnapshot: next.Snapshot,
+		Summary:      DiffDatasets(cur.Persons, next.Persons).Summary(),
+	}
+	if err := preview.Summary.Check(limits); err != nil {
+		preview.Rejected = err.Error()
+	}
+	return preview, nil
+}
+
+func newAdminStatus(store *datasetStore, ds *Dataset) adminStatus {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return adminStatus{
+		Dataset: adminDataset{
+			Path:              store.path,
+			Snapshot:          ds.Snapshot,
+			PreviousSnapshots: store.snapshots(),
+			LoadedAt:          ds.LoadedAt,
+			Records:           ds.Len(),
+			Indexes:           slices.Sorted(maps.Keys(ds.sorted)),
+			IndexBuildMs:      float64(ds.IndexBuildTime.Microseconds()) / 1000,
+		},
+		Memory: adminMemory{
+			HeapAllocBytes: mem.HeapAlloc,
+			SysBytes:       mem.Sys,
+			NumGC:          mem.NumGC,
+			Goroutines:     runtime.NumGoroutine(),
+		},
+		ActiveTokens: activeTokens.count(),
+	}
+}
+
+func newAdminConfig() adminConfig {
+	config := adminConfig{
+		DatasetPath:     defaultDatasets.path,
+		KeepSnapshots:   keepSnapshots,
+		SnapshotTTL:     snapshotTTL.String(),
+		OrderFields:     comparatorNames(),
+		DefaultOrder:    defaultOrderField,
+		ExportBatchSize: defaultExportBatchSize,
+		LatencyBuckets:  latencyBuckets,
+		WriteTokens:     len(writeTokens),
+		AdminTokens:     len(adminTokens),
+
+		DefaultRedaction:  defaultRedactionPolicy,
+		RedactionPolicies: len(tokenPolicies),
+	}
+	if auditLog != nil {
+		config.AuditLog = auditLog.path
+	}
+	for _, enc := range userEncodings {
+		config.ContentTypes = append(config.ContentTypes, enc.contentType)
+	}
+	return config
+}
+
+// AdminServer - служебные ручки для токенов из adminTokens:
+// GET /admin - что загружено и сколько памяти занято, GET /admin/config - действующие настройки,
+// POST /admin/reload - перечитать датасет с диска (с max_added, max_removed, max_modified - только
+// если изменений не больше, иначе 409; с dry_run=true - только показать изменения),
+// POST /admin/reindex - перестроить индексы,
+// GET /admin/audit?token=&from=&to=&limit= - журнал поисков,
+// GET /admin/duplicates?email=&phone=&name_similarity=&min_confidence= - вероятные дубли, см. FindDuplicates.
+// На /datasets/{name}/admin те же ручки работают с именованным датасетом, config и audit - общие
+func AdminServer(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("AccessToken")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+	if !adminTokens[token] {
+		writeError(w, http.StatusForbidden, "AccessToken has no admin access")
+		return
+	}
+
+	var (
+		result interface{}
+		err    error
+		store  = storeFor(r)
+	)
+	switch action := r.PathValue("action"); {
+	case action == "" && r.Method == http.MethodGet:
+		var ds *Dataset
+		if ds, err = store.Get(); err == nil {
+			result = newAdminStatus(store, ds)
+		}
+	case action == "config" && r.Method == http.MethodGet:
+		result = newAdminConfig()
+	case action == "reload" && r.Method == http.MethodPost:
+		r.ParseForm()
+		limits, lerr := parseDiffLimits(r.Form)
+		if lerr != nil {
+			writeError(w, http.StatusBadRequest, lerr.Error())
+			return
+		}
+		if r.Form.Get("dry_run") == "true" {
+			result, err = previewReload(store, limits)
+			break
+		}
+		var ds *Dataset
+		ds, err = store.reload(func(cur, next *Dataset) error {
+			return DiffDatasets(cur.Persons, next.Persons).Summary().Check(limits)
+		})
+		if errors.Is(err, errDiffLimits) {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err == nil {
+			result = newAdminStatus(store, ds)
+		}
+	case action == "reindex" && r.Method == http.MethodPost:
+		var ds *Dataset
+		if ds, err = store.reindex(); err == nil {
+			result = newAdminStatus(store, ds)
+		}
+	case action == "audit" && r.Method == http.MethodGet:
+		if auditLog == nil {
+			writeError(w, http.StatusNotFound, errAuditDisabled.Error())
+			return
+		}
+		r.ParseForm()
+		q, qerr := parseAuditQuery(r.Form)
+		if qerr != nil {
+			writeError(w, http.StatusBadRequest, qerr.Error())
+			return
+		}
+		result, err = auditLog.Query(q)
+	case action == "duplicates" && r.Method == http.MethodGet:
+		r.ParseForm()
+		rules, rerr := parseDuplicateRules(r.Form)
+		if rerr != nil {
+			writeError(w, http.StatusBadRequest, rerr.Error())
+			return
+		}
+		var ds *Dataset
+		if ds, err = store.Get(); err == nil {
+			result = FindDuplicates(ds.Persons, rules)
+		}
+	case action == "" || action == "config" || action == "reload" || action == "reindex" || action == "audit" || action == "duplicates":
+		writeError(w, http.StatusMethodNotAllowed, "unknown method")
+		return
+	default:
+		writeError(w, http.StatusNotFound, "unknown admin action")
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,130 @@
+package hw4
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func useAdminTokens(t *testing.T, tokens ...string) {
+	t.Helper()
+	old := adminTokens
+	adminTokens = map[string]bool{}
+	for _, token := range tokens {
+		adminTokens[token] = true
+	}
+	t.Cleanup(func() { adminTokens = old })
+}
+
+func adminRequest(t *testing.T, server *httptest.Server, method, path, token string, result interface{}) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, server.URL+path, nil)
+	req.Header.Set("AccessToken", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if result != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("cant unpack %s: %v", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminServer(t *testing.T) {
+	path := useDatasetCopy(t)
+	useAdminTokens(t, "admin")
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	for _, c := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodGet, "/admin", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin", "reader", http.StatusForbidden},
+		{http.MethodPost, "/admin", "admin", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/reload", "admin", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/unknown", "admin", http.StatusNotFound},
+	} {
+		if status := adminRequest(t, server, c.method, c.path, c.token, nil); status != c.status {
+			t.Errorf("%s %s with %q: expected %d, got %d", c.method, c.path, c.token, c.status, status)
+		}
+	}
+
+	// активным считается только токен, который куда-то пустили
+	if _, err := (&SearchClient{URL: server.URL, AccessToken: "reader"}).FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status := adminStatus{}
+	if code := adminRequest(t, server, http.MethodGet, "/admin", "admin", &status); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if status.Dataset.Path != path || status.Dataset.Records != 35 || status.Dataset.Snapshot == "" {
+		t.Errorf("wrong dataset status: %+v", status.Dataset)
+	}
+	if !slices.Equal(status.Dataset.Indexes, comparatorNames()) || !slices.Contains(status.Dataset.Indexes, "Balance") {
+		t.Errorf("wrong indexes: %v", status.Dataset.Indexes)
+	}
+	if status.Memory.HeapAllocBytes == 0 || status.Memory.Goroutines == 0 || status.ActiveTokens < 2 {
+		t.Errorf("wrong runtime status: %+v, active tokens %d", status.Memory, status.ActiveTokens)
+	}
+
+	config := adminConfig{}
+	adminRequest(t, server, http.MethodGet, "/admin/config", "admin", &config)
+	if config.DatasetPath != path || config.AdminTokens != 1 || config.KeepSnapshots != keepSnapshots || len(config.ContentTypes) != len(userEncodings) {
+		t.Errorf("wrong config: %+v", config)
+	}
+
+	reindexed := adminStatus{}
+	adminRequest(t, server, http.MethodPost, "/admin/reindex", "admin", &reindexed)
+	if reindexed.Dataset.Snapshot != status.Dataset.Snapshot || !reindexed.Dataset.LoadedAt.After(status.Dataset.LoadedAt) {
+		t.Errorf("reindex should keep snapshot and rebuild: %+v", reindexed.Dataset)
+	}
+
+	// файл поменяли, сохранив mtime: сам датасет этого не заметит, а reload перечитает
+	info, _ := os.Stat(path)
+	data, _ := os.ReadFile(path)
+	data = []byte(strings.Replace(string(data), "<row>", "<!-- edited --><row>", 1))
+	os.WriteFile(path, data, 0644)
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+
+	reloaded := adminStatus{}
+	adminRequest(t, server, http.MethodPost, "/admin/reload", "admin", &reloaded)
+	if reloaded.Dataset.Snapshot == status.Dataset.Snapshot {
+		t.Errorf("reload did not pick up the changed file")
+	}
+	if len(reloaded.Dataset.PreviousSnapshots) != 1 || reloaded.Dataset.PreviousSnapshots[0] != status.Dataset.Snapshot {
+		t.Errorf("expected previous snapshot %s, got %v", status.Dataset.Snapshot, reloaded.Dataset.PreviousSnapshots)
+	}
+}
+
+func TestTokenTracker(t *testing.T) {
+	tt := &tokenTracker{}
+	for i := 0; i < maxTrackedTokens+100; i++ {
+		tt.seen(strconv.Itoa(i))
+	}
+	if n := tt.count(); n != maxTrackedTokens {
+		t.Errorf("expected %d tracked tokens, got %d", maxTrackedTokens, n)
+	}
+
+	// устаревшие забываются при следующей отметке, и место освобождается
+	tt.mu.Lock()
+	for token := range tt.lastSeen {
+		tt.lastSeen[token] = time.Now().Add(-2 * activeTokenWindow)
+	}
+	tt.mu.Unlock()
+	tt.seen("fresh")
+	if n := tt.count(); n != 1 {
+		t.Errorf("expected only the fresh token, got %d", n)
+	}
+}
@@ -0,0 +1,342 @@
+package hw4
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditMaxBytes = 100 << 20
+	defaultAuditMaxFiles = 10
+	defaultAuditLimit    = 1000
+)
+
+var errAuditDisabled = errors.New("audit log is disabled")
+
+// auditLog - журнал поисков, nil - не пишется
+var auditLog *AuditLog
+
+// AuditRecord - одна строка журнала: кто, когда, что искал и кого нашёл.
+// Кроме поиска пишутся все ручки, которые отдают записи датасета или их id
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// полный sha256 токена, см. auditToken, сам токен не пишется
+	Token    string `json:"token"`
+	Dataset  string `json:"dataset,omitempty"`
+	Snapshot string `json:"snapshot"`
+	// через какую ручку отданы записи: пусто - поиск, иначе export, facets, persons, searches или changes
+	Endpoint string `json:"endpoint,omitempty"`
+	// параметры после разбора, в одном порядке и формате, вне зависимости от того, как их прислали
+	Params string `json:"params"`
+	// limit в Params на одну запись больше, чем просили: так SearchClient узнаёт о следующей странице
+	LimitProbe bool  `json:"limit_probe,omitempty"`
+	Results    int   `json:"results"`
+	UserIDs    []int `json:"user_ids"`
+}
+
+// LimitProbeHeader - заголовок запроса, которым SearchClient помечает limit с лишней записью
+const LimitProbeHeader = "X-Limit-Probe"
+
+// AuditQuery - фильтр для AuditLog.Query, пустые поля не фильтруют
+type AuditQuery struct {
+	// сам токен или его отпечаток "sha256:..." из AuditRecord.Token
+	Token string
+	// Time в [From, To)
+	From, To time.Time
+	// не больше стольких последних подходящих записей, 0 - defaultAuditLimit
+	Limit int
+}
+
+// AuditLog пишет AuditRecord в JSONL-файл только дописыванием. Когда файл дорастает до maxBytes,
+// он становится path.1, прежний path.1 - path.2 и так далее, старше path.{maxFiles} удаляются
+type AuditLog struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenAuditLog открывает журнал на дописывание, maxBytes и maxFiles <= 0 - значения по умолчанию
+func OpenAuditLog(path string, maxBytes int64, maxFiles int) (*AuditLog, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultAuditMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultAuditMaxFiles
+	}
+	l := &AuditLog{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+func (l *AuditLog) rotated(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+func (l *AuditLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(l.rotated(l.maxFiles))
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(l.rotated(n), l.rotated(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.rotated(1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Write дописывает запись одной строкой, при необходимости сначала ротирует файл
+func (l *AuditLog) Write(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("cant rotate audit log: %w", err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Query ищет последние q.Limit подходящих записей, от новых файлов журнала к старым,
+// и отдаёт их от старых к новым
+func (l *AuditLog) Query(q AuditQuery) ([]AuditRecord, error) {
+	if q.Token != "" && !strings.HasPrefix(q.Token, "sha256:") {
+		q.Token = auditToken(q.Token)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultAuditLimit
+	}
+
+	files, err := l.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	result := []AuditRecord{}
+	for _, f := range files {
+		matches, err := q.scan(f)
+		if err != nil {
+			return nil, err
+		}
+		result = append(matches, result...)
+		if len(result) >= q.Limit {
+			break
+		}
+	}
+	return result[max(len(result)-q.Limit, 0):], nil
+}
+
+// auditFile - файл журнала, открытый для Query, и сколько из него читать
+type auditFile struct {
+	*os.File
+	size int64
+}
+
+// openFiles открывает файлы журнала от нового к старому. Под mu только открытие:
+// открытые файлы переживут ротацию, а текущий читается до размера на момент открытия,
+// без строки, которую дописывают прямо сейчас. Сам поиск идёт без mu и не задерживает Write
+func (l *AuditLog) openFiles() ([]auditFile, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var files []auditFile
+	for n := 0; n <= l.maxFiles; n++ {
+		path, size := l.path, l.size
+		if n > 0 {
+			path, size = l.rotated(n), -1
+		}
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil && size < 0 {
+			var info os.FileInfo
+			if info, err = f.Stat(); err == nil {
+				size = info.Size()
+			}
+		}
+		if err != nil {
+			if f != nil {
+				f.Close()
+			}
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, auditFile{File: f, size: size})
+	}
+	return files, nil
+}
+
+// scan - все подходящие под q записи файла по порядку
+func (q AuditQuery) scan(f auditFile) ([]AuditRecord, error) {
+	var result []AuditRecord
+	// не bufio.Scanner: в записях выгрузок и фасетов все id в одной строке, и длину её не ограничить
+	reader := bufio.NewReader(io.LimitReader(f, f.size))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec AuditRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			if q.matches(rec) {
+				result = append(result, rec)
+			}
+		}
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+	}
+}
+
+func (q AuditQuery) matches(rec AuditRecord) bool {
+	switch {
+	case q.Token != "" && rec.Token != q.Token:
+		return false
+	case !q.From.IsZero() && rec.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !rec.Time.Before(q.To):
+		return false
+	}
+	return true
+}
+
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// auditToken - отпечаток токена для журнала. В отличие от redactToken из лога доступа хеш полный:
+// по 32 битам записи разных токенов могли бы совпасть при фильтре по токену
+func auditToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// auditSearch записывает в журнал успешный поиск перед отдачей ответа
+func auditSearch(r *http.Request, token, snapshot string, sr SearchRequest, ids []int) error {
+	return auditRead(r, "", token, snapshot, normalizedParams(sr), ids)
+}
+
+// auditRead записывает в журнал, кому ручка endpoint отдаёт записи ids, перед отдачей ответа
+func auditRead(r *http.Request, endpoint, token, snapshot, params string, ids []int) error {
+	if auditLog == nil {
+		return nil
+	}
+	return auditLog.Write(AuditRecord{
+		Time:     time.Now().UTC(),
+		Token:    auditToken(token),
+		Dataset:  r.PathValue("name"),
+		Endpoint: endpoint,
+		Snapshot: snapshot,
+		Params:   params,
+		// заголовок шлёт только FindUsers, сохранённые поиски и остальные ручки limit не добавляют
+		LimitProbe: r.Header.Get(LimitProbeHeader) != "",
+		Results:    len(ids),
+		UserIDs:    ids,
+	})
+}
+
+// writeAuditError - ответ, когда запись не попала в журнал аудита: без неё результат не отдаём
+func writeAuditError(w http.ResponseWriter, err error) {
+	accessLog.Error("audit log write failed", "error", err.Error())
+	writeError(w, http.StatusInternalServerError, "cant write audit log")
+}
+
+// normalizedParams - параметры поиска так, как их понял SearchServer, ключи по алфавиту
+func normalizedParams(sr SearchRequest) string {
+	params := url.Values{}
+	if sr.Query != "" {
+		params.Add("query", sr.Query)
+	}
+	orderField := sr.OrderField
+	if orderField == "" {
+		orderField = defaultOrderField
+	}
+	params.Add("order_field", orderField)
+	params.Add("order_by", strconv.Itoa(sr.OrderBy))
+	params.Add("offset", strconv.Itoa(sr.Offset))
+	params.Add("limit", strconv.Itoa(sr.Limit))
+	addFilterParams(params, sr)
+	addFieldsParam(params, sr.Fields)
+	return params.Encode()
+}
+
+// parseAuditQuery разбирает token, from, to и limit для GET /admin/audit
+func parseAuditQuery(params url.Values) (AuditQuery, error) {
+	q := AuditQuery{Token: params.Get("token")}
+	for name, value := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if raw := params.Get(name); raw != "" {
+			t, err := parseDateParam(name, raw)
+			if err != nil {
+				return AuditQuery{}, err
+			}
+			*value = t
+		}
+	}
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return AuditQuery{}, errors.New("Invalid limit value")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
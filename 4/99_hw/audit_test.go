@@ -0,0 +1,258 @@
+package hw4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useAuditLog включает журнал аудита во временном каталоге на время теста
+func useAuditLog(t *testing.T, maxBytes int64, maxFiles int) *AuditLog {
+	t.Helper()
+	l, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"), maxBytes, maxFiles)
+	if err != nil {
+		t.Fatalf("cant open audit log: %v", err)
+	}
+	old := auditLog
+	auditLog = l
+	t.Cleanup(func() {
+		auditLog = old
+		l.Close()
+	})
+	return l
+}
+
+func TestAuditSearch(t *testing.T) {
+	useAdminTokens(t, "admin")
+	useAuditLog(t, 0, 0)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	alice := &SearchClient{AccessToken: "alice", URL: server.URL}
+	resp, err := alice.FindUsers(SearchRequest{Limit: 2, Query: "Boyd", OrderField: "Id", OrderBy: OrderByAsc, State: "mississippi"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bob := &SearchClient{AccessToken: "bob", URL: server.URL}
+	if _, err := bob.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// неудачные поиски результата не отдают и в журнал не попадают
+	if _, err := bob.FindUsers(SearchRequest{Limit: 1, OrderField: "Salary"}); err == nil {
+		t.Fatalf("expected bad order field")
+	}
+
+	var records []AuditRecord
+	if status := adminRequest(t, server, http.MethodGet, "/admin/audit?token=alice", "admin", &records); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record for alice, got %+v", records)
+	}
+	rec := records[0]
+	if rec.Token != auditToken("alice") || rec.Snapshot != resp.Snapshot || !rec.LimitProbe || time.Since(rec.Time) > time.Minute {
+		t.Errorf("wrong record: %+v", rec)
+	}
+	if want := "limit=3&offset=0&order_by=-1&order_field=Id&query=Boyd&state=mississippi"; rec.Params != want {
+		t.Errorf("wrong params %q, want %q", rec.Params, want)
+	}
+	var ids []int
+	for _, user := range resp.Users {
+		ids = append(ids, user.Id)
+	}
+	if len(ids) != 1 || rec.Results != 1 || !reflect.DeepEqual(rec.UserIDs, ids) {
+		t.Errorf("wrong user ids %v, response had %v", rec.UserIDs, ids)
+	}
+
+	if status := adminRequest(t, server, http.MethodGet, "/admin/audit", "admin", &records); status != http.StatusOK || len(records) != 2 {
+		t.Errorf("expected 2 records, got %d: %+v", status, records)
+	}
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if status := adminRequest(t, server, http.MethodGet, "/admin/audit?from="+future, "admin", &records); status != http.StatusOK || len(records) != 0 {
+		t.Errorf("expected no records from the future, got %d: %+v", status, records)
+	}
+	if status := adminRequest(t, server, http.MethodGet, "/admin/audit?token="+auditToken("bob")+"&to="+future, "admin", &records); status != http.StatusOK || len(records) != 1 {
+		t.Errorf("expected 1 record for bob by fingerprint, got %d: %+v", status, records)
+	}
+	for _, c := range []struct {
+		method, path, token string
+		status              int
+	}{
+		{http.MethodGet, "/admin/audit", "alice", http.StatusForbidden},
+		{http.MethodPost, "/admin/audit", "admin", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/audit?from=yesterday", "admin", http.StatusBadRequest},
+		{http.MethodGet, "/admin/audit?limit=-1", "admin", http.StatusBadRequest},
+	} {
+		if status := adminRequest(t, server, c.method, c.path, c.token, nil); status != c.status {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.status, status)
+		}
+	}
+}
+
+// выгрузка, фасеты, запись по id и сохранённый поиск тоже отдают записи и пишутся в журнал
+func TestAuditOtherEndpoints(t *testing.T) {
+	useWriteTokens(t, "alice")
+	useSavedSearches(t)
+	l := useAuditLog(t, 0, 0)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	ctx := context.Background()
+	alice := &SearchClient{AccessToken: "alice", URL: server.URL}
+
+	var exported []int
+	for user, err := range alice.ExportUsers(ctx, "Boyd") {
+		if err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		exported = append(exported, user.Id)
+	}
+	if _, err := alice.Facets(ctx, SearchRequest{State: "mississippi"}); err != nil {
+		t.Fatalf("facets: %v", err)
+	}
+	if _, err := alice.GetPerson(ctx, 3); err != nil {
+		t.Fatalf("get person: %v", err)
+	}
+	saved, err := alice.SaveSearch(ctx, SearchRequest{Query: "Boyd"})
+	if err != nil {
+		t.Fatalf("save search: %v", err)
+	}
+	if _, err := alice.SavedSearch(ctx, saved.ID); err != nil {
+		t.Fatalf("saved search: %v", err)
+	}
+
+	records, err := l.Query(AuditQuery{Token: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var endpoints []string
+	for _, rec := range records {
+		endpoints = append(endpoints, rec.Endpoint)
+	}
+	if want := []string{"export", "facets", "persons", "", "searches"}; !reflect.DeepEqual(endpoints, want) {
+		t.Fatalf("expected endpoints %q, got %q", want, endpoints)
+	}
+	if !reflect.DeepEqual(records[0].UserIDs, exported) || records[0].Params != "query=Boyd" {
+		t.Errorf("wrong export record: %+v, exported %v", records[0], exported)
+	}
+	if records[1].Results == 0 || !strings.Contains(records[1].Params, "state=mississippi") {
+		t.Errorf("wrong facets record: %+v", records[1])
+	}
+	if !reflect.DeepEqual(records[2].UserIDs, []int{3}) || records[2].Params != "id=3" {
+		t.Errorf("wrong persons record: %+v", records[2])
+	}
+	if !reflect.DeepEqual(records[4].UserIDs, saved.UserIDs) || records[4].Params != "id="+saved.ID {
+		t.Errorf("wrong saved search record: %+v", records[4])
+	}
+
+	// повторить через FindUsers можно только поиски
+	plan, err := LoadPlanFromAudit(strings.NewReader(auditLines(t, records)), "")
+	if err != nil || len(plan.Requests) != 1 {
+		t.Errorf("expected 1 replayable search, got %+v, %v", plan, err)
+	}
+}
+
+func auditLines(t *testing.T, records []AuditRecord) string {
+	t.Helper()
+	var b strings.Builder
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// выгрузка большого датасета пишет все id в одну строку длиннее любого буфера bufio.Scanner
+func TestAuditLongRecords(t *testing.T) {
+	l := useAuditLog(t, 0, 0)
+	ids := make([]int, 300000)
+	for i := range ids {
+		ids[i] = i
+	}
+	now := time.Now()
+	records := []AuditRecord{
+		{Time: now, Token: "alice", Endpoint: "export", Params: "limit=0", Results: len(ids), UserIDs: ids},
+		{Time: now, Token: "alice", Params: "limit=2", Results: 1, UserIDs: []int{1}},
+	}
+	for _, rec := range records {
+		if err := l.Write(rec); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	got, err := l.Query(AuditQuery{})
+	if err != nil || len(got) != 2 || len(got[0].UserIDs) != len(ids) {
+		t.Fatalf("wrong records: %d, %v", len(got), err)
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := LoadPlanFromAudit(bytes.NewReader(data), "")
+	if err != nil || len(plan.Requests) != 1 {
+		t.Errorf("wrong plan: %+v, %v", plan, err)
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	useAdminTokens(t, "admin")
+	old := auditLog
+	auditLog = nil
+	t.Cleanup(func() { auditLog = old })
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	if status := adminRequest(t, server, http.MethodGet, "/admin/audit", "admin", nil); status != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", status)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	l := useAuditLog(t, 150, 2)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 6 {
+		rec := AuditRecord{Time: start.Add(time.Duration(i) * time.Minute), Token: auditToken("alice"), Params: "limit=1", UserIDs: []int{i}}
+		if err := l.Write(rec); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	// каждая запись больше половины maxBytes, так что в файле по одной, а хранятся текущий и два старых
+	for _, suffix := range []string{"", ".1", ".2"} {
+		if _, err := os.Stat(l.path + suffix); err != nil {
+			t.Errorf("expected %s: %v", l.path+suffix, err)
+		}
+	}
+	if _, err := os.Stat(l.path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 to be removed, got %v", l.path, err)
+	}
+
+	records, err := l.Query(AuditQuery{Token: "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []int
+	for _, rec := range records {
+		ids = append(ids, rec.UserIDs[0])
+	}
+	if !reflect.DeepEqual(ids, []int{3, 4, 5}) {
+		t.Errorf("expected the three newest records oldest first, got %v", ids)
+	}
+
+	// с limit - самые новые записи, даже если они в разных файлах
+	records, err = l.Query(AuditQuery{From: start.Add(3 * time.Minute), Limit: 2})
+	if err != nil || len(records) != 2 || records[0].UserIDs[0] != 4 || records[1].UserIDs[0] != 5 {
+		t.Errorf("wrong limited query: %+v, %v", records, err)
+	}
+}
@@ -0,0 +1,227 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen - SearchClient не стал отправлять запрос, потому что CircuitBreaker разомкнут
+var ErrCircuitOpen = errors.New("SearchServer circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	defaultBreakerMinRequests  = 5
+	defaultBreakerFailureRatio = 0.5
+	defaultBreakerCooldown     = 5 * time.Second
+	defaultBreakerInterval     = 10 * time.Second
+)
+
+// CircuitBreaker размыкает цепь, когда доля неудачных запросов превышает порог,
+// и пропускает пробные запросы после Cooldown. Неудачей считаются ошибки соединения,
+// таймауты и ответы 5xx, ответы 4xx - нет. Нулевые поля заменяются значениями по умолчанию
+type CircuitBreaker struct {
+	// сколько запросов должно набраться, прежде чем считать долю ошибок
+	MinRequests int
+	// доля ошибок, начиная с которой цепь размыкается
+	FailureRatio float64
+	// через сколько после размыкания пропустить пробный запрос
+	Cooldown time.Duration
+	// сколько подряд успешных пробных запросов замыкают цепь
+	HalfOpenRequests int
+	// как часто обнулять счётчики в замкнутом состоянии, отрицательное - не обнулять.
+	// Без обнуления долгая история успешных запросов не даёт цепи разомкнуться при отказе сервера
+	Interval time.Duration
+	// вызывается после каждой смены состояния
+	OnStateChange func(from, to BreakerState)
+
+	mu          sync.Mutex
+	state       BreakerState
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	probes      int
+	successes   int
+
+	now func() time.Time
+}
+
+func (cb *CircuitBreaker) minRequests() int {
+	if cb.MinRequests > 0 {
+		return cb.MinRequests
+	}
+	return defaultBreakerMinRequests
+}
+
+func (cb *CircuitBreaker) failureRatio() float64 {
+	if cb.FailureRatio > 0 {
+		return cb.FailureRatio
+	}
+	return defaultBreakerFailureRatio
+}
+
+func (cb *CircuitBreaker) cooldown() time.Duration {
+	if cb.Cooldown > 0 {
+		return cb.Cooldown
+	}
+	return defaultBreakerCooldown
+}
+
+func (cb *CircuitBreaker) halfOpenRequests() int {
+	if cb.HalfOpenRequests > 0 {
+		return cb.HalfOpenRequests
+	}
+	return 1
+}
+
+func (cb *CircuitBreaker) interval() time.Duration {
+	if cb.Interval != 0 {
+		return cb.Interval
+	}
+	return defaultBreakerInterval
+}
+
+func (cb *CircuitBreaker) timeNow() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
+
+// State отдаёт текущее состояние, open с истёкшим Cooldown уже считается half-open
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	from, to := cb.refresh()
+	state := cb.state
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return state
+}
+
+// refresh переводит open в half-open по таймеру и обнуляет окно замкнутой цепи, вызывается под mu
+func (cb *CircuitBreaker) refresh() (from, to BreakerState) {
+	now := cb.timeNow()
+	switch cb.state {
+	case BreakerOpen:
+		if now.Sub(cb.openedAt) >= cb.cooldown() {
+			return cb.setState(BreakerHalfOpen)
+		}
+	case BreakerClosed:
+		if interval := cb.interval(); interval > 0 && now.Sub(cb.windowStart) >= interval {
+			cb.requests, cb.failures, cb.windowStart = 0, 0, now
+		}
+	}
+	return cb.state, cb.state
+}
+
+func (cb *CircuitBreaker) setState(state BreakerState) (from, to BreakerState) {
+	from = cb.state
+	cb.state = state
+	cb.requests, cb.failures, cb.probes, cb.successes = 0, 0, 0, 0
+	cb.windowStart = cb.timeNow()
+	if state == BreakerOpen {
+		cb.openedAt = cb.windowStart
+	}
+	return from, state
+}
+
+func (cb *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && cb.OnStateChange != nil {
+		cb.OnStateChange(from, to)
+	}
+}
+
+// allow решает, можно ли отправить запрос прямо сейчас
+func (cb *CircuitBreaker) allow() error {
+	cb.mu.Lock()
+	from, to := cb.refresh()
+	err := error(nil)
+	switch cb.state {
+	case BreakerOpen:
+		err = ErrCircuitOpen
+	case BreakerHalfOpen:
+		if cb.probes >= cb.halfOpenRequests() {
+			err = ErrCircuitOpen
+		} else {
+			cb.probes++
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+	return err
+}
+
+// record учитывает результат запроса, пропущенного allow
+func (cb *CircuitBreaker) record(failed bool) {
+	cb.mu.Lock()
+	from, to := cb.state, cb.state
+	switch cb.state {
+	case BreakerClosed:
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+		if cb.requests >= cb.minRequests() && float64(cb.failures)/float64(cb.requests) >= cb.failureRatio() {
+			from, to = cb.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if failed {
+			from, to = cb.setState(BreakerOpen)
+			break
+		}
+		cb.successes++
+		if cb.successes >= cb.halfOpenRequests() {
+			from, to = cb.setState(BreakerClosed)
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(from, to)
+}
+
+// release возвращает слот пробного запроса, результат которого не учитывается
+func (cb *CircuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == BreakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// do выполняет запрос через автомат. Отмена запроса вызывающим не считается ни успехом, ни неудачей
+func (cb *CircuitBreaker) do(ctx context.Context, do func() (*SearchResponse, int, error)) (*SearchResponse, int, error) {
+	if err := cb.allow(); err != nil {
+		return nil, 0, err
+	}
+
+	result, status, err := do()
+	if err != nil && status == 0 && ctx.Err() != nil {
+		cb.release()
+	} else {
+		cb.record(status == 0 && err != nil || status >= 500)
+	}
+	return result, status, err
+}
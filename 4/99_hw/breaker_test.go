@@ -0,0 +1,140 @@
+package hw4
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if failing.Load() {
+			SearchInternalErrorServer(w, r)
+			return
+		}
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var transitions []string
+	breaker := &CircuitBreaker{
+		MinRequests:  4,
+		FailureRatio: 0.5,
+		Cooldown:     time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+		now: func() time.Time { return now },
+	}
+	client := &SearchClient{URL: server.URL, AccessToken: "123", Breaker: breaker}
+	req := SearchRequest{Limit: 1}
+
+	// 4xx не считаются неудачей
+	for i := 0; i < 5; i++ {
+		client.FindUsers(SearchRequest{OrderField: "Unknown"})
+	}
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("bad requests must not open breaker, state: %s", state)
+	}
+
+	// 5 успешных ответов и 4 ошибки - доля ошибок ещё ниже порога
+	failing.Store(true)
+	for i := 0; i < 4; i++ {
+		client.FindUsers(req)
+	}
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("expected closed below failure ratio, state: %s", state)
+	}
+	client.FindUsers(req)
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("expected open, state: %s", state)
+	}
+
+	hitsBefore := hits.Load()
+	if _, err := client.FindUsers(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if hits.Load() != hitsBefore {
+		t.Errorf("open breaker must not send requests")
+	}
+
+	// после Cooldown пробный запрос снова падает - цепь опять размыкается
+	now = now.Add(time.Minute)
+	if _, err := client.FindUsers(req); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected server error on probe, got %v", err)
+	}
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("failed probe must reopen breaker, state: %s", state)
+	}
+
+	failing.Store(false)
+	now = now.Add(time.Minute)
+	if _, err := client.FindUsers(req); err != nil {
+		t.Errorf("unexpected probe error: %v", err)
+	}
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("successful probe must close breaker, state: %s", state)
+	}
+
+	expected := []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}
+	if !reflect.DeepEqual(transitions, expected) {
+		t.Errorf("wrong transitions: %v, expected: %v", transitions, expected)
+	}
+}
+
+func TestCircuitBreakerHalfOpenLimit(t *testing.T) {
+	now := time.Now()
+	breaker := &CircuitBreaker{MinRequests: 1, now: func() time.Time { return now }}
+	breaker.record(true)
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("expected open, state: %s", state)
+	}
+
+	now = now.Add(defaultBreakerCooldown)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	if err := breaker.allow(); err != ErrCircuitOpen {
+		t.Errorf("expected only one probe in half-open, got %v", err)
+	}
+
+	// отменённый вызывающим пробный запрос освобождает слот
+	breaker.release()
+	if err := breaker.allow(); err != nil {
+		t.Errorf("expected probe slot to be released, got %v", err)
+	}
+}
+
+// долгая история успешных запросов не должна мешать разомкнуть цепь, когда сервер упал
+func TestCircuitBreakerOutageAfterHealthyHistory(t *testing.T) {
+	now := time.Now()
+	breaker := &CircuitBreaker{now: func() time.Time { return now }}
+	for i := 0; i < 1000; i++ {
+		breaker.record(false)
+	}
+
+	now = now.Add(defaultBreakerInterval)
+	for i := 0; i < defaultBreakerMinRequests; i++ {
+		if err := breaker.allow(); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+		breaker.record(true)
+	}
+	if state := breaker.State(); state != BreakerOpen {
+		t.Errorf("expected open after %d failures, state: %s", defaultBreakerMinRequests, state)
+	}
+}
@@ -1,33 +1,62 @@
-package main
+package hw4
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var (
 	errTest = errors.New("testing")
-	client  = &http.Client{Timeout: time.Second}
+	client  = &http.Client{Transport: defaultTransport, Timeout: time.Second}
 )
 
+// причины отказа, которые вызывающий может различить через errors.Is
+var (
+	ErrBadAccessToken = errors.New("Bad AccessToken")
+	ErrServerFatal    = errors.New("SearchServer fatal error")
+	ErrBadRequest     = errors.New("bad request")
+	// сервер не ответил за Timeout клиента
+	ErrTimeout = errors.New("timeout")
+)
+
+// badRequestError - ошибка в параметрах запроса, сообщение своё, но errors.Is(err, ErrBadRequest)
+type badRequestError string
+
+func (e badRequestError) Error() string { return string(e) }
+
+func (e badRequestError) Is(target error) bool { return target == ErrBadRequest }
+
 type User struct {
 	Id     int
 	Name   string
 	Age    int
 	About  string
 	Gender string
+	// приходят, только если запрошены в SearchRequest.Fields, и в том виде,
+	// который разрешает политика токена
+	Email   string `json:",omitempty"`
+	Phone   string `json:",omitempty"`
+	Address string `json:",omitempty"`
+	Balance string `json:",omitempty"`
 }
 
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// версия датасета, по которой искал SearchServer
+	Snapshot string
+	// в каком виде пришли запрошенные дополнительные поля: "email" -> RedactMasked
+	Redaction map[string]RedactionMode
 }
 
 type SearchErrorResponse struct {
@@ -48,6 +77,16 @@ type SearchRequest struct {
 	Query      string // подстрока в 1 из полей
 	OrderField string
 	OrderBy    int
+	// искать по этой версии датасета, пусто - по текущей
+	Snapshot string
+	// registered в [RegisteredFrom, RegisteredTo), нулевое время - без границы
+	RegisteredFrom time.Time
+	RegisteredTo   time.Time
+	// штат и город из address, без учёта регистра
+	State string
+	City  string
+	// дополнительные поля User, которые нужно вернуть
+	Fields ExtraFields
 }
 
 type SearchClient struct {
@@ -55,23 +94,48 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// именованный датасет на SearchServer, пустой - основной. Влияет на FindUsers, Pages и Facets
+	Dataset string
+	// в каком формате просить результат (ContentTypeJSON, ContentTypeNDJSON, ContentTypeCSV, ContentTypeProtobuf), пустой - json
+	Accept string
+	// колбэки вокруг каждого запроса, см. ClientHooks
+	Hooks ClientHooks
+	// если задан - при недоступном SearchServer запросы сразу падают с ErrCircuitOpen
+	Breaker *CircuitBreaker
+	// если задан - запросы идут по списку реплик вместо URL, см. Endpoints
+	Endpoints *Endpoints
+	// если задан - одинаковые одновременные запросы склеиваются в один
+	Group *RequestGroup
+	// через какой клиент ходить, nil - общий с таймаутом в секунду, см. NewHTTPClient.
+	// Выгрузка и подписки берут его транспорт, но не таймаут
+	HTTPClient *http.Client
+	// больше стольких байт ответ не читается, дальше - ErrResponseTooLarge. 0 - DefaultMaxResponseBytes, < 0 - без ограничения.
+	// Выгрузку и подписки не ограничивает, их размер заранее неизвестен
+	MaxResponseBytes int64
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext - FindUsers с контекстом, трасса из ctx (ContextWithTrace) уходит в заголовке traceparent
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+		return nil, badRequestError("limit must be > 0")
 	}
 	if req.Limit > 25 {
 		req.Limit = 25
 	}
 	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+		return nil, badRequestError("offset must be > 0")
 	}
 
+	info := RequestInfo{URL: srv.URL, Request: req, Attempt: 1}
+
 	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
 	req.Limit++
 
@@ -80,44 +144,155 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if req.Snapshot != "" {
+		searcherParams.Add("snapshot", req.Snapshot)
+	}
+	addFilterParams(searcherParams, req)
+	addFieldsParam(searcherParams, req.Fields)
+
+	find := func(ctx context.Context) (*SearchResponse, error) {
+		return srv.send(ctx, info, func(ctx context.Context, endpoint string) (*SearchResponse, int, error) {
+			return srv.doSearch(ctx, endpoint, req, searcherParams)
+		})
+	}
+	if srv.Group != nil {
+		key := coalesceKey{
+			url:              srv.URL,
+			dataset:          srv.Dataset,
+			accessToken:      srv.AccessToken,
+			accept:           srv.Accept,
+			req:              req,
+			maxResponseBytes: srv.MaxResponseBytes,
+			httpClient:       srv.HTTPClient,
+		}
+		if srv.Endpoints != nil {
+			key.url = strings.Join(srv.Endpoints.URLs, " ")
+		}
+		return srv.Group.do(ctx, key, find)
+	}
+	return find(ctx)
+}
+
+// attemptFunc делает одну попытку запроса к конкретному endpoint
+type attemptFunc func(ctx context.Context, endpoint string) (*SearchResponse, int, error)
+
+// send проводит запрос через Breaker и Endpoints, хуки вызываются на каждую попытку
+func (srv *SearchClient) send(ctx context.Context, info RequestInfo, do attemptFunc) (*SearchResponse, error) {
+	attempt := func(ctx context.Context, endpoint string, n int) (*SearchResponse, int, error) {
+		info := info
+		info.URL, info.Attempt = endpoint, n
+		return srv.doWithHooks(ctx, info, func() (*SearchResponse, int, error) {
+			return do(ctx, endpoint)
+		})
+	}
+	run := func() (*SearchResponse, int, error) {
+		if srv.Endpoints != nil {
+			return srv.Endpoints.do(ctx, attempt)
+		}
+		return attempt(ctx, srv.URL, 1)
+	}
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if srv.Breaker == nil {
+		result, _, err := run()
+		return result, err
+	}
+	result, _, err := srv.Breaker.do(ctx, run)
+	if err == ErrCircuitOpen && srv.Hooks != nil {
+		srv.Hooks.OnError(ctx, info, err)
+	}
+	return result, err
+}
+
+// baseURL - куда идти с запросами вне FindUsers
+func (srv *SearchClient) baseURL() string {
+	if srv.Endpoints != nil {
+		if order := srv.Endpoints.order(); len(order) > 0 {
+			return order[0]
+		}
+	}
+	return srv.URL
+}
+
+// datasetURL - адрес ручки датасета клиента: /parts... для основного, /datasets/{name}/parts... для именованного
+func (srv *SearchClient) datasetURL(parts ...string) (string, error) {
+	if srv.Dataset != "" {
+		parts = append([]string{"datasets", srv.Dataset}, parts...)
+	}
+	endpoint, err := url.JoinPath(srv.baseURL(), parts...)
+	if err != nil {
+		return "", fmt.Errorf("bad URL %s: %s", srv.baseURL(), err)
+	}
+	return endpoint, nil
+}
+
+// doSearch делает сам HTTP-запрос, вместе с результатом отдаёт код ответа (0 - если ответа не было)
+func (srv *SearchClient) doSearch(ctx context.Context, endpoint string, req SearchRequest, searcherParams url.Values) (*SearchResponse, int, error) {
+	if srv.Dataset != "" {
+		var err error
+		if endpoint, err = url.JoinPath(endpoint, "datasets", srv.Dataset, "search"); err != nil {
+			return nil, 0, fmt.Errorf("bad URL %s: %s", endpoint, err)
+		}
+	}
+	searcherReq, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?"+searcherParams.Encode(), nil)
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	searcherReq.Header.Add(TraceParentHeader, outgoingTrace(ctx).String())
+	searcherReq.Header.Add(LimitProbeHeader, "1")
+	if srv.Accept != "" {
+		searcherReq.Header.Add("Accept", srv.Accept)
+	}
 
-	resp, err := client.Do(searcherReq)
+	resp, err := srv.httpClient().Do(searcherReq)
 	if err != nil {
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+			return nil, 0, fmt.Errorf("%w for %s", ErrTimeout, searcherParams.Encode())
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return nil, 0, fmt.Errorf("unknown error %s", err)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	defer drainAndClose(resp.Body)
+	body := limitBody(resp, srv.maxResponseBytes())
 
 	switch resp.StatusCode {
 	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
+		return nil, resp.StatusCode, ErrBadAccessToken
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return nil, resp.StatusCode, ErrServerFatal
+	case http.StatusNotAcceptable:
+		return nil, resp.StatusCode, fmt.Errorf("SearchServer cant encode result as %s", srv.Accept)
+	case http.StatusGone:
+		return nil, resp.StatusCode, ErrSnapshotExpired
+	case http.StatusNotFound:
+		return nil, resp.StatusCode, ErrDatasetNotFound
 	case http.StatusBadRequest:
+		errBody, err := io.ReadAll(io.LimitReader(body, maxDrainBytes))
+		if err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("cant read error response: %w", err)
+		}
 		errResp := SearchErrorResponse{}
-		err = json.Unmarshal(body, &errResp)
+		err = json.Unmarshal(errBody, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return nil, resp.StatusCode, fmt.Errorf("cant unpack error json: %s", err)
 		}
 		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+			return nil, resp.StatusCode, badRequestError(fmt.Sprintf("OrderFeld %s invalid", req.OrderField))
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return nil, resp.StatusCode, badRequestError("unknown bad request error: " + errResp.Error)
 	}
 
-	data := []User{}
-	err = json.Unmarshal(body, &data)
+	enc := encodingByContentType(resp.Header.Get("Content-Type"))
+	data, err := enc.decodeFrom(body)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		// тело читается потоком, так что Timeout клиента может сработать и здесь, а не только в Do
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, resp.StatusCode, fmt.Errorf("%w for %s", ErrTimeout, searcherParams.Encode())
+		}
+		return nil, resp.StatusCode, fmt.Errorf("cant unpack result %s: %w", enc.name, err)
 	}
 
-	result := SearchResponse{}
+	result := SearchResponse{
+		Snapshot:  resp.Header.Get(SnapshotHeader),
+		Redaction: parseRedactionHeader(resp.Header.Get(RedactionHeader)),
+	}
 	if len(data) == req.Limit {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
@@ -125,5 +300,5 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		result.Users = data[0:len(data)]
 	}
 
-	return &result, err
+	return &result, resp.StatusCode, err
 }
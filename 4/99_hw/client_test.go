@@ -1,188 +1,14 @@
-package main
+package hw4
 
 import (
-	"encoding/json"
-	"encoding/xml"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"reflect"
-	"slices"
-	"strconv"
-	"strings"
 	"testing"
 	"time"
 )
 
-type Person struct {
-	ID            int    `xml:"id"`
-	Guid          string `xml:"guid"`
-	IsActive      string `xml:"isActive"`
-	Balance       string `xml:"balance"`
-	Picture       string `xml:"picture"`
-	Age           int    `xml:"age"`
-	EyeColor      string `xml:"eyeColor"`
-	FirstName     string `xml:"first_name"`
-	LastName      string `xml:"last_name"`
-	Gender        string `xml:"gender"`
-	Company       string `xml:"company"`
-	Email         string `xml:"email"`
-	Phone         string `xml:"phone"`
-	Address       string `xml:"address"`
-	About         string `xml:"about"`
-	Registered    string `xml:"registered"`
-	FavoriteFruit string `xml:"favoriteFruit"`
-}
-
-type Root struct {
-	XMLName xml.Name `xml:"root"`
-	Persons []Person `xml:"row"`
-}
-
-func SearchServer(w http.ResponseWriter, r *http.Request) {
-
-	authHeader := r.Header.Get("AccessToken")
-	if authHeader == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("AccessToken header is required"))
-		return
-	}
-
-	var sr SearchRequest
-	var err error
-
-	sr.Query = r.FormValue("query")
-	sr.OrderField = r.FormValue("order_field")
-
-	if orderByValue := r.FormValue("order_by"); orderByValue != "" {
-		sr.OrderBy, err = strconv.Atoi(orderByValue)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error": "Invalid order_by value"}`))
-			return
-		}
-	}
-
-	if offsetValue := r.FormValue("offset"); offsetValue != "" {
-		sr.Offset, err = strconv.Atoi(offsetValue)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error": "Invalid offset value"}`))
-			return
-		}
-	}
-
-	if limitValue := r.FormValue("limit"); limitValue != "" {
-		sr.Limit, err = strconv.Atoi(limitValue)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error": "Invalid limit value"}`))
-			return
-		}
-	}
-
-	f, err := os.Open("dataset.xml")
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "Cannot open dataset"}`))
-		return
-	}
-
-	decoder := xml.NewDecoder(f)
-	root := &Root{}
-
-	if err := decoder.Decode(root); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "Cannot decode dataset"}`))
-		return
-	}
-
-	var filteredUsers []User
-	for _, person := range root.Persons {
-		name := person.FirstName + " " + person.LastName
-		if strings.Contains(name, sr.Query) || strings.Contains(person.About, sr.Query) {
-			user := User{
-				Id:     person.ID,
-				Name:   name,
-				Age:    person.Age,
-				About:  strings.TrimSpace(person.About),
-				Gender: person.Gender,
-			}
-			filteredUsers = append(filteredUsers, user)
-		}
-	}
-
-	if sr.OrderBy != OrderByAsIs && sr.OrderBy != OrderByDesc && sr.OrderBy != OrderByAsc {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"error": "Invalid order_by value"}`))
-		return
-	}
-
-	if sr.OrderField != "Id" && sr.OrderField != "Age" && sr.OrderField != "Name" && sr.OrderField != "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"error": "Invalid order_field value"}`))
-		return
-	}
-
-	if sr.OrderBy != OrderByAsIs {
-		slices.SortFunc(filteredUsers, func(a, b User) int {
-			switch sr.OrderField {
-			case "Id":
-				if sr.OrderBy == OrderByAsc {
-					return a.Id - b.Id
-				}
-				if sr.OrderBy == OrderByDesc {
-					return b.Id - a.Id
-				}
-			case "Age":
-				if sr.OrderBy == OrderByAsc {
-					return a.Age - b.Age
-				}
-				if sr.OrderBy == OrderByDesc {
-					return b.Age - a.Age
-				}
-			case "Name", "":
-				if sr.OrderBy == OrderByAsc {
-					return strings.Compare(a.Name, b.Name)
-				}
-				if sr.OrderBy == OrderByDesc {
-					return strings.Compare(b.Name, a.Name)
-				}
-			}
-			return 0
-		})
-	}
-
-	if sr.Offset < 0 || sr.Offset > len(filteredUsers) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"error": "Invalid offset value"}`))
-		return
-	}
-	filteredUsers = filteredUsers[sr.Offset:]
-
-	if sr.Limit < 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"error": "Invalid limit  value"}`))
-		return
-	}
-
-	if sr.Limit != 0 && sr.Limit <= len(filteredUsers) {
-		filteredUsers = filteredUsers[:sr.Limit]
-	}
-
-	jsonPersons, err := json.Marshal(filteredUsers)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"error": "Failed to convert users to json"}`))
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(jsonPersons)
-}
-
 func SearchInternalErrorServer(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Write([]byte(`{"error": "some unknown error"}`))
@@ -642,6 +468,14 @@ func TestSearchServer(t *testing.T) {
 			t.Errorf("[%d] unexpected error text: %v, expected: %v", i, err, c.err)
 		}
 
+		// версия датасета меняется вместе с dataset.xml, проверяем только что она есть
+		if result != nil {
+			if result.Snapshot == "" {
+				t.Errorf("[%d] empty snapshot", i)
+			}
+			result.Snapshot = ""
+		}
+
 		if !reflect.DeepEqual(result, c.sResponse) {
 			t.Errorf("[%d] wrong result:\n %#v\n expected:\n %#v", i, result, c.sResponse)
 		}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"hw4"
+)
+
+func diff(args []string, stdout, stderr io.Writer) int {
+	limits := hw4.NoDiffLimits
+	fs := flag.NewFlagSet("dataset diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.IntVar(&limits.MaxAdded, "max-added", limits.MaxAdded, "код выхода 1, если добавлено больше записей, -1 - без ограничения")
+	fs.IntVar(&limits.MaxRemoved, "max-removed", limits.MaxRemoved, "код выхода 1, если удалено больше записей, -1 - без ограничения")
+	fs.IntVar(&limits.MaxModified, "max-modified", limits.MaxModified, "код выхода 1, если изменено больше записей, -1 - без ограничения")
+	asJSON := fs.Bool("json", false, "вывести изменения в JSON")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(stderr, "dataset diff: want old.xml and new.xml")
+		return exitUsage
+	}
+
+	var datasets [2]*hw4.Dataset
+	for i, path := range fs.Args() {
+		ds, err := hw4.LoadDataset(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "dataset diff: %s: %s\n", path, err)
+			return exitUsage
+		}
+		datasets[i] = ds
+	}
+	d := hw4.DiffDatasets(datasets[0].Persons, datasets[1].Persons)
+	summary := d.Summary()
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			hw4.DatasetDiff
+			Summary hw4.DiffSummary `json:"summary"`
+		}{d, summary})
+	} else {
+		for _, p := range d.Added {
+			fmt.Fprintf(stdout, "+ %d %s\n", p.ID, p.Name())
+		}
+		for _, p := range d.Removed {
+			fmt.Fprintf(stdout, "- %d %s\n", p.ID, p.Name())
+		}
+		for _, change := range d.Modified {
+			fmt.Fprintf(stdout, "~ %d %s\n", change.ID, change.Guid)
+			for _, field := range change.Changes {
+				fmt.Fprintf(stdout, "    %s: %q -> %q\n", field.Field, field.Old, field.New)
+			}
+		}
+		fmt.Fprintln(stdout, summary)
+	}
+
+	if err := summary.Check(limits); err != nil {
+		fmt.Fprintln(stderr, "dataset diff:", err)
+		return exitFailed
+	}
+	return exitOK
+}
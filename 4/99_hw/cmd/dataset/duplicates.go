@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"hw4"
+)
+
+func duplicates(args []string, stdout, stderr io.Writer) int {
+	rules := hw4.DefaultDuplicateRules
+	fs := flag.NewFlagSet("dataset duplicates", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.BoolVar(&rules.Email, "email", rules.Email, "дубли по одинаковому email")
+	fs.BoolVar(&rules.Phone, "phone", rules.Phone, "дубли по одинаковому телефону")
+	fs.Float64Var(&rules.NameSimilarity, "name-similarity", rules.NameSimilarity, "насколько похожи имена в одной компании, от 0 до 1, 0 - не сравнивать")
+	fs.Float64Var(&rules.MinConfidence, "min-confidence", rules.MinConfidence, "не показывать кластеры с меньшей уверенностью")
+	asJSON := fs.Bool("json", false, "вывести кластеры в JSON")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	path, ok := datasetArg(fs, stderr)
+	if !ok {
+		return exitUsage
+	}
+
+	ds, err := hw4.LoadDataset(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "dataset duplicates: %s: %s\n", path, err)
+		return exitUsage
+	}
+	clusters := hw4.FindDuplicates(ds.Persons, rules)
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(clusters)
+		return exitOK
+	}
+
+	// для того, кто будет сливать записи: кто в кластере и что у них общего
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for i, cluster := range clusters {
+		fmt.Fprintf(tw, "cluster %d\tconfidence %.3f\n", i+1, cluster.Confidence)
+		for _, row := range cluster.Rows {
+			p := ds.Persons[row]
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%s\t%s\n", p.ID, p.Name(), p.Company, p.Email, p.Phone)
+		}
+		for _, m := range cluster.Matches {
+			fmt.Fprintf(tw, "  %d ~ %d\t%.3f\t%s\n", m.A, m.B, m.Confidence, strings.Join(m.Reasons, ", "))
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(stdout, "%s: %d rows, %d clusters\n", path, len(ds.Persons), len(clusters))
+	return exitOK
+}
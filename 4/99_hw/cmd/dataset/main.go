@@ -0,0 +1,119 @@
+// dataset - инструменты для dataset.xml.
+//
+//	dataset lint [-strict] [-o clean.xml] [dataset.xml]
+//	dataset duplicates [-email=false] [-phone=false] [-name-similarity 0.85] [-min-confidence 0.5] [-json] [dataset.xml]
+//	dataset diff [-max-added N] [-max-removed N] [-max-modified N] [-json] old.xml new.xml
+//
+// lint печатает все проблемы с номерами строк и id записей. Код выхода 1, если есть ошибки,
+// а с -strict - и предупреждения; 2 - файл не читается или не разбирается как XML.
+// С -o пишет чистый датасет: без записей с ошибками, с обрезанными пробелами, по возрастанию id.
+//
+// duplicates печатает кластеры вероятных дублей с уверенностью и причинами, см. hw4.FindDuplicates.
+//
+// diff показывает добавленные, удалённые и изменённые записи с полями, см. hw4.DiffDatasets.
+// Код выхода 1, если изменений больше лимитов, так что им можно проверять новую выгрузку перед загрузкой
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"hw4"
+)
+
+const (
+	exitOK = iota
+	exitFailed
+	exitUsage
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+var commands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"lint":       lint,
+	"duplicates": duplicates,
+	"diff":       diff,
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintln(stderr, "usage: dataset lint|duplicates|diff [flags] [dataset.xml]")
+		return exitUsage
+	}
+	return commands[args[0]](args[1:], stdout, stderr)
+}
+
+// datasetArg - путь к датасету из аргументов после флагов, по умолчанию dataset.xml
+func datasetArg(fs *flag.FlagSet, stderr io.Writer) (string, bool) {
+	switch fs.NArg() {
+	case 0:
+		return "dataset.xml", true
+	case 1:
+		return fs.Arg(0), true
+	}
+	fmt.Fprintf(stderr, "%s: want one dataset file\n", fs.Name())
+	return "", false
+}
+
+func lint(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("dataset lint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	strict := fs.Bool("strict", false, "проваливать проверку и на предупреждениях, для CI")
+	output := fs.String("o", "", "куда записать чистый датасет")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	path, ok := datasetArg(fs, stderr)
+	if !ok {
+		return exitUsage
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(stderr, "dataset lint:", err)
+		return exitUsage
+	}
+	defer f.Close()
+	report, err := hw4.LintDataset(f)
+	if err != nil {
+		fmt.Fprintf(stderr, "dataset lint: %s: %s\n", path, err)
+		return exitUsage
+	}
+
+	errorsCount, warnings := 0, 0
+	for _, issue := range report.Issues {
+		if issue.Severity == hw4.LintError {
+			errorsCount++
+		} else {
+			warnings++
+		}
+		// путь:строка в начале, как у компилятора, чтобы редакторы и CI делали ссылки
+		fmt.Fprintf(stdout, "%s:%d: row %s: %s: %s: %s\n", path, issue.Line, issue.RowID, issue.Field, issue.Severity, issue.Message)
+	}
+	fmt.Fprintf(stdout, "%s: %d rows, %d errors, %d warnings\n", path, report.Rows, errorsCount, warnings)
+
+	if *output != "" {
+		data, err := report.Normalized()
+		if err == nil {
+			err = os.WriteFile(*output, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "dataset lint:", err)
+			return exitUsage
+		}
+		fmt.Fprintf(stdout, "%s: %d clean rows written\n", *output, len(report.Clean))
+	}
+
+	if report.Failed(*strict) {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hw4"
+)
+
+// row - запись dataset.xml, поля из override заменяют значения по умолчанию
+func row(id int, override map[string]string) string {
+	fields := [][2]string{
+		{"id", fmt.Sprint(id)},
+		{"guid", fmt.Sprintf("00000000-0000-0000-0000-%012d", id)},
+		{"isActive", "true"},
+		{"balance", "$1,000.00"},
+		{"age", "30"},
+		{"first_name", fmt.Sprintf("Person%d", id)},
+		{"last_name", "Wolf"},
+		{"gender", "male"},
+		{"company", fmt.Sprintf("COMPANY%d", id)},
+		{"email", fmt.Sprintf("person%d@example.com", id)},
+		{"phone", fmt.Sprintf("+1 (900) 000-%04d", id)},
+		{"address", "586 Winthrop Street, Edneyville, Mississippi, 9555"},
+		{"registered", "2017-02-05T06:23:27 -03:00"},
+	}
+	b := &strings.Builder{}
+	b.WriteString("  <row>\n")
+	for _, f := range fields {
+		value := f[1]
+		if v, ok := override[f[0]]; ok {
+			value = v
+		}
+		fmt.Fprintf(b, "    <%s>%s</%s>\n", f[0], value, f[0])
+	}
+	b.WriteString("  </row>\n")
+	return b.String()
+}
+
+func writeDataset(t *testing.T, dir, name string, rows ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data := `<?xml version="1.0" encoding="UTF-8" ?>` + "\n<root>\n" + strings.Join(rows, "") + "</root>\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	clean := writeDataset(t, dir, "clean.xml", row(0, nil), row(1, nil))
+	warning := writeDataset(t, dir, "warning.xml", row(0, map[string]string{"email": ""}))
+	broken := writeDataset(t, dir, "broken.xml", row(0, nil), row(1, map[string]string{"age": "x"}))
+	notXML := writeDataset(t, dir, "not.xml", "<row>")
+	next := writeDataset(t, dir, "next.xml", row(0, nil), row(1, map[string]string{"age": "31"}), row(2, nil))
+	dups := writeDataset(t, dir, "dups.xml", row(0, nil), row(1, map[string]string{"email": "person0@example.com"}), row(2, nil))
+	missing := filepath.Join(dir, "missing.xml")
+
+	cases := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout []string
+	}{
+		{"no command", nil, exitUsage, nil},
+		{"unknown command", []string{"merge"}, exitUsage, nil},
+
+		{"lint clean", []string{"lint", clean}, exitOK, []string{clean + ": 2 rows, 0 errors, 0 warnings\n"}},
+		{"lint warning", []string{"lint", warning}, exitOK, []string{warning + ":13: row 0: email: warning: email is empty\n", "0 errors, 1 warnings"}},
+		{"lint strict warning", []string{"lint", "-strict", warning}, exitFailed, []string{"0 errors, 1 warnings"}},
+		{"lint error", []string{"lint", broken}, exitFailed, []string{`row 1: age: error: age "x" is not a number`, "2 rows, 1 errors"}},
+		{"lint missing file", []string{"lint", missing}, exitUsage, nil},
+		{"lint not xml", []string{"lint", notXML}, exitUsage, nil},
+		{"lint two files", []string{"lint", clean, broken}, exitUsage, nil},
+		{"lint bad flag", []string{"lint", "-unknown", clean}, exitUsage, nil},
+		{"lint help", []string{"lint", "-h"}, exitOK, nil},
+
+		{"diff", []string{"diff", clean, next}, exitOK, []string{"+ 2 Person2 Wolf\n", "~ 1 ", `    age: "30" -> "31"`, "1 added, 0 removed, 1 modified, 1 unchanged\n"}},
+		{"diff within limits", []string{"diff", "-max-added", "1", "-max-modified", "1", clean, next}, exitOK, nil},
+		{"diff over limits", []string{"diff", "-max-added", "0", clean, next}, exitFailed, []string{"1 added"}},
+		{"diff same", []string{"diff", clean, clean}, exitOK, []string{"0 added, 0 removed, 0 modified, 2 unchanged\n"}},
+		{"diff one file", []string{"diff", clean}, exitUsage, nil},
+		{"diff missing file", []string{"diff", clean, missing}, exitUsage, nil},
+
+		{"duplicates", []string{"duplicates", dups}, exitOK, []string{"cluster 1", "same email", dups + ": 3 rows, 1 clusters\n"}},
+		{"duplicates without email", []string{"duplicates", "-email=false", dups}, exitOK, []string{dups + ": 3 rows, 0 clusters\n"}},
+		{"duplicates none", []string{"duplicates", clean}, exitOK, []string{"2 rows, 0 clusters"}},
+		{"duplicates missing file", []string{"duplicates", missing}, exitUsage, nil},
+		{"duplicates bad flag", []string{"duplicates", "-name-similarity", "x", dups}, exitUsage, nil},
+	}
+	for _, c := range cases {
+		var stdout, stderr bytes.Buffer
+		if code := run(c.args, &stdout, &stderr); code != c.code {
+			t.Errorf("[%s] expected exit %d, got %d: %s%s", c.name, c.code, code, stdout.String(), stderr.String())
+			continue
+		}
+		for _, want := range c.stdout {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("[%s] output miss %q:\n%s", c.name, want, stdout.String())
+			}
+		}
+	}
+}
+
+func TestLintWritesCleanDataset(t *testing.T) {
+	dir := t.TempDir()
+	path := writeDataset(t, dir, "dataset.xml", row(2, map[string]string{"first_name": " Boyd "}), row(1, map[string]string{"age": "x"}), row(0, nil))
+	output := filepath.Join(dir, "clean.xml")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"lint", "-o", output, path}, &stdout, &stderr); code != exitFailed {
+		t.Fatalf("expected exit %d, got %d: %s", exitFailed, code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), output+": 2 clean rows written\n") {
+		t.Errorf("wrong output:\n%s", stdout.String())
+	}
+
+	ds, err := hw4.LoadDataset(output)
+	if err != nil {
+		t.Fatalf("cant load clean dataset: %v", err)
+	}
+	if len(ds.Persons) != 2 || ds.Persons[0].ID != 0 || ds.Persons[1].ID != 2 || ds.Persons[1].FirstName != "Boyd" {
+		t.Errorf("wrong clean dataset: %+v", ds.Persons)
+	}
+
+	// чистый датасет проходит lint без ошибок
+	stdout.Reset()
+	if code := run([]string{"lint", output}, &stdout, &stderr); code != exitOK {
+		t.Errorf("clean dataset fails lint:\n%s", stdout.String())
+	}
+}
+
+func TestDuplicatesJSON(t *testing.T) {
+	dir := t.TempDir()
+	path := writeDataset(t, dir, "dataset.xml", row(0, nil), row(1, map[string]string{"phone": "+1 (900) 000-0000"}))
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"duplicates", "-json", path}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	var clusters []hw4.DuplicateCluster
+	if err := json.Unmarshal(stdout.Bytes(), &clusters); err != nil {
+		t.Fatalf("bad json %q: %v", stdout.String(), err)
+	}
+	if len(clusters) != 1 || len(clusters[0].IDs) != 2 {
+		t.Errorf("wrong clusters: %+v", clusters)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"hw4"
+)
+
+func compare(args []string, stdout, stderr io.Writer) int {
+	fs := flagSet("loadgen compare", stderr)
+	maxRegression := fs.Float64("max-regression", 10, "на сколько процентов метрика может стать хуже, чтобы код выхода был 0")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(stderr, "loadgen compare: want base.json and next.json")
+		return exitUsage
+	}
+
+	var reports [2]*hw4.LoadReport
+	for i, path := range fs.Args() {
+		report, err := readReport(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "loadgen compare: %s: %s\n", path, err)
+			return exitUsage
+		}
+		reports[i] = report
+	}
+
+	code := exitOK
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "metric\t%s\t%s\tworse by\t\n", reportName(reports[0], "base"), reportName(reports[1], "next"))
+	for _, d := range hw4.CompareLoadReports(reports[0], reports[1]) {
+		mark := ""
+		if d.Regression > *maxRegression {
+			mark = "REGRESSION"
+			code = exitFailed
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%+.1f%%\t%s\n", d.Metric, d.Base, d.Next, d.Regression, mark)
+	}
+	tw.Flush()
+	return code
+}
+
+func reportName(r *hw4.LoadReport, fallback string) string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return fallback
+}
@@ -0,0 +1,75 @@
+// loadgen - нагрузка на SearchServer для планирования мощностей.
+//
+//	loadgen run -url http://localhost:8080 -token T [-qps 50] [-duration 10s | -requests N] [-concurrency 64]
+//	            [-replay audit.jsonl | -mix mix.json] [-seed 1] [-label build] [-o report.json] [-json]
+//	loadgen compare [-max-regression 10] base.json next.json
+//
+// run шлёт поиски с заданным QPS, не дожидаясь ответов, и печатает перцентили задержки,
+// пропускную способность и ошибки по классам, см. hw4.RunLoad. Запросы берутся из журнала
+// аудита (-replay, по кругу в записанном порядке), из синтетической смеси с весами (-mix,
+// [{"weight": 3, "params": "query=Boyd&limit=10"}]) или из встроенной смеси. Одинаковый -seed
+// даёт одинаковую последовательность запросов. С -o отчёт пишется в JSON для compare.
+// Ctrl-C останавливает нагрузку, отчёт строится по уже отправленным запросам.
+//
+// compare сравнивает два отчёта. Код выхода 1, если задержка или доля ошибок выросли,
+// а пропускная способность упала больше чем на -max-regression процентов
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"hw4"
+)
+
+const (
+	exitOK = iota
+	exitFailed
+	exitUsage
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+var commands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"run":     runLoad,
+	"compare": compare,
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintln(stderr, "usage: loadgen run|compare [flags]")
+		return exitUsage
+	}
+	return commands[args[0]](args[1:], stdout, stderr)
+}
+
+func flagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// parseFlags - fs.Parse с кодом выхода: -h - успех, прочие ошибки - неверные флаги
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+func readReport(path string) (*hw4.LoadReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return hw4.ReadLoadReport(f)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"hw4"
+	"hw4/searchtest"
+)
+
+func TestRunAndCompare(t *testing.T) {
+	srv := searchtest.NewServer(hw4.User{Id: 0, Name: "Boyd Wolf", Age: 22}, hw4.User{Id: 1, Name: "Hilda Mayer", Age: 21})
+	defer srv.Close()
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.json")
+	mix := filepath.Join(dir, "mix.json")
+	if err := os.WriteFile(mix, []byte(`[{"weight": 3, "params": "query=Boyd&limit=5"}, {"weight": 1, "params": "offset=100"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"run", "-url", srv.URL, "-token", "t", "-qps", "500", "-requests", "20", "-mix", mix, "-seed", "7", "-label", "v1", "-o", base}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "requests:   20 sent") || !strings.Contains(stdout.String(), "errors:     bad_request") {
+		t.Errorf("wrong report:\n%s", stdout.String())
+	}
+
+	report, err := readReport(base)
+	if err != nil {
+		t.Fatalf("cant read report: %v", err)
+	}
+	if report.Label != "v1" || report.Requests != 20 || report.Succeeded+report.Errors[hw4.LoadBadRequest] != 20 {
+		t.Errorf("wrong saved report: %+v", report)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"compare", base, base}, &stdout, &stderr); code != exitOK {
+		t.Errorf("expected no regression against itself, exit %d:\n%s", code, stdout.String())
+	}
+
+	report.Label = "v2"
+	report.Latency.P99 = report.Latency.P99*2 + time.Millisecond
+	next := filepath.Join(dir, "next.json")
+	f, err := os.Create(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report.WriteJSON(f)
+	f.Close()
+
+	stdout.Reset()
+	if code := run([]string{"compare", base, next}, &stdout, &stderr); code != exitFailed {
+		t.Errorf("expected regression exit, got %d:\n%s", code, stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "REGRESSION") || !strings.Contains(stdout.String(), "v2") {
+		t.Errorf("wrong compare output:\n%s", stdout.String())
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"run"},
+		{"run", "-url", "http://localhost", "-replay", "a", "-mix", "b"},
+		{"compare", "one.json"},
+	} {
+		var stdout, stderr bytes.Buffer
+		if code := run(args, &stdout, &stderr); code != exitUsage {
+			t.Errorf("%v: expected usage exit, got %d", args, code)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"hw4"
+)
+
+// defaultMix - смесь, если не задан ни -replay, ни -mix: в основном короткие поиски по подстроке,
+// немного сортировок, фильтров и глубокой пагинации
+const defaultMix = `[
+	{"weight": 40, "params": "query=Boyd&limit=10"},
+	{"weight": 20, "params": "query=nisi&limit=25&order_field=Age&order_by=1"},
+	{"weight": 15, "params": "limit=25&order_field=Name&order_by=-1"},
+	{"weight": 10, "params": "state=mississippi&limit=10"},
+	{"weight": 10, "params": "query=a&limit=25&offset=10"},
+	{"weight": 5, "params": "query=zzz&limit=1"}
+]`
+
+func runLoad(args []string, stdout, stderr io.Writer) int {
+	fs := flagSet("loadgen run", stderr)
+	url := fs.String("url", "", "адрес SearchServer")
+	token := fs.String("token", "", "токен доступа")
+	dataset := fs.String("dataset", "", "именованный датасет, пустой - основной")
+	h2c := fs.Bool("h2c", false, "ходить по HTTP/2 без TLS, сервер должен быть запущен с -h2c")
+	timeout := fs.Duration("timeout", time.Second, "таймаут одного запроса")
+	qps := fs.Float64("qps", 50, "сколько запросов в секунду запускать")
+	duration := fs.Duration("duration", 10*time.Second, "сколько длится нагрузка, если не задан -requests")
+	requests := fs.Int("requests", 0, "сколько всего запросов отправить")
+	concurrency := fs.Int("concurrency", 64, "не больше стольких запросов одновременно")
+	replay := fs.String("replay", "", "журнал аудита, поиски из которого повторить")
+	mix := fs.String("mix", "", "файл синтетической смеси запросов с весами")
+	seed := fs.Uint64("seed", 1, "зерно выбора запросов из смеси")
+	label := fs.String("label", "", "подпись прогона в отчёте, например версия сборки")
+	output := fs.String("o", "", "куда записать отчёт в JSON")
+	asJSON := fs.Bool("json", false, "напечатать отчёт в JSON")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *url == "" || (*replay != "" && *mix != "") || fs.NArg() != 0 {
+		fmt.Fprintln(stderr, "loadgen run: want -url and at most one of -replay and -mix")
+		return exitUsage
+	}
+	if *requests == 0 {
+		*requests = int(*qps * duration.Seconds())
+	}
+
+	plan, err := loadPlan(*replay, *mix, *dataset)
+	if err != nil {
+		fmt.Fprintln(stderr, "loadgen run:", err)
+		return exitUsage
+	}
+	client := &hw4.SearchClient{
+		URL:         *url,
+		AccessToken: *token,
+		Dataset:     *dataset,
+		HTTPClient: hw4.NewHTTPClient(hw4.TransportConfig{
+			H2C:                 *h2c,
+			MaxIdleConnsPerHost: *concurrency,
+			Timeout:             *timeout,
+		}),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cfg := hw4.LoadConfig{QPS: *qps, Requests: *requests, Concurrency: *concurrency, Seed: *seed, Label: *label}
+	report, err := hw4.RunLoad(ctx, client, plan, cfg)
+	if err != nil {
+		fmt.Fprintln(stderr, "loadgen run:", err)
+		return exitUsage
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err == nil {
+			err = report.WriteJSON(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "loadgen run:", err)
+			return exitFailed
+		}
+	}
+	if *asJSON {
+		report.WriteJSON(stdout)
+	} else {
+		printReport(stdout, report)
+	}
+	return exitOK
+}
+
+func loadPlan(replay, mix, dataset string) (hw4.LoadPlan, error) {
+	switch {
+	case replay != "":
+		f, err := os.Open(replay)
+		if err != nil {
+			return hw4.LoadPlan{}, err
+		}
+		defer f.Close()
+		return hw4.LoadPlanFromAudit(f, dataset)
+	case mix != "":
+		f, err := os.Open(mix)
+		if err != nil {
+			return hw4.LoadPlan{}, err
+		}
+		defer f.Close()
+		return hw4.LoadPlanFromMix(f)
+	}
+	return hw4.LoadPlanFromMix(strings.NewReader(defaultMix))
+}
+
+func printReport(w io.Writer, r *hw4.LoadReport) {
+	if r.Label != "" {
+		fmt.Fprintf(w, "label:      %s\n", r.Label)
+	}
+	fmt.Fprintf(w, "requests:   %d sent, %d ok in %s\n", r.Requests, r.Succeeded, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput: %.1f/s (target %.1f/s)\n", r.Throughput, r.TargetQPS)
+	l := r.Latency
+	fmt.Fprintf(w, "latency:    min %s, mean %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n",
+		l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+	for _, class := range slices.Sorted(maps.Keys(r.Errors)) {
+		fmt.Fprintf(w, "errors:     %s %d\n", class, r.Errors[class])
+	}
+}
@@ -0,0 +1,291 @@
+// searchctl ищет пользователей в SearchServer из командной строки.
+// Токен берётся из -token, переменной SEARCHCTL_TOKEN или файла конфига
+// (~/.config/searchctl/config, строки вида "token = ...", "url = ...", "dataset = ...").
+//
+// Коды выхода: 0 - успех, 1 - прочие ошибки (сеть, таймаут), 2 - неверные флаги,
+// 3 - токен не подошёл, 4 - SearchServer отклонил запрос, 5 - ошибка на стороне SearchServer
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hw4"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitAuth
+	exitBadRequest
+	exitServer
+)
+
+var orders = map[string]int{"asc": hw4.OrderByAsc, "desc": hw4.OrderByDesc, "asis": hw4.OrderByAsIs}
+
+// options - всё, что нужно для одного запуска, после разбора флагов, окружения и конфига
+type options struct {
+	client hw4.SearchClient
+	req    hw4.SearchRequest
+	all    bool
+	format string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Getenv, os.Stdout, os.Stderr))
+}
+
+func run(args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	opts, err := parseArgs(args, getenv, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintln(stderr, "searchctl:", err)
+		return exitUsage
+	}
+
+	users, err := search(context.Background(), opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "searchctl:", err)
+		return exitCode(err)
+	}
+	if err := printUsers(stdout, opts.format, opts.req.Fields, users); err != nil {
+		fmt.Fprintln(stderr, "searchctl:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func parseArgs(args []string, getenv func(string) string, stderr io.Writer) (*options, error) {
+	fs := flag.NewFlagSet("searchctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	configPath := fs.String("config", defaultConfigPath(getenv), "файл конфига с url, token и dataset")
+	url := fs.String("url", "", "адрес SearchServer, по умолчанию SEARCHCTL_URL, конфиг или http://localhost:8080")
+	token := fs.String("token", "", "AccessToken, по умолчанию SEARCHCTL_TOKEN или конфиг")
+	dataset := fs.String("dataset", "", "именованный датасет на SearchServer")
+	query := fs.String("query", "", "подстрока в Name или About")
+	orderField := fs.String("order-field", "", "поле сортировки, пустое - Name")
+	order := fs.String("order", "asis", "направление сортировки: asc, desc, asis")
+	limit := fs.Int("limit", 25, "сколько пользователей вернуть, с -all - размер страницы")
+	offset := fs.Int("offset", 0, "сколько пользователей пропустить")
+	state := fs.String("state", "", "штат из адреса")
+	city := fs.String("city", "", "город из адреса")
+	from := fs.String("registered-from", "", "зарегистрированы не раньше, 2006-01-02 или RFC3339")
+	to := fs.String("registered-to", "", "зарегистрированы раньше, 2006-01-02 или RFC3339")
+	fields := fs.String("fields", "", "дополнительные поля через запятую: email,phone,address,balance")
+	all := fs.Bool("all", false, "пройти все страницы выдачи")
+	format := fs.String("format", "table", "формат вывода: table, json, csv")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	config, err := readConfig(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	opts := &options{
+		client: hw4.SearchClient{
+			URL:         firstNonEmpty(*url, getenv("SEARCHCTL_URL"), config["url"], "http://localhost:8080"),
+			AccessToken: firstNonEmpty(*token, getenv("SEARCHCTL_TOKEN"), config["token"]),
+			Dataset:     firstNonEmpty(*dataset, config["dataset"]),
+		},
+		req: hw4.SearchRequest{
+			Limit:      *limit,
+			Offset:     *offset,
+			Query:      *query,
+			OrderField: *orderField,
+			State:      *state,
+			City:       *city,
+		},
+		all:    *all,
+		format: *format,
+	}
+
+	var ok bool
+	if opts.req.OrderBy, ok = orders[*order]; !ok {
+		return nil, fmt.Errorf("unknown order %q, want asc, desc or asis", *order)
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return nil, fmt.Errorf("unknown format %q, want table, json or csv", *format)
+	}
+	if opts.req.Fields, err = hw4.ParseExtraFields(*fields); err != nil {
+		return nil, fmt.Errorf("unknown fields %q", *fields)
+	}
+	if opts.req.RegisteredFrom, err = parseDate(*from); err != nil {
+		return nil, fmt.Errorf("bad -registered-from: %w", err)
+	}
+	if opts.req.RegisteredTo, err = parseDate(*to); err != nil {
+		return nil, fmt.Errorf("bad -registered-to: %w", err)
+	}
+	return opts, nil
+}
+
+func defaultConfigPath(getenv func(string) string) string {
+	if path := getenv("SEARCHCTL_CONFIG"); path != "" {
+		return path
+	}
+	if home := getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "searchctl", "config")
+	}
+	return ""
+}
+
+// readConfig читает строки "ключ = значение", пустые строки и # игнорируются. Отсутствие файла - не ошибка
+func readConfig(path string) (map[string]string, error) {
+	config := map[string]string{}
+	if path == "" {
+		return config, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, line)
+		}
+		config[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return config, scanner.Err()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func search(ctx context.Context, opts *options) ([]hw4.User, error) {
+	if !opts.all {
+		resp, err := opts.client.FindUsersContext(ctx, opts.req)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Users, nil
+	}
+	var users []hw4.User
+	for resp, err := range opts.client.Pages(ctx, opts.req) {
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, resp.Users...)
+	}
+	return users, nil
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, hw4.ErrBadAccessToken):
+		return exitAuth
+	case errors.Is(err, hw4.ErrBadRequest), errors.Is(err, hw4.ErrDatasetNotFound), errors.Is(err, hw4.ErrSnapshotExpired):
+		return exitBadRequest
+	case errors.Is(err, hw4.ErrServerFatal), errors.Is(err, hw4.ErrCircuitOpen):
+		return exitServer
+	}
+	return exitError
+}
+
+// columns - колонки вывода: пять основных и запрошенные дополнительные
+func columns(fields hw4.ExtraFields) ([]string, func(hw4.User) []string) {
+	names := []string{"Id", "Name", "Age", "Gender", "About"}
+	extra := []struct {
+		field hw4.ExtraFields
+		name  string
+		value func(hw4.User) string
+	}{
+		{hw4.FieldEmail, "Email", func(u hw4.User) string { return u.Email }},
+		{hw4.FieldPhone, "Phone", func(u hw4.User) string { return u.Phone }},
+		{hw4.FieldAddress, "Address", func(u hw4.User) string { return u.Address }},
+		{hw4.FieldBalance, "Balance", func(u hw4.User) string { return u.Balance }},
+	}
+	var values []func(hw4.User) string
+	for _, column := range extra {
+		if fields&column.field != 0 {
+			names = append(names, column.name)
+			values = append(values, column.value)
+		}
+	}
+	return names, func(u hw4.User) []string {
+		row := []string{strconv.Itoa(u.Id), u.Name, strconv.Itoa(u.Age), u.Gender, strings.TrimSpace(u.About)}
+		for _, value := range values {
+			row = append(row, value(u))
+		}
+		return row
+	}
+}
+
+func printUsers(w io.Writer, format string, fields hw4.ExtraFields, users []hw4.User) error {
+	if format == "json" {
+		if users == nil {
+			users = []hw4.User{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	}
+
+	names, row := columns(fields)
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write(names)
+		for _, u := range users {
+			cw.Write(row(u))
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(names, "\t")))
+	for _, u := range users {
+		values := row(u)
+		// в таблице About только началом, целиком он есть в json и csv
+		if about := []rune(values[4]); len(about) > 40 {
+			values[4] = string(about[:40]) + "…"
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
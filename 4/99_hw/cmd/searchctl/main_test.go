@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hw4"
+	"hw4/searchtest"
+)
+
+var users = []hw4.User{
+	{Id: 0, Name: "Boyd Wolf", Age: 22, About: "Nulla cillum", Gender: "male"},
+	{Id: 1, Name: "Hilda Mayer", Age: 21, About: "Sit commodo", Gender: "female"},
+	{Id: 2, Name: "Brooks Aguilar", Age: 25, About: "Velit ullamco", Gender: "male"},
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func TestRunFormats(t *testing.T) {
+	srv := searchtest.NewServer(users...)
+	defer srv.Close()
+	getenv := env(map[string]string{"SEARCHCTL_URL": srv.URL, "SEARCHCTL_TOKEN": "token"})
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-order-field", "Age", "-order", "asc", "-limit", "1", "-all", "-format", "json"}, getenv, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	var got []hw4.User
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("bad json %q: %v", stdout.String(), err)
+	}
+	if len(got) != 3 || got[0].Id != 1 || got[2].Id != 2 {
+		t.Errorf("wrong users: %+v", got)
+	}
+	srv.AssertRequestCount(t, 3)
+	srv.AssertParam(t, 0, "order_by", "-1")
+
+	stdout.Reset()
+	if code := run([]string{"-query", "Hilda", "-format", "csv"}, getenv, &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	if want := "Id,Name,Age,Gender,About\n1,Hilda Mayer,21,female,Sit commodo\n"; stdout.String() != want {
+		t.Errorf("wrong csv:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-query", "Boyd"}, getenv, &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], "Boyd Wolf") {
+		t.Errorf("wrong table:\n%s", stdout.String())
+	}
+}
+
+func TestRunConfig(t *testing.T) {
+	srv := searchtest.NewServer(users...)
+	defer srv.Close()
+	srv.RequireToken("from-config")
+
+	config := filepath.Join(t.TempDir(), "config")
+	data := "# searchctl\nurl = " + srv.URL + "\ntoken = from-config\n"
+	if err := os.WriteFile(config, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-config", config}, env(nil), &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit %d: %s", code, stderr.String())
+	}
+	// переменная окружения главнее конфига, флаг главнее переменной
+	getenv := env(map[string]string{"SEARCHCTL_TOKEN": "from-env"})
+	if code := run([]string{"-config", config}, getenv, &stdout, &stderr); code != exitAuth {
+		t.Errorf("env token: want exit %d, got %d", exitAuth, code)
+	}
+	if code := run([]string{"-config", config, "-token", "from-config"}, getenv, &stdout, &stderr); code != exitOK {
+		t.Errorf("flag token: want exit %d, got %d: %s", exitOK, code, stderr.String())
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	srv := searchtest.NewServer(users...)
+	defer srv.Close()
+	getenv := env(map[string]string{"SEARCHCTL_URL": srv.URL, "SEARCHCTL_TOKEN": "token"})
+
+	cases := []struct {
+		name  string
+		fault *searchtest.Fault
+		args  []string
+		code  int
+	}{
+		{name: "ok", code: exitOK},
+		{name: "unknown flag", args: []string{"-nope"}, code: exitUsage},
+		{name: "bad order", args: []string{"-order", "up"}, code: exitUsage},
+		{name: "bad format", args: []string{"-format", "xml"}, code: exitUsage},
+		{name: "bad date", args: []string{"-registered-from", "yesterday"}, code: exitUsage},
+		{name: "negative limit", args: []string{"-limit", "-1"}, code: exitBadRequest},
+		{name: "auth", fault: ptr(searchtest.Unauthorized()), code: exitAuth},
+		{name: "bad request", fault: ptr(searchtest.BadRequest("Invalid query")), code: exitBadRequest},
+		{name: "bad order field", args: []string{"-order-field", "About"}, code: exitBadRequest},
+		{name: "server", fault: ptr(searchtest.InternalError()), code: exitServer},
+		{name: "broken json", fault: ptr(searchtest.BadJSON()), code: exitError},
+	}
+	for _, c := range cases {
+		if c.fault != nil {
+			srv.FailNext(*c.fault)
+		}
+		var stdout, stderr bytes.Buffer
+		if code := run(c.args, getenv, &stdout, &stderr); code != c.code {
+			t.Errorf("%s: want exit %d, got %d: %s", c.name, c.code, code, stderr.String())
+		}
+		srv.Heal()
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
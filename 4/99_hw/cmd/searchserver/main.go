@@ -0,0 +1,131 @@
+// searchserver поднимает SearchServer с датасетом из файла.
+// По SIGTERM или SIGINT перестаёт быть ready и дожидается запросов в полёте
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"hw4"
+)
+
+func splitTokens(tokens string) []string {
+	if tokens == "" {
+		return nil
+	}
+	return strings.Split(tokens, ",")
+}
+
+// datasetsFlag собирает повторяемый -named-dataset name=path:token1,token2
+type datasetsFlag []hw4.DatasetConfig
+
+func (d *datasetsFlag) String() string { return fmt.Sprint(len(*d)) }
+
+func (d *datasetsFlag) Set(value string) error {
+	name, rest, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("want name=path:tokens, got %q", value)
+	}
+	path, tokens, _ := strings.Cut(rest, ":")
+	*d = append(*d, hw4.DatasetConfig{Name: name, Path: path, Tokens: splitTokens(tokens)})
+	return nil
+}
+
+// policiesFlag собирает повторяемый -redaction token=email:full,phone:hashed.
+// Поля, которых нет в списке, этому токену не отдаются
+type policiesFlag map[string]hw4.RedactionPolicy
+
+func (p policiesFlag) String() string { return fmt.Sprint(len(p)) }
+
+func (p policiesFlag) Set(value string) error {
+	token, fields, ok := strings.Cut(value, "=")
+	if !ok || token == "" {
+		return fmt.Errorf("want token=field:mode,..., got %q", value)
+	}
+	policy := hw4.RedactionPolicy{}
+	for _, field := range splitTokens(fields) {
+		name, mode, ok := strings.Cut(field, ":")
+		if _, err := hw4.ParseExtraFields(name); !ok || name == "" || err != nil {
+			return fmt.Errorf("want field:mode, got %q", field)
+		}
+		switch mode := hw4.RedactionMode(mode); mode {
+		case hw4.RedactFull, hw4.RedactMasked, hw4.RedactHashed, hw4.RedactOmitted:
+			policy[name] = mode
+		default:
+			return fmt.Errorf("unknown redaction mode %q", mode)
+		}
+	}
+	p[token] = policy
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "адрес, на котором слушать")
+	dataset := flag.String("dataset", "dataset.xml", "путь к dataset.xml")
+	tokens := flag.String("tokens", "", "токены основного датасета через запятую, пусто - все, кроме токенов -named-dataset")
+	writeTokens := flag.String("write-tokens", "", "токены с правом записи через запятую")
+	adminTokens := flag.String("admin-tokens", "", "токены с доступом к /admin через запятую")
+	var datasets datasetsFlag
+	flag.Var(&datasets, "named-dataset", "именованный датасет name=path:token1,token2, можно повторять")
+	policies := policiesFlag{}
+	flag.Var(policies, "redaction", "политика персональных полей для токена token=email:full,phone:hashed, можно повторять")
+	redactionKeyFile := flag.String("redaction-key-file", "", "файл с ключом HMAC для режима hashed, пустой - случайный ключ до перезапуска")
+	auditLog := flag.String("audit-log", "", "файл журнала аудита поисков, пустой - не писать")
+	auditMaxBytes := flag.Int64("audit-max-bytes", 100<<20, "размер, после которого журнал аудита ротируется")
+	auditMaxFiles := flag.Int("audit-max-files", 10, "сколько старых файлов журнала аудита хранить")
+	reloadLimits := hw4.NoDiffLimits
+	flag.IntVar(&reloadLimits.MaxAdded, "reload-max-added", -1, "не подхватывать новый датасет сам, если в нём добавлено больше записей")
+	flag.IntVar(&reloadLimits.MaxRemoved, "reload-max-removed", -1, "не подхватывать новый датасет сам, если в нём удалено больше записей")
+	flag.IntVar(&reloadLimits.MaxModified, "reload-max-modified", -1, "не подхватывать новый датасет сам, если в нём изменено больше записей")
+	h2c := flag.Bool("h2c", false, "принимать HTTP/2 без TLS рядом с HTTP/1.1")
+	drainDelay := flag.Duration("drain-delay", 0, "сколько после сигнала отвечать 503 на /readyz, прежде чем перестать принимать соединения")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "сколько ждать запросы в полёте при остановке")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("SearchServer listening on %s", ln.Addr())
+
+	cfg := hw4.ServerConfig{
+		DatasetPath:       *dataset,
+		DefaultTokens:     splitTokens(*tokens),
+		WriteTokens:       splitTokens(*writeTokens),
+		AdminTokens:       splitTokens(*adminTokens),
+		Datasets:          datasets,
+		RedactionPolicies: policies,
+		AuditLogPath:      *auditLog,
+		AuditMaxBytes:     *auditMaxBytes,
+		AuditMaxFiles:     *auditMaxFiles,
+		H2C:               *h2c,
+		DrainDelay:        *drainDelay,
+		ShutdownTimeout:   *shutdownTimeout,
+	}
+	if *redactionKeyFile != "" {
+		key, err := os.ReadFile(*redactionKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.RedactionKey = bytes.TrimSpace(key)
+	}
+	if reloadLimits != hw4.NoDiffLimits {
+		cfg.ReloadLimits = &reloadLimits
+	}
+	if err := hw4.Serve(ctx, ln, cfg); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("SearchServer stopped")
+}
@@ -0,0 +1,98 @@
+package hw4
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type coalesceKey struct {
+	url         string
+	dataset     string
+	accessToken string
+	accept      string
+	req         SearchRequest
+	// у клиентов с разными лимитами и транспортом ответы могут различаться
+	maxResponseBytes int64
+	httpClient       *http.Client
+}
+
+type coalescedCall struct {
+	done   chan struct{}
+	result *SearchResponse
+	err    error
+	// сколько вызывающих ещё ждут результата, под RequestGroup.mu
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// RequestGroup склеивает одинаковые одновременные вызовы FindUsers в один HTTP-запрос:
+// пока запрос в полёте, остальные вызовы с тем же SearchRequest ждут его результата.
+// Один RequestGroup можно разделять между несколькими SearchClient
+type RequestGroup struct {
+	mu    sync.Mutex
+	calls map[coalesceKey]*coalescedCall
+
+	coalesced atomic.Int64
+}
+
+// Coalesced - сколько вызовов получили результат чужого запроса вместо своего
+func (g *RequestGroup) Coalesced() int64 {
+	return g.coalesced.Load()
+}
+
+// do выполняет fn один раз на ключ. Каждый ждущий сам прекращает ждать по своему ctx,
+// запрос отменяется, только когда ушли все ждущие
+func (g *RequestGroup) do(ctx context.Context, key coalesceKey, fn func(ctx context.Context) (*SearchResponse, error)) (*SearchResponse, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[coalesceKey]*coalescedCall{}
+	}
+	call, inFlight := g.calls[key]
+	if inFlight {
+		g.coalesced.Add(1)
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.result, call.err = fn(callCtx)
+			cancel()
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// новые вызовы не должны присоединиться к отменённому запросу
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if call.result == nil {
+		return nil, call.err
+	}
+	// у каждого своя копия, чтобы вызывающие не портили друг другу Users и Redaction
+	result := *call.result
+	result.Users = slices.Clone(call.result.Users)
+	result.Redaction = maps.Clone(call.result.Redaction)
+	return &result, call.err
+}
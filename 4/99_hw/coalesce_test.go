@@ -0,0 +1,224 @@
+package hw4
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestGroupCoalesce(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+
+	group := &RequestGroup{}
+	client := &SearchClient{URL: server.URL, AccessToken: "123", Group: group}
+	req := SearchRequest{Limit: 3, Query: "nisi"}
+
+	const callers = 10
+	results := make([]*SearchResponse, callers)
+	errs := make([]error, callers)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = client.FindUsers(req)
+		}(i)
+	}
+
+	// ушедший по своему контексту не мешает остальным
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error, 1)
+	go func() {
+		_, err := client.FindUsersContext(ctx, req)
+		cancelled <- err
+	}()
+
+	for group.Coalesced() < callers {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if hits.Load() != 1 {
+		t.Errorf("expected 1 HTTP call, got %d", hits.Load())
+	}
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, errs[i])
+		}
+		if !reflect.DeepEqual(results[i], results[0]) {
+			t.Errorf("[%d] different result: %#v", i, results[i])
+		}
+	}
+	results[0].Users[0].Name = "changed"
+	if results[1].Users[0].Name == "changed" {
+		t.Errorf("callers must not share Users")
+	}
+
+	// после завершения запроса следующий вызов снова идёт на сервер
+	if _, err := client.FindUsers(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if hits.Load() != 3 || group.Coalesced() != callers {
+		t.Errorf("expected 3 HTTP calls and %d coalesced, got %d and %d", callers, hits.Load(), group.Coalesced())
+	}
+}
+
+// зависший запрос отменяется, когда ушли все, кто его ждал
+func TestRequestGroupCancelsAbandonedCall(t *testing.T) {
+	aborted, stop := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(aborted)
+		case <-stop:
+		}
+	}))
+	defer server.Close()
+	defer close(stop)
+
+	group := &RequestGroup{}
+	client := &SearchClient{URL: server.URL, AccessToken: "123", Group: group, HTTPClient: NewHTTPClient(TransportConfig{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.FindUsersContext(ctx, SearchRequest{})
+			errs <- err
+		}()
+	}
+	for group.Coalesced() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatalf("abandoned request was not cancelled")
+	}
+}
+
+func TestRequestGroupSharesError(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		SearchInternalErrorServer(w, r)
+	}))
+	defer server.Close()
+
+	group := &RequestGroup{}
+	client := &SearchClient{URL: server.URL, AccessToken: "123", Group: group}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.FindUsers(SearchRequest{})
+			errs <- err
+		}()
+	}
+	for group.Coalesced() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil || err.Error() != "SearchServer fatal error" {
+			t.Errorf("expected shared server error, got %v", err)
+		}
+	}
+	if hits.Load() != 1 {
+		t.Errorf("expected 1 HTTP call, got %d", hits.Load())
+	}
+}
+
+func TestRequestGroupCopiesResult(t *testing.T) {
+	group := &RequestGroup{}
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (*SearchResponse, error) {
+		<-release
+		return &SearchResponse{Users: []User{{Id: 1}}, Redaction: map[string]RedactionMode{"email": RedactMasked}}, nil
+	}
+
+	results := make([]*SearchResponse, 2)
+	wg := &sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = group.do(context.Background(), coalesceKey{}, fn)
+		}()
+	}
+	for group.Coalesced() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	results[0].Redaction["email"] = RedactFull
+	results[0].Users[0].Id = 2
+	if results[1].Redaction["email"] != RedactMasked || results[1].Users[0].Id != 1 {
+		t.Errorf("callers must not share results: %#v", results[1])
+	}
+}
+
+func TestRequestGroupKeyIncludesLimits(t *testing.T) {
+	var hits atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		SearchServer(w, r)
+	}))
+	defer server.Close()
+
+	group := &RequestGroup{}
+	clients := []*SearchClient{
+		{URL: server.URL, AccessToken: "123", Group: group},
+		{URL: server.URL, AccessToken: "123", Group: group, MaxResponseBytes: 1 << 10},
+		{URL: server.URL, AccessToken: "123", Group: group, HTTPClient: NewHTTPClient(TransportConfig{Timeout: time.Second})},
+	}
+	wg := &sync.WaitGroup{}
+	for _, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.FindUsers(SearchRequest{Limit: 3})
+		}()
+	}
+	for hits.Load() < int32(len(clients)) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if group.Coalesced() != 0 {
+		t.Errorf("clients with different limits must not be coalesced, got %d", group.Coalesced())
+	}
+}
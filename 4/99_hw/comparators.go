@@ -0,0 +1,149 @@
+package hw4
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Comparator сравнивает две записи для order_field: < 0, если при OrderByAsc a идёт раньше b
+type Comparator func(a, b Person) int
+
+var (
+	comparatorsMu sync.RWMutex
+	// userComparators - поля, по которым SearchServer умеет сортировать (order_field)
+	userComparators = map[string]Comparator{
+		"Id":            func(a, b Person) int { return cmp.Compare(a.ID, b.ID) },
+		"Guid":          func(a, b Person) int { return strings.Compare(a.Guid, b.Guid) },
+		"IsActive":      compareBy(isActive, compareBool),
+		"Balance":       compareBy(Person.BalanceCents, cmp.Compare[int64]),
+		"Picture":       func(a, b Person) int { return strings.Compare(a.Picture, b.Picture) },
+		"Age":           func(a, b Person) int { return cmp.Compare(a.Age, b.Age) },
+		"EyeColor":      func(a, b Person) int { return strings.Compare(a.EyeColor, b.EyeColor) },
+		"Name":          func(a, b Person) int { return strings.Compare(a.Name(), b.Name()) },
+		"FirstName":     func(a, b Person) int { return strings.Compare(a.FirstName, b.FirstName) },
+		"LastName":      func(a, b Person) int { return strings.Compare(a.LastName, b.LastName) },
+		"Gender":        func(a, b Person) int { return strings.Compare(a.Gender, b.Gender) },
+		"Company":       func(a, b Person) int { return strings.Compare(a.Company, b.Company) },
+		"Email":         func(a, b Person) int { return strings.Compare(a.Email, b.Email) },
+		"Phone":         func(a, b Person) int { return strings.Compare(a.Phone, b.Phone) },
+		"Address":       func(a, b Person) int { return strings.Compare(a.Address, b.Address) },
+		"City":          compareBy(city, strings.Compare),
+		"State":         compareBy(state, strings.Compare),
+		"About":         func(a, b Person) int { return strings.Compare(a.About, b.About) },
+		"Registered":    compareBy(registeredAt, time.Time.Compare),
+		"FavoriteFruit": func(a, b Person) int { return strings.Compare(a.FavoriteFruit, b.FavoriteFruit) },
+	}
+	// recordComparators - те же сортировки по полям, которые датасет разбирает при загрузке (record),
+	// чтобы не разбирать строки на каждом сравнении. RegisterComparator с тем же именем их отменяет
+	recordComparators = map[string]func(a, b *record) int{
+		"Balance":    compareBy(func(r *record) (int64, bool) { return r.BalanceCents, r.hasBalance }, cmp.Compare[int64]),
+		"City":       compareBy(func(r *record) (string, bool) { return r.City, r.City != "" }, strings.Compare),
+		"State":      compareBy(func(r *record) (string, bool) { return r.State, r.State != "" }, strings.Compare),
+		"Registered": compareBy(func(r *record) (time.Time, bool) { return r.Registered, !r.Registered.IsZero() }, time.Time.Compare),
+	}
+)
+
+// RegisterComparator добавляет или заменяет сортировку, которую можно выбрать через order_field.
+// Индексы строятся при загрузке датасета, поэтому регистрировать лучше до старта сервера:
+// уже загруженный датасет сортирует по новому полю на каждый запрос, пока его не перечитают
+func RegisterComparator(name string, compare Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+	userComparators[name] = compare
+	delete(recordComparators, name)
+}
+
+func comparator(name string) (Comparator, bool) {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	compare, ok := userComparators[name]
+	return compare, ok
+}
+
+// recordComparator - сравнение по разобранным полям записи, если для name оно есть
+func recordComparator(name string) (func(a, b *record) int, bool) {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	compare, ok := recordComparators[name]
+	return compare, ok
+}
+
+// comparatorNames - все зарегистрированные order_field по алфавиту
+func comparatorNames() []string {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	return slices.Sorted(maps.Keys(userComparators))
+}
+
+// compareBy сравнивает по значению из key. Записи, где значение не разобралось, идут первыми
+func compareBy[E, T any](key func(E) (T, bool), compare func(a, b T) int) func(a, b E) int {
+	return func(a, b E) int {
+		av, aok := key(a)
+		bv, bok := key(b)
+		switch {
+		case !aok || !bok:
+			return compareBool(aok, bok)
+		default:
+			return compare(av, bv)
+		}
+	}
+}
+
+// false идёт раньше true
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isActive(p Person) (bool, bool) {
+	active, err := strconv.ParseBool(p.IsActive)
+	return active, err == nil
+}
+
+func city(p Person) (string, bool) {
+	city, _ := p.Location()
+	return city, city != ""
+}
+
+func state(p Person) (string, bool) {
+	_, state := p.Location()
+	return state, state != ""
+}
+
+func registeredAt(p Person) (time.Time, bool) {
+	t, err := p.RegisteredAt()
+	return t, err == nil
+}
+
+// BalanceCents разбирает balance вида "$2,144.93" в центы
+func (p Person) BalanceCents() (int64, bool) {
+	balance := strings.ReplaceAll(strings.TrimPrefix(p.Balance, "$"), ",", "")
+	whole, frac, _ := strings.Cut(balance, ".")
+	if len(frac) > 2 {
+		return 0, false
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	dollars, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	cents, err := strconv.ParseUint(frac, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if strings.HasPrefix(whole, "-") {
+		return dollars*100 - int64(cents), true
+	}
+	return dollars*100 + int64(cents), true
+}
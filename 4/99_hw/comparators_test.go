@@ -0,0 +1,114 @@
+package hw4
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestBalanceCents(t *testing.T) {
+	cases := []struct {
+		balance string
+		cents   int64
+		ok      bool
+	}{
+		{"$2,144.93", 214493, true},
+		{"$1,000.5", 100050, true},
+		{"$12", 1200, true},
+		{"$-3.20", -320, true},
+		{"", 0, false},
+		{"$1.234", 0, false},
+		{"$1,0x0.00", 0, false},
+	}
+	for _, c := range cases {
+		cents, ok := (Person{Balance: c.balance}).BalanceCents()
+		if cents != c.cents || ok != c.ok {
+			t.Errorf("%q: expected %d, %v, got %d, %v", c.balance, c.cents, c.ok, cents, ok)
+		}
+	}
+}
+
+func TestBuiltinComparators(t *testing.T) {
+	ds := NewDataset([]Person{
+		{ID: 0, Balance: "$999.99", IsActive: "true", Registered: "2015-01-01T10:00:00 -03:00"},
+		{ID: 1, Balance: "$1,000.50", IsActive: "false", Registered: "2015-01-01T12:00:00 +03:00"},
+		{ID: 2, Balance: "broken", IsActive: "", Registered: "broken"},
+		{ID: 3, Balance: "$2.00", IsActive: "true", Registered: "2014-12-31T23:00:00 -03:00"},
+	})
+	ids := func(users []User) []int {
+		result := []int{}
+		for _, user := range users {
+			result = append(result, user.Id)
+		}
+		return result
+	}
+
+	cases := map[string][]int{
+		// по числу, а не по строке: $999.99 меньше $1,000.50
+		"Balance": {2, 3, 0, 1},
+		// неразобранное, потом false, потом true
+		"IsActive": {2, 1, 0, 3},
+		// с учётом смещения: 12:00 +03:00 раньше 10:00 -03:00
+		"Registered": {2, 3, 1, 0},
+	}
+	for field, want := range cases {
+		if got := ids(ds.Search(SearchRequest{OrderField: field, OrderBy: OrderByAsc})); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", field, want, got)
+		}
+	}
+}
+
+func TestRegisterComparator(t *testing.T) {
+	ds := NewDataset([]Person{
+		{ID: 0, IsActive: "false", Age: 30},
+		{ID: 1, IsActive: "true", Age: 40},
+		{ID: 2, IsActive: "true", Age: 20},
+	})
+	t.Cleanup(func() {
+		comparatorsMu.Lock()
+		delete(userComparators, "ActiveFirst")
+		comparatorsMu.Unlock()
+	})
+
+	// активные первыми, внутри - по возрасту
+	RegisterComparator("ActiveFirst", func(a, b Person) int {
+		if c := -compareBy(isActive, compareBool)(a, b); c != 0 {
+			return c
+		}
+		return a.Age - b.Age
+	})
+	if _, err := parseSearchParams(map[string][]string{"order_field": {"ActiveFirst"}}); err != nil {
+		t.Fatalf("registered comparator is not accepted: %v", err)
+	}
+
+	want := []int{2, 1, 0}
+	sr := SearchRequest{OrderField: "ActiveFirst", OrderBy: OrderByAsc}
+	for name, ds := range map[string]*Dataset{"loaded before": ds, "loaded after": NewDataset(ds.Persons)} {
+		got := []int{}
+		for _, user := range ds.Search(sr) {
+			got = append(got, user.Id)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s registration: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+// сортировки по разобранным полям записи должны совпадать со сравнением Person
+func TestRecordComparatorsMatchPersons(t *testing.T) {
+	ds, err := LoadDataset("dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %v", err)
+	}
+	for field := range recordComparators {
+		compare, _ := comparator(field)
+		want := make([]int, len(ds.Persons))
+		for i := range want {
+			want[i] = i
+		}
+		slices.SortStableFunc(want, func(a, b int) int { return compare(ds.Persons[a], ds.Persons[b]) })
+		if got := ds.sorted[field]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: record order differs from Person order", field)
+		}
+	}
+}
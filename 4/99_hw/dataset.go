@@ -0,0 +1,416 @@
+package hw4
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errDatasetOpen   = errors.New("Cannot open dataset")
+	errDatasetDecode = errors.New("Cannot decode dataset")
+	errDatasetWrite  = errors.New("Cannot write dataset")
+
+	ErrSnapshotExpired = errors.New("dataset snapshot expired")
+)
+
+// record - запись датасета в том виде, в каком по ней ищут и сортируют
+type record struct {
+	User
+	// нулевое, если registered не разобрался
+	Registered  time.Time
+	City, State string
+	// balance в центах, hasBalance - false, если balance не разобрался
+	BalanceCents int64
+	hasBalance   bool
+}
+
+// пустой order_field сортирует по Name
+const defaultOrderField = "Name"
+
+// Dataset - загруженный в память dataset.xml вместе с индексами для сортировки
+type Dataset struct {
+	Persons []Person
+	records []record
+	// позиции в records, отсортированные по возрастанию поля, при равенстве - в порядке датасета
+	sorted map[string][]int
+
+	// Snapshot - версия датасета, хэш содержимого файла
+	Snapshot       string
+	LoadedAt       time.Time
+	IndexBuildTime time.Duration
+
+	// когда версия перестала быть текущей, для старых снимков
+	supersededAt time.Time
+	// номер версии в datasetStore, растёт с каждой заменой
+	generation uint64
+}
+
+func NewDataset(persons []Person) *Dataset {
+	started := time.Now()
+	ds := &Dataset{
+		Persons: persons,
+		records: make([]record, len(persons)),
+		sorted:  map[string][]int{},
+	}
+	for i, person := range persons {
+		rec := record{User: person.User()}
+		rec.Registered, _ = person.RegisteredAt()
+		rec.City, rec.State = person.Location()
+		rec.BalanceCents, rec.hasBalance = person.BalanceCents()
+		ds.records[i] = rec
+	}
+
+	for _, field := range comparatorNames() {
+		ds.sorted[field] = ds.sortPositions(field)
+	}
+
+	ds.LoadedAt = time.Now()
+	ds.IndexBuildTime = ds.LoadedAt.Sub(started)
+	return ds
+}
+
+// sortPositions - позиции записей по возрастанию поля field, при равенстве - в порядке датасета
+func (ds *Dataset) sortPositions(field string) []int {
+	positions := make([]int, len(ds.Persons))
+	for i := range positions {
+		positions[i] = i
+	}
+	if compare, ok := recordComparator(field); ok {
+		slices.SortStableFunc(positions, func(a, b int) int {
+			return compare(&ds.records[a], &ds.records[b])
+		})
+		return positions
+	}
+	compare, _ := comparator(field)
+	slices.SortStableFunc(positions, func(a, b int) int {
+		return compare(ds.Persons[a], ds.Persons[b])
+	})
+	return positions
+}
+
+func LoadDataset(path string) (*Dataset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errDatasetOpen
+	}
+	return decodeDataset(data)
+}
+
+// decodeDataset разбирает содержимое dataset.xml
+func decodeDataset(data []byte) (*Dataset, error) {
+	root := &Root{}
+	if err := xml.Unmarshal(data, root); err != nil {
+		return nil, errDatasetDecode
+	}
+	ds := NewDataset(root.Persons)
+	ds.Snapshot = snapshotID(data)
+	return ds, nil
+}
+
+func snapshotID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func (ds *Dataset) Len() int {
+	return len(ds.records)
+}
+
+// Search возвращает пользователей, подходящих под фильтры sr, в нужном порядке.
+// Limit и Offset не учитываются, OrderField и OrderBy должны быть уже проверены
+func (ds *Dataset) Search(sr SearchRequest) []User {
+	var result []User
+	for _, i := range ds.search(sr) {
+		result = append(result, ds.records[i].User)
+	}
+	return result
+}
+
+// search - то же, что Search, но отдаёт позиции записей в датасете
+func (ds *Dataset) search(sr SearchRequest) []int {
+	orderField := sr.OrderField
+	if orderField == "" {
+		orderField = defaultOrderField
+	}
+
+	var result []int
+	add := func(i int) {
+		if ds.matches(i, sr) {
+			result = append(result, i)
+		}
+	}
+
+	positions, ok := ds.sorted[orderField]
+	if !ok && sr.OrderBy != OrderByAsIs {
+		// сортировку зарегистрировали после загрузки датасета
+		positions = ds.sortPositions(orderField)
+	}
+
+	switch sr.OrderBy {
+	case OrderByAsc:
+		for _, i := range positions {
+			add(i)
+		}
+	case OrderByDesc:
+		for _, i := range slices.Backward(positions) {
+			add(i)
+		}
+	default:
+		for i := range ds.records {
+			add(i)
+		}
+	}
+	return result
+}
+
+// matches проверяет i-ю запись по query, штату, городу и диапазону registered.
+// Записи без даты регистрации под диапазон не попадают
+func (ds *Dataset) matches(i int, sr SearchRequest) bool {
+	rec := &ds.records[i]
+	switch {
+	case !ds.Persons[i].Matches(sr.Query):
+		return false
+	case sr.State != "" && !strings.EqualFold(rec.State, sr.State):
+		return false
+	case sr.City != "" && !strings.EqualFold(rec.City, sr.City):
+		return false
+	case !sr.RegisteredFrom.IsZero() && (rec.Registered.IsZero() || rec.Registered.Before(sr.RegisteredFrom)):
+		return false
+	case !sr.RegisteredTo.IsZero() && (rec.Registered.IsZero() || !rec.Registered.Before(sr.RegisteredTo)):
+		return false
+	}
+	return true
+}
+
+const (
+	// сколько прошлых версий датасета держать для постраничного обхода и как долго
+	keepSnapshots = 5
+	snapshotTTL   = 10 * time.Minute
+)
+
+// datasetStore держит последний загруженный датасет и перечитывает файл, когда тот поменялся.
+// Несколько прошлых версий остаются доступны по Snapshot, чтобы страницы одного обхода
+// не разъезжались при перезагрузке
+type datasetStore struct {
+	// имя для метрик, у основного датасета - пустое
+	name string
+	path string
+	// если заданы, поменявшийся файл подхватывается сам, только если изменений не больше,
+	// иначе остаётся текущая версия до явного reload
+	limits *DiffLimits
+
+	mu         sync.Mutex
+	ds         *Dataset
+	modTime    time.Time
+	previous   []*Dataset
+	generation uint64
+	// mtime файла, который отказались подхватывать из-за limits
+	rejectedModTime time.Time
+}
+
+var defaultDatasets = &datasetStore{path: "dataset.xml"}
+
+// replace делает ds текущей версией, вызывается под mu
+func (s *datasetStore) replace(ds *Dataset, modTime time.Time) {
+	if s.ds != nil && s.ds.Snapshot != ds.Snapshot {
+		s.ds.supersededAt = time.Now()
+		s.previous = append(s.previous, s.ds)
+		if len(s.previous) > keepSnapshots {
+			s.previous = s.previous[len(s.previous)-keepSnapshots:]
+		}
+	}
+	s.generation++
+	ds.generation = s.generation
+	s.ds, s.modTime = ds, modTime
+	defaultMetrics.observeDataset(s.name, ds)
+	go savedSearches.reevaluate(s, ds)
+}
+
+// Snapshot отдаёт версию датасета по её id, ErrSnapshotExpired - если её уже не осталось
+func (s *datasetStore) Snapshot(id string) (*Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ds, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	if ds.Snapshot == id {
+		return ds, nil
+	}
+	for _, prev := range s.previous {
+		if prev.Snapshot == id && time.Since(prev.supersededAt) < snapshotTTL {
+			return prev, nil
+		}
+	}
+	return nil, ErrSnapshotExpired
+}
+
+func (s *datasetStore) Get() (*Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get()
+}
+
+// get - Get под уже взятым mu
+func (s *datasetStore) get() (*Dataset, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, errDatasetOpen
+	}
+
+	if s.ds != nil && (info.ModTime().Equal(s.modTime) || info.ModTime().Equal(s.rejectedModTime)) {
+		return s.ds, nil
+	}
+
+	ds, err := LoadDataset(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if s.ds != nil && s.limits != nil {
+		if err := DiffDatasets(s.ds.Persons, ds.Persons).Summary().Check(*s.limits); err != nil {
+			accessLog.Warn("dataset reload rejected", "path", s.path, "error", err.Error())
+			s.rejectedModTime = info.ModTime()
+			return s.ds, nil
+		}
+	}
+	s.replace(ds, info.ModTime())
+	return ds, nil
+}
+
+// current - загруженная версия без проверки файла, грузит только если ещё ничего нет
+func (s *datasetStore) current() (*Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ds != nil {
+		return s.ds, nil
+	}
+	return s.get()
+}
+
+// reload перечитывает файл датасета, даже если mtime не поменялся или его отказались подхватывать из-за limits.
+// Если задан check, он видит текущую и новую версии и может отказаться от замены ошибкой
+func (s *datasetStore) reload(check func(cur, next *Dataset) error) (*Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, errDatasetOpen
+	}
+	ds, err := LoadDataset(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if check != nil && s.ds != nil {
+		if err := check(s.ds, ds); err != nil {
+			return nil, err
+		}
+	}
+	s.replace(ds, info.ModTime())
+	return ds, nil
+}
+
+// reindex заново строит индексы текущей версии, не перечитывая файл
+func (s *datasetStore) reindex() (*Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ds, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	fresh := NewDataset(ds.Persons)
+	fresh.Snapshot = ds.Snapshot
+	s.replace(fresh, s.modTime)
+	return fresh, nil
+}
+
+// snapshots - id прошлых версий, которые ещё можно запросить, от старых к новым
+func (s *datasetStore) snapshots() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := []string{}
+	for _, prev := range s.previous {
+		if time.Since(prev.supersededAt) < snapshotTTL {
+			ids = append(ids, prev.Snapshot)
+		}
+	}
+	return ids
+}
+
+// update меняет записи через fn, перестраивает индексы и атомарно переписывает файл датасета
+func (s *datasetStore) update(fn func(persons []Person) ([]Person, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ds, err := s.get()
+	if err != nil {
+		return err
+	}
+	persons, err := fn(slices.Clone(ds.Persons))
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeDataset(persons)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errDatasetWrite, err)
+	}
+	if err := writeDatasetFile(s.path, data); err != nil {
+		return fmt.Errorf("%w: %s", errDatasetWrite, err)
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return errDatasetOpen
+	}
+	ds = NewDataset(persons)
+	ds.Snapshot = snapshotID(data)
+	s.replace(ds, info.ModTime())
+	return nil
+}
+
+func encodeDataset(persons []Person) ([]byte, error) {
+	buf := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(Root{Persons: persons}); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// writeDatasetFile пишет датасет во временный файл рядом и переименовывает его поверх path,
+// так что читатели видят либо старую, либо новую версию целиком
+func writeDatasetFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,66 @@
+package hw4
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadDatasetErrors(t *testing.T) {
+	broken := filepath.Join(t.TempDir(), "broken.xml")
+	os.WriteFile(broken, []byte("<root><row><id>x</id></row></root>"), 0644)
+
+	cases := []struct {
+		path string
+		err  error
+	}{
+		{path: filepath.Join(t.TempDir(), "missing.xml"), err: errDatasetOpen},
+		{path: broken, err: errDatasetDecode},
+	}
+
+	for i, c := range cases {
+		if _, err := LoadDataset(c.path); err != c.err {
+			t.Errorf("[%d] expected error %v, got %v", i, c.err, err)
+		}
+	}
+}
+
+func TestDatasetSearch(t *testing.T) {
+	ds := NewDataset([]Person{
+		{ID: 2, FirstName: "Boyd", LastName: "Wolf", Age: 30, About: "one"},
+		{ID: 0, FirstName: "Anna", LastName: "Lee", Age: 22, About: "two"},
+		{ID: 1, FirstName: "Carl", LastName: "Moe", Age: 30, About: "one two"},
+	})
+
+	ids := func(users []User) []int {
+		result := []int{}
+		for _, user := range users {
+			result = append(result, user.Id)
+		}
+		return result
+	}
+
+	cases := []struct {
+		query      string
+		orderField string
+		orderBy    int
+		ids        []int
+	}{
+		{orderBy: OrderByAsIs, ids: []int{2, 0, 1}},
+		{orderBy: OrderByAsc, ids: []int{0, 2, 1}},
+		{orderField: "Id", orderBy: OrderByDesc, ids: []int{2, 1, 0}},
+		{orderField: "Age", orderBy: OrderByAsc, ids: []int{0, 2, 1}},
+		{orderField: "Age", orderBy: OrderByDesc, ids: []int{1, 2, 0}},
+		{query: "two", orderField: "Name", orderBy: OrderByDesc, ids: []int{1, 0}},
+		{query: "Wolf", ids: []int{2}},
+		{query: "nobody", ids: []int{}},
+	}
+
+	for i, c := range cases {
+		result := ids(ds.Search(SearchRequest{Query: c.query, OrderField: c.orderField, OrderBy: c.orderBy}))
+		if !reflect.DeepEqual(result, c.ids) {
+			t.Errorf("[%d] wrong result: %v, expected: %v", i, result, c.ids)
+		}
+	}
+}
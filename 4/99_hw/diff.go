@@ -0,0 +1,168 @@
+package hw4
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errDiffLimits = errors.New("dataset diff exceeds limits")
+
+// personFieldOrder - поля Person в порядке dataset.xml
+var personFieldOrder = []string{
+	"id", "guid", "isActive", "balance", "picture", "age", "eyeColor", "first_name", "last_name",
+	"gender", "company", "email", "phone", "address", "about", "registered", "favoriteFruit",
+}
+
+func personField(p Person, name string) string {
+	switch name {
+	case "id":
+		return strconv.Itoa(p.ID)
+	case "age":
+		return strconv.Itoa(p.Age)
+	}
+	return *lintFields[name](&p)
+}
+
+// FieldChange - поле, которое поменялось
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// PersonChange - запись, которая есть в обоих датасетах, но отличается
+type PersonChange struct {
+	ID      int           `json:"id"`
+	Guid    string        `json:"guid"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// DatasetDiff - чем новый датасет отличается от старого
+type DatasetDiff struct {
+	Added     []Person       `json:"added"`
+	Removed   []Person       `json:"removed"`
+	Modified  []PersonChange `json:"modified"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// DiffSummary - только количества из DatasetDiff
+type DiffSummary struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Modified  int `json:"modified"`
+	Unchanged int `json:"unchanged"`
+}
+
+// DiffLimits - сколько изменений допустимо, чтобы загрузить новый датасет. Отрицательное - без ограничения
+type DiffLimits struct {
+	MaxAdded    int
+	MaxRemoved  int
+	MaxModified int
+}
+
+var NoDiffLimits = DiffLimits{MaxAdded: -1, MaxRemoved: -1, MaxModified: -1}
+
+// DiffDatasets сопоставляет записи по id, а если id нет в старом датасете - по guid,
+// так что запись со сменившимся id считается изменённой, а не удалённой и добавленной.
+// Записи с одинаковым id сопоставляются по порядку: первая с первой, вторая со второй
+func DiffDatasets(prev, next []Person) DatasetDiff {
+	diff := DatasetDiff{Added: []Person{}, Removed: []Person{}, Modified: []PersonChange{}}
+	byID := map[int][]int{}
+	byGuid := map[string][]int{}
+	for i, p := range prev {
+		byID[p.ID] = append(byID[p.ID], i)
+		if p.Guid != "" {
+			byGuid[p.Guid] = append(byGuid[p.Guid], i)
+		}
+	}
+
+	matched := make([]bool, len(prev))
+	// firstUnmatched - первая ещё не сопоставленная запись из positions
+	firstUnmatched := func(positions []int) (int, bool) {
+		for _, i := range positions {
+			if !matched[i] {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	for _, p := range next {
+		i, ok := firstUnmatched(byID[p.ID])
+		if !ok && p.Guid != "" {
+			i, ok = firstUnmatched(byGuid[p.Guid])
+		}
+		if !ok {
+			diff.Added = append(diff.Added, p)
+			continue
+		}
+		matched[i] = true
+
+		var changes []FieldChange
+		for _, field := range personFieldOrder {
+			if before, after := personField(prev[i], field), personField(p, field); before != after {
+				changes = append(changes, FieldChange{Field: field, Old: before, New: after})
+			}
+		}
+		if len(changes) == 0 {
+			diff.Unchanged++
+			continue
+		}
+		diff.Modified = append(diff.Modified, PersonChange{ID: p.ID, Guid: p.Guid, Changes: changes})
+	}
+	for i, p := range prev {
+		if !matched[i] {
+			diff.Removed = append(diff.Removed, p)
+		}
+	}
+
+	byPersonID := func(a, b Person) int { return cmp.Compare(a.ID, b.ID) }
+	slices.SortStableFunc(diff.Added, byPersonID)
+	slices.SortStableFunc(diff.Removed, byPersonID)
+	slices.SortStableFunc(diff.Modified, func(a, b PersonChange) int { return cmp.Compare(a.ID, b.ID) })
+	return diff
+}
+
+func (d DatasetDiff) Summary() DiffSummary {
+	return DiffSummary{Added: len(d.Added), Removed: len(d.Removed), Modified: len(d.Modified), Unchanged: d.Unchanged}
+}
+
+// Check - ошибка, если изменений больше, чем разрешают limits
+func (s DiffSummary) Check(limits DiffLimits) error {
+	var exceeded []string
+	for _, c := range []struct {
+		name         string
+		count, limit int
+	}{{"added", s.Added, limits.MaxAdded}, {"removed", s.Removed, limits.MaxRemoved}, {"modified", s.Modified, limits.MaxModified}} {
+		if c.limit >= 0 && c.count > c.limit {
+			exceeded = append(exceeded, fmt.Sprintf("%d %s > %d", c.count, c.name, c.limit))
+		}
+	}
+	if len(exceeded) > 0 {
+		return fmt.Errorf("%w: %s", errDiffLimits, strings.Join(exceeded, ", "))
+	}
+	return nil
+}
+
+func (s DiffSummary) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d modified, %d unchanged", s.Added, s.Removed, s.Modified, s.Unchanged)
+}
+
+// parseDiffLimits разбирает max_added, max_removed и max_modified, отсутствующие - без ограничения
+func parseDiffLimits(params url.Values) (DiffLimits, error) {
+	limits := NoDiffLimits
+	for name, value := range map[string]*int{"max_added": &limits.MaxAdded, "max_removed": &limits.MaxRemoved, "max_modified": &limits.MaxModified} {
+		if raw := params.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return DiffLimits{}, fmt.Errorf("Invalid %s value", name)
+			}
+			*value = n
+		}
+	}
+	return limits, nil
+}
@@ -0,0 +1,113 @@
+package hw4
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDiffDatasets(t *testing.T) {
+	prev := []Person{
+		{ID: 1, Guid: "a", FirstName: "Boyd", Age: 22},
+		{ID: 2, Guid: "b", FirstName: "Hilda", Age: 21},
+		{ID: 3, Guid: "c", FirstName: "Brooks", Age: 25},
+		{ID: 4, Guid: "d", FirstName: "Owen", Age: 30},
+	}
+	next := []Person{
+		{ID: 5, Guid: "e", FirstName: "Zed", Age: 40},
+		{ID: 1, Guid: "a", FirstName: "Boyd", Age: 23},
+		{ID: 30, Guid: "c", FirstName: "Brooks", Age: 25},
+		{ID: 4, Guid: "d", FirstName: "Owen", Age: 30},
+	}
+
+	diff := DiffDatasets(prev, next)
+	want := DatasetDiff{
+		Added:   []Person{next[0]},
+		Removed: []Person{prev[1]},
+		Modified: []PersonChange{
+			{ID: 1, Guid: "a", Changes: []FieldChange{{Field: "age", Old: "22", New: "23"}}},
+			{ID: 30, Guid: "c", Changes: []FieldChange{{Field: "id", Old: "3", New: "30"}}},
+		},
+		Unchanged: 1,
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("wrong diff:\n%+v\nwant:\n%+v", diff, want)
+	}
+
+	summary := diff.Summary()
+	if summary != (DiffSummary{Added: 1, Removed: 1, Modified: 2, Unchanged: 1}) {
+		t.Errorf("wrong summary %+v", summary)
+	}
+	if err := summary.Check(NoDiffLimits); err != nil {
+		t.Errorf("no limits: unexpected error %v", err)
+	}
+	err := summary.Check(DiffLimits{MaxAdded: 1, MaxRemoved: 0, MaxModified: 1})
+	if !errors.Is(err, errDiffLimits) || err.Error() != "dataset diff exceeds limits: 1 removed > 0, 2 modified > 1" {
+		t.Errorf("wrong limits error: %v", err)
+	}
+
+	if diff := DiffDatasets(prev, prev); diff.Unchanged != 4 || len(diff.Added)+len(diff.Removed)+len(diff.Modified) != 0 {
+		t.Errorf("same dataset must have no changes: %+v", diff)
+	}
+
+	// первая из записей с одинаковым id не считается удалённой, пока она на месте
+	dups := []Person{prev[0], {ID: 1, Guid: "z", FirstName: "Boyd", Age: 22}}
+	if diff := DiffDatasets(dups, dups); diff.Unchanged != 2 || len(diff.Added)+len(diff.Removed)+len(diff.Modified) != 0 {
+		t.Errorf("same dataset with duplicate ids must have no changes: %+v", diff)
+	}
+	if diff := DiffDatasets(dups, dups[:1]); diff.Unchanged != 1 || !reflect.DeepEqual(diff.Removed, dups[1:]) {
+		t.Errorf("expected only the second duplicate removed: %+v", diff)
+	}
+}
+
+func TestAdminReloadLimits(t *testing.T) {
+	path := useDatasetCopy(t)
+	useAdminTokens(t, "admin")
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	defaultDatasets.limits = &DiffLimits{MaxAdded: 0, MaxRemoved: 3, MaxModified: 0}
+	before, err := defaultDatasets.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// файл меняют в обход /persons, как при новой выгрузке
+	data, err := encodeDataset(before.Persons[:30])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// сам датасет новую версию не подхватывает: удалено больше трёх
+	if ds, err := defaultDatasets.Get(); err != nil || ds.Snapshot != before.Snapshot {
+		t.Fatalf("changed file must be rejected by limits: %v", err)
+	}
+
+	var preview adminReloadPreview
+	if status := adminRequest(t, server, http.MethodPost, "/admin/reload?dry_run=true&max_removed=3", "admin", &preview); status != http.StatusOK {
+		t.Fatalf("dry run: expected 200, got %d", status)
+	}
+	if preview.Summary != (DiffSummary{Removed: 5, Unchanged: 30}) || preview.Snapshot != before.Snapshot || preview.Rejected == "" {
+		t.Errorf("wrong preview: %+v", preview)
+	}
+
+	if status := adminRequest(t, server, http.MethodPost, "/admin/reload?max_removed=3", "admin", nil); status != http.StatusConflict {
+		t.Errorf("expected 409, got %d", status)
+	}
+	if ds, _ := defaultDatasets.Get(); ds == nil || ds.Snapshot != before.Snapshot {
+		t.Errorf("rejected reload must keep the old dataset")
+	}
+	if status := adminRequest(t, server, http.MethodPost, "/admin/reload?max_removed=x", "admin", nil); status != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", status)
+	}
+
+	var status adminStatus
+	if code := adminRequest(t, server, http.MethodPost, "/admin/reload?max_removed=5", "admin", &status); code != http.StatusOK || status.Dataset.Records != 30 {
+		t.Errorf("expected reload within limits, got %d: %+v", code, status.Dataset)
+	}
+}
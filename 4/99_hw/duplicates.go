@@ -0,0 +1,238 @@
+package hw4
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// уверенность, которую даёт каждое правило по отдельности
+const (
+	emailMatchConfidence = 0.95
+	phoneMatchConfidence = 0.8
+	// умножается на похожесть имён
+	nameMatchConfidence = 0.9
+)
+
+// DuplicateRules - по каким признакам две записи считаются одним человеком
+type DuplicateRules struct {
+	// совпадает email без учёта регистра
+	Email bool
+	// совпадают цифры телефона
+	Phone bool
+	// одна компания и имена похожи не меньше чем на NameSimilarity (от 0 до 1), 0 - правило выключено
+	NameSimilarity float64
+	// пары и кластеры с меньшей уверенностью не отдаются
+	MinConfidence float64
+}
+
+var DefaultDuplicateRules = DuplicateRules{Email: true, Phone: true, NameSimilarity: 0.85, MinConfidence: 0.5}
+
+// DuplicateMatch - пара записей, похожих на одного человека, и почему.
+// A и B - id записей, RowA и RowB - их позиции в датасете: id у дублей может и совпадать
+type DuplicateMatch struct {
+	A          int      `json:"a"`
+	B          int      `json:"b"`
+	RowA       int      `json:"row_a"`
+	RowB       int      `json:"row_b"`
+	Confidence float64  `json:"confidence"`
+	Reasons    []string `json:"reasons"`
+}
+
+// DuplicateCluster - записи, которые, вероятно, стоит слить в одну: позиции в датасете по возрастанию
+// и id записей в том же порядке. Confidence - уверенность самой слабой связи, через которую запись попала в кластер
+type DuplicateCluster struct {
+	Rows       []int            `json:"rows"`
+	IDs        []int            `json:"ids"`
+	Confidence float64          `json:"confidence"`
+	Matches    []DuplicateMatch `json:"matches"`
+}
+
+// FindDuplicates сравнивает записи, у которых общий email, телефон или компания,
+// и собирает связанные пары в кластеры. Записи различаются по позиции, а не по id,
+// так что строки с одинаковым id тоже сравниваются. Кластеры идут от самых уверенных
+func FindDuplicates(persons []Person, rules DuplicateRules) []DuplicateCluster {
+	matches := duplicateMatches(persons, rules)
+
+	// как в алгоритме Краскала: сначала самые уверенные связи, так у кластера максимальная слабейшая связь
+	slices.SortFunc(matches, func(a, b DuplicateMatch) int {
+		return cmp.Or(cmp.Compare(b.Confidence, a.Confidence), cmp.Compare(a.RowA, b.RowA), cmp.Compare(a.RowB, b.RowB))
+	})
+	parent := map[int]int{}
+	var find func(row int) int
+	find = func(row int) int {
+		if p, ok := parent[row]; ok && p != row {
+			parent[row] = find(p)
+			return parent[row]
+		}
+		parent[row] = row
+		return row
+	}
+	weakest := map[int]float64{}
+	for _, m := range matches {
+		a, b := find(m.RowA), find(m.RowB)
+		if a == b {
+			continue
+		}
+		confidence := m.Confidence
+		for _, root := range []int{a, b} {
+			if c, ok := weakest[root]; ok {
+				confidence = min(confidence, c)
+			}
+		}
+		parent[b] = a
+		delete(weakest, b)
+		weakest[a] = confidence
+	}
+
+	byRoot := map[int]*DuplicateCluster{}
+	for _, m := range matches {
+		root := find(m.RowA)
+		cluster, ok := byRoot[root]
+		if !ok {
+			cluster = &DuplicateCluster{Confidence: weakest[root]}
+			byRoot[root] = cluster
+		}
+		cluster.Matches = append(cluster.Matches, m)
+		for _, row := range []int{m.RowA, m.RowB} {
+			if !slices.Contains(cluster.Rows, row) {
+				cluster.Rows = append(cluster.Rows, row)
+			}
+		}
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, cluster := range byRoot {
+		slices.Sort(cluster.Rows)
+		for _, row := range cluster.Rows {
+			cluster.IDs = append(cluster.IDs, persons[row].ID)
+		}
+		clusters = append(clusters, *cluster)
+	}
+	slices.SortFunc(clusters, func(a, b DuplicateCluster) int {
+		return cmp.Or(cmp.Compare(b.Confidence, a.Confidence), cmp.Compare(a.Rows[0], b.Rows[0]))
+	})
+	return clusters
+}
+
+// duplicateMatches - пары, прошедшие MinConfidence. Сравниваются только записи из одной корзины:
+// с тем же email, телефоном или компанией, так что не все пары подряд
+func duplicateMatches(persons []Person, rules DuplicateRules) []DuplicateMatch {
+	buckets := map[string][]int{}
+	for i, p := range persons {
+		if email := strings.ToLower(strings.TrimSpace(p.Email)); rules.Email && email != "" {
+			buckets["email:"+email] = append(buckets["email:"+email], i)
+		}
+		if phone := phoneDigits(p.Phone); rules.Phone && phone != "" {
+			buckets["phone:"+phone] = append(buckets["phone:"+phone], i)
+		}
+		if company := strings.ToLower(strings.TrimSpace(p.Company)); rules.NameSimilarity > 0 && company != "" {
+			buckets["company:"+company] = append(buckets["company:"+company], i)
+		}
+	}
+
+	type pair struct{ a, b int }
+	seen := map[pair]bool{}
+	var matches []DuplicateMatch
+	for _, bucket := range buckets {
+		for x, i := range bucket {
+			for _, j := range bucket[x+1:] {
+				if seen[pair{i, j}] {
+					continue
+				}
+				seen[pair{i, j}] = true
+				if m, ok := matchPersons(persons[i], persons[j], rules); ok {
+					m.RowA, m.RowB = i, j
+					matches = append(matches, m)
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// matchPersons складывает уверенность сработавших правил как вероятности независимых событий
+func matchPersons(a, b Person, rules DuplicateRules) (DuplicateMatch, bool) {
+	m := DuplicateMatch{A: a.ID, B: b.ID, Reasons: []string{}}
+	miss := 1.0
+	if rules.Email && a.Email != "" && strings.EqualFold(strings.TrimSpace(a.Email), strings.TrimSpace(b.Email)) {
+		m.Reasons = append(m.Reasons, "same email")
+		miss *= 1 - emailMatchConfidence
+	}
+	if rules.Phone && phoneDigits(a.Phone) != "" && phoneDigits(a.Phone) == phoneDigits(b.Phone) {
+		m.Reasons = append(m.Reasons, "same phone")
+		miss *= 1 - phoneMatchConfidence
+	}
+	if rules.NameSimilarity > 0 && a.Company != "" && strings.EqualFold(strings.TrimSpace(a.Company), strings.TrimSpace(b.Company)) {
+		if similarity := nameSimilarity(a.Name(), b.Name()); similarity >= rules.NameSimilarity {
+			m.Reasons = append(m.Reasons, "similar name at "+strings.TrimSpace(a.Company))
+			miss *= 1 - nameMatchConfidence*similarity
+		}
+	}
+	m.Confidence = math.Round((1-miss)*1000) / 1000
+	return m, len(m.Reasons) > 0 && m.Confidence >= rules.MinConfidence
+}
+
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
+
+// nameSimilarity - 1 минус расстояние Левенштейна, делённое на длину длинного имени, без учёта регистра
+func nameSimilarity(a, b string) float64 {
+	ar := []rune(strings.ToLower(strings.TrimSpace(a)))
+	br := []rune(strings.ToLower(strings.TrimSpace(b)))
+	if len(ar) == 0 && len(br) == 0 {
+		return 1
+	}
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		cur[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(br)])/float64(max(len(ar), len(br)))
+}
+
+// parseDuplicateRules разбирает email, phone, name_similarity и min_confidence поверх DefaultDuplicateRules
+func parseDuplicateRules(params url.Values) (DuplicateRules, error) {
+	rules := DefaultDuplicateRules
+	for name, value := range map[string]*bool{"email": &rules.Email, "phone": &rules.Phone} {
+		if raw := params.Get(name); raw != "" {
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return DuplicateRules{}, errors.New("Invalid " + name + " value")
+			}
+			*value = b
+		}
+	}
+	for name, value := range map[string]*float64{"name_similarity": &rules.NameSimilarity, "min_confidence": &rules.MinConfidence} {
+		if raw := params.Get(name); raw != "" {
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil || f < 0 || f > 1 {
+				return DuplicateRules{}, errors.New("Invalid " + name + " value")
+			}
+			*value = f
+		}
+	}
+	return rules, nil
+}
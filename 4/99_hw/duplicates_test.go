@@ -0,0 +1,109 @@
+package hw4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var duplicatePersons = []Person{
+	{ID: 1, FirstName: "Boyd", LastName: "Wolf", Company: "HOPELI", Email: "boydwolf@hopeli.com", Phone: "+1 (956) 593-2402"},
+	{ID: 2, FirstName: "Boyd", LastName: "Wolfe", Company: "hopeli", Email: "boyd.wolfe@hopeli.com"},
+	{ID: 3, FirstName: "B.", LastName: "Wolf", Company: "OTHER", Email: "BoydWolf@Hopeli.com"},
+	{ID: 4, FirstName: "Hilda", LastName: "Mayer", Company: "QUINTITY", Phone: "19565932402"},
+	{ID: 5, FirstName: "Brooks", LastName: "Aguilar", Company: "HOPELI", Email: "brooks@hopeli.com"},
+	{ID: 6, FirstName: "Owen", LastName: "Lynn", Company: "ZILLANET", Phone: "+1 (800) 000-0000"},
+	{ID: 7, FirstName: "Owen", LastName: "Lyn", Company: "Zillanet", Phone: "1-800-000-0000"},
+}
+
+func TestFindDuplicates(t *testing.T) {
+	clusters := FindDuplicates(duplicatePersons, DefaultDuplicateRules)
+	want := []DuplicateCluster{
+		{
+			Rows:       []int{5, 6},
+			IDs:        []int{6, 7},
+			Confidence: 0.96,
+			Matches:    []DuplicateMatch{{A: 6, B: 7, RowA: 5, RowB: 6, Confidence: 0.96, Reasons: []string{"same phone", "similar name at ZILLANET"}}},
+		},
+		{
+			Rows:       []int{0, 1, 2, 3},
+			IDs:        []int{1, 2, 3, 4},
+			Confidence: 0.8,
+			Matches: []DuplicateMatch{
+				{A: 1, B: 3, RowA: 0, RowB: 2, Confidence: 0.95, Reasons: []string{"same email"}},
+				{A: 1, B: 2, RowA: 0, RowB: 1, Confidence: 0.81, Reasons: []string{"similar name at HOPELI"}},
+				{A: 1, B: 4, RowA: 0, RowB: 3, Confidence: 0.8, Reasons: []string{"same phone"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(clusters, want) {
+		t.Errorf("wrong clusters:\n%+v\nwant:\n%+v", clusters, want)
+	}
+
+	rules := DuplicateRules{Email: true, MinConfidence: 0.5}
+	clusters = FindDuplicates(duplicatePersons, rules)
+	if len(clusters) != 1 || !reflect.DeepEqual(clusters[0].IDs, []int{1, 3}) {
+		t.Errorf("email only: wrong clusters %+v", clusters)
+	}
+
+	rules = DefaultDuplicateRules
+	rules.MinConfidence = 0.9
+	clusters = FindDuplicates(duplicatePersons, rules)
+	if len(clusters) != 2 || !reflect.DeepEqual(clusters[1].IDs, []int{1, 3}) {
+		t.Errorf("min confidence 0.9: wrong clusters %+v", clusters)
+	}
+}
+
+// строки с одним id - тоже дубли, а не одна запись
+func TestFindDuplicatesSharedID(t *testing.T) {
+	persons := []Person{
+		{ID: 1, FirstName: "Boyd", LastName: "Wolf", Email: "boydwolf@hopeli.com"},
+		{ID: 2, FirstName: "Hilda", LastName: "Mayer"},
+		{ID: 1, FirstName: "Boyd", LastName: "Wolf", Email: "boydwolf@hopeli.com"},
+	}
+	clusters := FindDuplicates(persons, DefaultDuplicateRules)
+	if len(clusters) != 1 || !reflect.DeepEqual(clusters[0].Rows, []int{0, 2}) || !reflect.DeepEqual(clusters[0].IDs, []int{1, 1}) {
+		t.Errorf("wrong clusters: %+v", clusters)
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want float64
+	}{
+		{"Boyd Wolf", "boyd wolf", 1},
+		{"Boyd Wolf", "Boyd Wolfe", 0.9},
+		{"abc", "xyz", 0},
+		{"", "", 1},
+	} {
+		if got := nameSimilarity(c.a, c.b); got != c.want {
+			t.Errorf("nameSimilarity(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestAdminDuplicates(t *testing.T) {
+	useDatasetCopy(t)
+	useAdminTokens(t, "admin")
+	addPerson(t, Person{ID: 100, FirstName: "Boyd", LastName: "Wolf", Age: 22, Gender: "male", Email: "BOYDWOLF@hopeli.com"})
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	var clusters []DuplicateCluster
+	if status := adminRequest(t, server, http.MethodGet, "/admin/duplicates", "admin", &clusters); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(clusters) != 1 || !reflect.DeepEqual(clusters[0].IDs, []int{0, 100}) {
+		t.Errorf("wrong clusters: %+v", clusters)
+	}
+	if status := adminRequest(t, server, http.MethodGet, "/admin/duplicates?email=false", "admin", &clusters); status != http.StatusOK || len(clusters) != 0 {
+		t.Errorf("without email rule expected no clusters, got %d: %+v", status, clusters)
+	}
+	for _, path := range []string{"/admin/duplicates?email=maybe", "/admin/duplicates?min_confidence=2"} {
+		if status := adminRequest(t, server, http.MethodGet, path, "admin", nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, status)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package hw4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeNDJSON   = "application/x-ndjson"
+	ContentTypeCSV      = "text/csv"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// userEncoding - формат, в котором SearchServer может отдать список пользователей
+type userEncoding struct {
+	name        string
+	contentType string
+	marshal     func(users []User) ([]byte, error)
+	unmarshal   func(data []byte) ([]User, error)
+	// разбор потоком без чтения всего тела в память, nil - формат так не умеет
+	decode func(r io.Reader) ([]User, error)
+}
+
+// Первым идёт формат по умолчанию
+var userEncodings = []userEncoding{
+	{name: "json", contentType: ContentTypeJSON, marshal: marshalUsersJSON, unmarshal: unmarshalUsersJSON, decode: decodeUsersJSON},
+	{name: "ndjson", contentType: ContentTypeNDJSON, marshal: marshalUsersNDJSON, unmarshal: unmarshalUsersNDJSON, decode: decodeUsersNDJSON},
+	{name: "csv", contentType: ContentTypeCSV, marshal: marshalUsersCSV, unmarshal: unmarshalUsersCSV},
+	{name: "protobuf", contentType: ContentTypeProtobuf, marshal: marshalUsersProtobuf, unmarshal: unmarshalUsersProtobuf},
+}
+
+// encodingByContentType ищет формат по Content-Type ответа, неизвестные типы считаем json-ом
+func encodingByContentType(contentType string) userEncoding {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		for _, enc := range userEncodings {
+			if enc.contentType == mediaType {
+				return enc
+			}
+		}
+	}
+	return userEncodings[0]
+}
+
+// negotiateEncoding выбирает формат по заголовку Accept с учётом q-весов.
+// Пустой Accept и */* дают json, false - если ни один формат не подходит
+func negotiateEncoding(accept string) (userEncoding, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return userEncodings[0], true
+	}
+
+	best, bestQ := userEncoding{}, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qValue, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qValue, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+
+		switch mediaType {
+		case "*/*", "application/*":
+			best, bestQ = userEncodings[0], q
+		default:
+			for _, enc := range userEncodings {
+				if enc.contentType == mediaType {
+					best, bestQ = enc, q
+					break
+				}
+			}
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// decodeFrom разбирает тело ответа потоком, если формат это умеет, иначе читает его целиком
+func (enc userEncoding) decodeFrom(r io.Reader) ([]User, error) {
+	if enc.decode != nil {
+		return enc.decode(r)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return enc.unmarshal(data)
+}
+
+func marshalUsersJSON(users []User) ([]byte, error) {
+	return json.Marshal(users)
+}
+
+func unmarshalUsersJSON(data []byte) ([]User, error) {
+	return decodeUsersJSON(bytes.NewReader(data))
+}
+
+// errJSONTruncated - то же сообщение, что json.Unmarshal даёт на обрезанный json
+var errJSONTruncated = errors.New("unexpected end of JSON input")
+
+// decodeUsersJSON разбирает массив по одному пользователю, не держа в памяти всё тело.
+// Всё, что не массив, разбирается через json.Unmarshal целиком, чтобы ошибки были те же
+func decodeUsersJSON(r io.Reader) ([]User, error) {
+	users := []User{}
+	br := bufio.NewReader(r)
+	for {
+		first, err := br.Peek(1)
+		if err == io.EOF {
+			return users, errJSONTruncated
+		}
+		if err != nil {
+			return users, err
+		}
+		if !isJSONSpace(first[0]) {
+			break
+		}
+		br.ReadByte()
+	}
+	if first, _ := br.Peek(1); first[0] != '[' {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return users, err
+		}
+		err = json.Unmarshal(data, &users)
+		return users, err
+	}
+
+	decoder := json.NewDecoder(br)
+	if _, err := decoder.Token(); err != nil {
+		return users, jsonTruncated(err)
+	}
+	for decoder.More() {
+		user := User{}
+		if err := decoder.Decode(&user); err != nil {
+			return users, jsonTruncated(err)
+		}
+		users = append(users, user)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return users, jsonTruncated(err)
+	}
+	return users, checkJSONEnd(decoder)
+}
+
+func isJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// jsonTruncated заменяет конец потока посреди значения на сообщение json.Unmarshal
+func jsonTruncated(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return errJSONTruncated
+	}
+	return err
+}
+
+// checkJSONEnd - как и json.Unmarshal, не пропускаем мусор после значения
+func checkJSONEnd(decoder *json.Decoder) error {
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("invalid character after top-level value")
+		}
+		return err
+	}
+	return nil
+}
+
+func marshalUsersNDJSON(users []User) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, user := range users {
+		if err := enc.Encode(user); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalUsersNDJSON(data []byte) ([]User, error) {
+	return decodeUsersNDJSON(bytes.NewReader(data))
+}
+
+// decodeUsersNDJSON читает по одному пользователю, пустые строки пропускаются
+func decodeUsersNDJSON(r io.Reader) ([]User, error) {
+	users := []User{}
+	decoder := json.NewDecoder(r)
+	for {
+		user := User{}
+		err := decoder.Decode(&user)
+		if err == io.EOF {
+			return users, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+}
+
+var csvHeader = []string{"Id", "Name", "Age", "About", "Gender"}
+
+type csvColumn struct {
+	name  string
+	value func(u *User) *string
+}
+
+// дополнительные колонки CSV, пишутся только если хоть у кого-то есть значение
+var csvExtraColumns = []csvColumn{
+	{"Email", func(u *User) *string { return &u.Email }},
+	{"Phone", func(u *User) *string { return &u.Phone }},
+	{"Address", func(u *User) *string { return &u.Address }},
+	{"Balance", func(u *User) *string { return &u.Balance }},
+}
+
+func marshalUsersCSV(users []User) ([]byte, error) {
+	header := slices.Clone(csvHeader)
+	var extra []int
+	for i, column := range csvExtraColumns {
+		if slices.ContainsFunc(users, func(u User) bool { return *column.value(&u) != "" }) {
+			header = append(header, column.name)
+			extra = append(extra, i)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write(header)
+	for _, user := range users {
+		row := []string{strconv.Itoa(user.Id), user.Name, strconv.Itoa(user.Age), user.About, user.Gender}
+		for _, i := range extra {
+			row = append(row, *csvExtraColumns[i].value(&user))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func unmarshalUsersCSV(data []byte) ([]User, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || len(records[0]) < len(csvHeader) || !slices.Equal(records[0][:len(csvHeader)], csvHeader) {
+		return nil, errors.New("unexpected csv header")
+	}
+	var extra []int
+	for _, name := range records[0][len(csvHeader):] {
+		i := slices.IndexFunc(csvExtraColumns, func(column csvColumn) bool { return column.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unexpected csv column %s", name)
+		}
+		extra = append(extra, i)
+	}
+
+	users := make([]User, 0, len(records)-1)
+	for _, record := range records[1:] {
+		user := User{Name: record[1], About: record[3], Gender: record[4]}
+		if user.Id, err = strconv.Atoi(record[0]); err != nil {
+			return nil, err
+		}
+		if user.Age, err = strconv.Atoi(record[2]); err != nil {
+			return nil, err
+		}
+		for j, i := range extra {
+			*csvExtraColumns[i].value(&user) = record[len(csvHeader)+j]
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Номера полей из user.proto
+const (
+	protoSearchResultUsers = 1
+
+	protoUserId      = 1
+	protoUserName    = 2
+	protoUserAge     = 3
+	protoUserAbout   = 4
+	protoUserGender  = 5
+	protoUserEmail   = 6
+	protoUserPhone   = 7
+	protoUserAddress = 8
+	protoUserBalance = 9
+)
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+func appendProtoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendProtoInt(b []byte, field, value int) []byte {
+	if value == 0 {
+		return b
+	}
+	b = appendProtoTag(b, field, protoWireVarint)
+	return binary.AppendUvarint(b, uint64(int64(value)))
+}
+
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = appendProtoTag(b, field, protoWireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendProtoString(b []byte, field int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	return appendProtoBytes(b, field, []byte(value))
+}
+
+func marshalUserProtobuf(user User) []byte {
+	var b []byte
+	b = appendProtoInt(b, protoUserId, user.Id)
+	b = appendProtoString(b, protoUserName, user.Name)
+	b = appendProtoInt(b, protoUserAge, user.Age)
+	b = appendProtoString(b, protoUserAbout, user.About)
+	b = appendProtoString(b, protoUserGender, user.Gender)
+	b = appendProtoString(b, protoUserEmail, user.Email)
+	b = appendProtoString(b, protoUserPhone, user.Phone)
+	b = appendProtoString(b, protoUserAddress, user.Address)
+	b = appendProtoString(b, protoUserBalance, user.Balance)
+	return b
+}
+
+func marshalUsersProtobuf(users []User) ([]byte, error) {
+	var b []byte
+	for _, user := range users {
+		b = appendProtoBytes(b, protoSearchResultUsers, marshalUserProtobuf(user))
+	}
+	return b, nil
+}
+
+var errProtoTruncated = errors.New("protobuf: truncated message")
+
+// readProtoField читает одно поле сообщения, для bytes-полей value - длина, payload - содержимое
+func readProtoField(b []byte) (field, wireType int, value uint64, payload, rest []byte, err error) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errProtoTruncated
+	}
+	b = b[n:]
+	field, wireType = int(tag>>3), int(tag&7)
+
+	switch wireType {
+	case protoWireVarint:
+		value, n = binary.Uvarint(b)
+		if n <= 0 {
+			return 0, 0, 0, nil, nil, errProtoTruncated
+		}
+		return field, wireType, value, nil, b[n:], nil
+	case protoWireBytes:
+		value, n = binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < value {
+			return 0, 0, 0, nil, nil, errProtoTruncated
+		}
+		b = b[n:]
+		return field, wireType, value, b[:value], b[value:], nil
+	case protoWireFixed64:
+		if len(b) < 8 {
+			return 0, 0, 0, nil, nil, errProtoTruncated
+		}
+		return field, wireType, binary.LittleEndian.Uint64(b), nil, b[8:], nil
+	case protoWireFixed32:
+		if len(b) < 4 {
+			return 0, 0, 0, nil, nil, errProtoTruncated
+		}
+		return field, wireType, uint64(binary.LittleEndian.Uint32(b)), nil, b[4:], nil
+	}
+	return 0, 0, 0, nil, nil, fmt.Errorf("protobuf: unsupported wire type %d", wireType)
+}
+
+func unmarshalUserProtobuf(b []byte) (User, error) {
+	user := User{}
+	for len(b) > 0 {
+		field, wireType, value, payload, rest, err := readProtoField(b)
+		if err != nil {
+			return User{}, err
+		}
+		b = rest
+
+		switch {
+		case field == protoUserId && wireType == protoWireVarint:
+			user.Id = int(int64(value))
+		case field == protoUserName && wireType == protoWireBytes:
+			user.Name = string(payload)
+		case field == protoUserAge && wireType == protoWireVarint:
+			user.Age = int(int64(value))
+		case field == protoUserAbout && wireType == protoWireBytes:
+			user.About = string(payload)
+		case field == protoUserGender && wireType == protoWireBytes:
+			user.Gender = string(payload)
+		case field == protoUserEmail && wireType == protoWireBytes:
+			user.Email = string(payload)
+		case field == protoUserPhone && wireType == protoWireBytes:
+			user.Phone = string(payload)
+		case field == protoUserAddress && wireType == protoWireBytes:
+			user.Address = string(payload)
+		case field == protoUserBalance && wireType == protoWireBytes:
+			user.Balance = string(payload)
+		}
+	}
+	return user, nil
+}
+
+func unmarshalUsersProtobuf(b []byte) ([]User, error) {
+	users := []User{}
+	for len(b) > 0 {
+		field, wireType, _, payload, rest, err := readProtoField(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+
+		if field != protoSearchResultUsers || wireType != protoWireBytes {
+			continue
+		}
+		user, err := unmarshalUserProtobuf(payload)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
@@ -0,0 +1,113 @@
+package hw4
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		accept      string
+		contentType string
+		ok          bool
+	}{
+		{accept: "", contentType: ContentTypeJSON, ok: true},
+		{accept: "*/*", contentType: ContentTypeJSON, ok: true},
+		{accept: "application/*", contentType: ContentTypeJSON, ok: true},
+		{accept: "text/csv", contentType: ContentTypeCSV, ok: true},
+		{accept: "application/x-ndjson", contentType: ContentTypeNDJSON, ok: true},
+		{accept: "application/x-protobuf", contentType: ContentTypeProtobuf, ok: true},
+		{accept: "text/html, text/csv;q=0.5, application/json;q=0.9", contentType: ContentTypeJSON, ok: true},
+		{accept: "text/csv;q=0.1, application/x-protobuf", contentType: ContentTypeProtobuf, ok: true},
+		{accept: "application/json;q=0", ok: false},
+		{accept: "text/html", ok: false},
+	}
+
+	for i, c := range cases {
+		enc, ok := negotiateEncoding(c.accept)
+		if ok != c.ok {
+			t.Errorf("[%d] unexpected ok for %q: %v", i, c.accept, ok)
+			continue
+		}
+		if ok && enc.contentType != c.contentType {
+			t.Errorf("[%d] wrong encoding for %q: %s, expected: %s", i, c.accept, enc.contentType, c.contentType)
+		}
+	}
+}
+
+func TestUserEncodingsRoundTrip(t *testing.T) {
+	users := []User{
+		{Id: 0, Name: "Boyd Wolf", Age: 22, About: "line one\nline \"two\", with comma", Gender: "male"},
+		{Id: 34, Name: "Kane Sharp", Age: 34, About: "", Gender: "male"},
+		{Id: -1, Name: "Ночной Дозор", Age: 0, About: "юникод", Gender: "female"},
+	}
+
+	for _, enc := range userEncodings {
+		data, err := enc.marshal(users)
+		if err != nil {
+			t.Errorf("[%s] marshal error: %v", enc.name, err)
+			continue
+		}
+		result, err := enc.unmarshal(data)
+		if err != nil {
+			t.Errorf("[%s] unmarshal error: %v", enc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, users) {
+			t.Errorf("[%s] wrong result:\n %#v\n expected:\n %#v", enc.name, result, users)
+		}
+	}
+}
+
+func TestUnmarshalBrokenPayload(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+	}{
+		{contentType: ContentTypeNDJSON, body: "{\"Id\": 1}\n{\"Id\": "},
+		{contentType: ContentTypeCSV, body: "Id,Name\n1,Boyd"},
+		{contentType: ContentTypeCSV, body: "Id,Name,Age,About,Gender\nx,Boyd,22,,male"},
+		{contentType: ContentTypeProtobuf, body: "\x0a\x10\x08"},
+	}
+
+	for i, c := range cases {
+		enc := encodingByContentType(c.contentType)
+		if _, err := enc.unmarshal([]byte(c.body)); err == nil {
+			t.Errorf("[%d] expected error for %s payload %q", i, enc.name, c.body)
+		}
+	}
+}
+
+func TestSearchServerAccept(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	req := SearchRequest{Limit: 5, Offset: 3, Query: "nisi", OrderField: "Age", OrderBy: OrderByAsc}
+	jsonClient := &SearchClient{URL: server.URL, AccessToken: "123"}
+	expected, err := jsonClient.FindUsers(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, enc := range userEncodings {
+		client := &SearchClient{URL: server.URL, AccessToken: "123", Accept: enc.contentType}
+		result, err := client.FindUsers(req)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %v", enc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%s] wrong result:\n %#v\n expected:\n %#v", enc.name, result, expected)
+		}
+	}
+
+	client := &SearchClient{URL: server.URL, AccessToken: "123", Accept: "text/html"}
+	result, err := client.FindUsers(req)
+	expectedErr := fmt.Errorf("SearchServer cant encode result as text/html")
+	if result != nil || err == nil || err.Error() != expectedErr.Error() {
+		t.Errorf("expected error %q, got %v", expectedErr, err)
+	}
+}
@@ -0,0 +1,176 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEndpointCooldown = 5 * time.Second
+	defaultHedgeDelay       = 100 * time.Millisecond
+
+	// сколько последних задержек помнить для p95 и сколько нужно, чтобы ему доверять
+	latencyWindow   = 100
+	minHedgeSamples = 20
+	hedgePercentile = 0.95
+)
+
+// Endpoints - несколько реплик SearchServer. Запросы расходятся по кругу между живыми
+// репликами, при ошибке соединения реплика выпадает из ротации на Cooldown, а запрос
+// повторяется на следующей. С Hedge, если ответа нет дольше p95 задержки, параллельно
+// уходит дублирующий запрос на следующую реплику: побеждает первый успешный ответ,
+// остальные отменяются
+type Endpoints struct {
+	URLs []string
+	// на сколько убирать реплику из ротации после ошибки соединения, 0 - 5 секунд
+	Cooldown time.Duration
+	// отправлять дублирующий запрос, если первый отвечает дольше p95
+	Hedge bool
+	// задержка перед дублирующим запросом, пока не накопилась статистика для p95, 0 - 100мс
+	HedgeDelay time.Duration
+
+	mu        sync.Mutex
+	next      int
+	downUntil map[string]time.Time
+	latencies []time.Duration
+	pos       int
+}
+
+// order отдаёт реплики в порядке обхода: живые по кругу, за ними выпавшие - на крайний случай
+func (e *Endpoints) order() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.URLs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	healthy := make([]string, 0, len(e.URLs))
+	var down []string
+	for i := range e.URLs {
+		endpoint := e.URLs[(e.next+i)%len(e.URLs)]
+		if now.Before(e.downUntil[endpoint]) {
+			down = append(down, endpoint)
+		} else {
+			healthy = append(healthy, endpoint)
+		}
+	}
+	e.next = (e.next + 1) % len(e.URLs)
+	return append(healthy, down...)
+}
+
+func (e *Endpoints) markDown(endpoint string) {
+	cooldown := e.Cooldown
+	if cooldown <= 0 {
+		cooldown = defaultEndpointCooldown
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.downUntil == nil {
+		e.downUntil = map[string]time.Time{}
+	}
+	e.downUntil[endpoint] = time.Now().Add(cooldown)
+}
+
+func (e *Endpoints) markUp(endpoint string, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.downUntil, endpoint)
+
+	if len(e.latencies) < latencyWindow {
+		e.latencies = append(e.latencies, latency)
+		return
+	}
+	e.latencies[e.pos] = latency
+	e.pos = (e.pos + 1) % latencyWindow
+}
+
+// hedgeDelay - p95 последних задержек или HedgeDelay, пока их мало
+func (e *Endpoints) hedgeDelay() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.latencies) < minHedgeSamples {
+		if e.HedgeDelay > 0 {
+			return e.HedgeDelay
+		}
+		return defaultHedgeDelay
+	}
+	sorted := slices.Clone(e.latencies)
+	slices.Sort(sorted)
+	return sorted[int(float64(len(sorted)-1)*hedgePercentile)]
+}
+
+var errNoEndpoints = errors.New("no SearchServer endpoints configured")
+
+type attemptResult struct {
+	endpoint string
+	result   *SearchResponse
+	status   int
+	err      error
+	duration time.Duration
+}
+
+func (e *Endpoints) do(ctx context.Context, attempt func(ctx context.Context, endpoint string, n int) (*SearchResponse, int, error)) (*SearchResponse, int, error) {
+	order := e.order()
+	if len(order) == 0 {
+		return nil, 0, errNoEndpoints
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, len(order))
+	launched := 0
+	launch := func() {
+		endpoint := order[launched]
+		launched++
+		go func(n int) {
+			started := time.Now()
+			result, status, err := attempt(ctx, endpoint, n)
+			results <- attemptResult{endpoint, result, status, err, time.Since(started)}
+		}(launched)
+	}
+	launch()
+
+	var hedge <-chan time.Time
+	if e.Hedge && len(order) > 1 {
+		timer := time.NewTimer(e.hedgeDelay())
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	var last *attemptResult
+	for done := 0; done < launched; {
+		select {
+		case <-hedge:
+			hedge = nil
+			if launched < len(order) {
+				launch()
+			}
+		case res := <-results:
+			done++
+			// ошибка без ответа при живом контексте - реплика недоступна
+			connErr := res.err != nil && res.status == 0 && ctx.Err() == nil
+			if connErr {
+				e.markDown(res.endpoint)
+			} else if res.status != 0 {
+				e.markUp(res.endpoint, res.duration)
+			}
+
+			if res.err == nil {
+				return res.result, res.status, nil
+			}
+			if last == nil || !connErr {
+				last = &res
+			}
+			if connErr && launched < len(order) {
+				launch()
+			}
+		}
+	}
+	return last.result, last.status, last.err
+}
@@ -0,0 +1,142 @@
+package hw4
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func countingServer(hits *atomic.Int32, handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		handler(w, r)
+	}))
+}
+
+func TestEndpointsRoundRobin(t *testing.T) {
+	var hitsA, hitsB atomic.Int32
+	serverA := countingServer(&hitsA, SearchServer)
+	defer serverA.Close()
+	serverB := countingServer(&hitsB, SearchServer)
+	defer serverB.Close()
+
+	client := &SearchClient{AccessToken: "123", Endpoints: &Endpoints{URLs: []string{serverA.URL, serverB.URL}}}
+	for i := 0; i < 4; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if hitsA.Load() != 2 || hitsB.Load() != 2 {
+		t.Errorf("expected 2 hits per endpoint, got %d and %d", hitsA.Load(), hitsB.Load())
+	}
+}
+
+func TestEndpointsFailover(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(SearchServer))
+	dead.Close()
+	var hits atomic.Int32
+	alive := countingServer(&hits, SearchServer)
+	defer alive.Close()
+
+	stats := &StatsRecorder{}
+	client := &SearchClient{
+		AccessToken: "123",
+		Hooks:       stats,
+		Endpoints:   &Endpoints{URLs: []string{dead.URL, alive.URL}, Cooldown: time.Minute},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+			t.Fatalf("[%d] unexpected error: %v", i, err)
+		}
+	}
+	// первый запрос переключился с мёртвой реплики, дальше она вне ротации
+	got := stats.Stats()
+	if got.Requests != 4 || got.Retries != 1 || got.Errors != 1 || got.Pages != 3 {
+		t.Errorf("wrong stats: %+v", got)
+	}
+	if hits.Load() != 3 {
+		t.Errorf("expected 3 hits on alive endpoint, got %d", hits.Load())
+	}
+
+	// когда живых нет, возвращается ошибка последней попытки
+	allDead := &SearchClient{AccessToken: "123", Endpoints: &Endpoints{URLs: []string{dead.URL, dead.URL + "/"}}}
+	if _, err := allDead.FindUsers(SearchRequest{}); err == nil || !strings.HasPrefix(err.Error(), "unknown error") {
+		t.Errorf("expected connection error, got %v", err)
+	}
+
+	// ответ 4xx - не повод идти на другую реплику
+	var badHits atomic.Int32
+	bad := countingServer(&badHits, SearchErrorBadRequestUnknownServer)
+	defer bad.Close()
+	client = &SearchClient{AccessToken: "123", Endpoints: &Endpoints{URLs: []string{bad.URL, alive.URL}}}
+	if _, err := client.FindUsers(SearchRequest{}); err == nil {
+		t.Errorf("expected bad request error")
+	}
+	if badHits.Load() != 1 || hits.Load() != 3 {
+		t.Errorf("bad request must not fail over, hits %d and %d", badHits.Load(), hits.Load())
+	}
+
+	if _, err := (&SearchClient{Endpoints: &Endpoints{}}).FindUsers(SearchRequest{}); err != errNoEndpoints {
+		t.Errorf("expected errNoEndpoints, got %v", err)
+	}
+}
+
+func TestEndpointsHedge(t *testing.T) {
+	slowCancelled := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			slowCancelled <- struct{}{}
+		case <-time.After(500 * time.Millisecond):
+			SearchServer(w, r)
+		}
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer fast.Close()
+
+	client := &SearchClient{
+		AccessToken: "123",
+		Endpoints:   &Endpoints{URLs: []string{slow.URL, fast.URL}, Hedge: true, HedgeDelay: 20 * time.Millisecond},
+	}
+
+	started := time.Now()
+	resp, err := client.FindUsers(SearchRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(resp.Users))
+	}
+	if elapsed := time.Since(started); elapsed > 400*time.Millisecond {
+		t.Errorf("hedged request took too long: %s", elapsed)
+	}
+
+	select {
+	case <-slowCancelled:
+	case <-time.After(time.Second):
+		t.Errorf("slow request was not cancelled")
+	}
+}
+
+func TestEndpointsHedgeDelay(t *testing.T) {
+	e := &Endpoints{HedgeDelay: time.Second}
+	for i := 1; i <= minHedgeSamples-1; i++ {
+		e.markUp("a", time.Duration(i)*time.Millisecond)
+	}
+	if delay := e.hedgeDelay(); delay != time.Second {
+		t.Errorf("expected HedgeDelay until enough samples, got %s", delay)
+	}
+
+	for i := minHedgeSamples; i <= latencyWindow+50; i++ {
+		e.markUp("a", time.Duration(i)*time.Millisecond)
+	}
+	// в окне задержки 51..150мс
+	if delay := e.hedgeDelay(); delay != 145*time.Millisecond {
+		t.Errorf("wrong p95: %s", delay)
+	}
+}
@@ -0,0 +1,147 @@
+package hw4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const defaultExportBatchSize = 100
+
+// клиент без общего таймаута - выгрузка может идти долго, отменяется через контекст
+var exportClient = &http.Client{Transport: defaultTransport}
+
+// SearchExportServer отдаёт всех подходящих под query пользователей в порядке датасета,
+// без limit/offset, в виде NDJSON. После каждых batch_size записей ответ сбрасывается клиенту
+func SearchExportServer(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("AccessToken") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+
+	query := r.FormValue("query")
+	batchSize := defaultExportBatchSize
+	if batchSizeValue := r.FormValue("batch_size"); batchSizeValue != "" {
+		var err error
+		batchSize, err = strconv.Atoi(batchSizeValue)
+		if err != nil || batchSize <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "Invalid batch_size value"}`))
+			return
+		}
+	}
+
+	ds, err := storeFor(r).Get()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	positions := ds.search(SearchRequest{Query: query})
+
+	ids := make([]int, len(positions))
+	for n, i := range positions {
+		ids[n] = ds.records[i].Id
+	}
+	params := url.Values{}
+	if query != "" {
+		params.Add("query", query)
+	}
+	if err := auditRead(r, "export", r.Header.Get("AccessToken"), ds.Snapshot, params.Encode(), ids); err != nil {
+		writeAuditError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeNDJSON)
+	w.Header().Set(SnapshotHeader, ds.Snapshot)
+	w.WriteHeader(http.StatusOK)
+
+	// после WriteHeader статус уже не поменять: при ошибке обрываем соединение,
+	// чтобы клиент получил unexpected EOF, а не принял обрезанную выгрузку за полную
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for written, i := range positions {
+		if r.Context().Err() != nil {
+			return
+		}
+		if err := enc.Encode(ds.records[i].User); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if (written+1)%batchSize == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// ExportUsers выгружает всех подходящих под query пользователей, без ограничения в 25 записей.
+// Ответ читается потоком, выгрузку можно прервать отменой ctx или выходом из цикла
+func (srv *SearchClient) ExportUsers(ctx context.Context, query string) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		exportURL, err := srv.datasetURL("export")
+		if err != nil {
+			yield(User{}, err)
+			return
+		}
+
+		params := url.Values{}
+		params.Add("query", query)
+		exportReq, err := http.NewRequestWithContext(ctx, http.MethodGet, exportURL+"?"+params.Encode(), nil)
+		if err != nil {
+			yield(User{}, fmt.Errorf("bad URL %s: %s", exportURL, err))
+			return
+		}
+		exportReq.Header.Add("AccessToken", srv.AccessToken)
+		exportReq.Header.Add(TraceParentHeader, outgoingTrace(ctx).String())
+
+		resp, err := srv.streamClient(exportClient).Do(exportReq)
+		if err != nil {
+			if ctx.Err() != nil {
+				yield(User{}, ctx.Err())
+				return
+			}
+			yield(User{}, fmt.Errorf("unknown error %s", err))
+			return
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusUnauthorized:
+			yield(User{}, ErrBadAccessToken)
+			return
+		case http.StatusNotFound:
+			yield(User{}, ErrDatasetNotFound)
+			return
+		default:
+			yield(User{}, fmt.Errorf("SearchServer export failed with status %d", resp.StatusCode))
+			return
+		}
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			user := User{}
+			err := decoder.Decode(&user)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+				} else {
+					err = fmt.Errorf("cant unpack export json: %s", err)
+				}
+				yield(User{}, err)
+				return
+			}
+			if !yield(user, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportUsers(t *testing.T) {
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	cases := []struct {
+		query string
+		count int
+	}{
+		{query: "", count: 35},
+		{query: "Boyd", count: 1},
+		{query: "nisi", count: 22},
+		{query: "no such user", count: 0},
+	}
+
+	client := &SearchClient{URL: server.URL, AccessToken: "123"}
+	for i, c := range cases {
+		var exported []User
+		for user, err := range client.ExportUsers(context.Background(), c.query) {
+			if err != nil {
+				t.Fatalf("[%d] unexpected error: %v", i, err)
+			}
+			exported = append(exported, user)
+		}
+		if len(exported) != c.count {
+			t.Errorf("[%d] wrong count for %q: %d, expected: %d", i, c.query, len(exported), c.count)
+		}
+
+		// та же выборка постранично через FindUsers
+		var paged []User
+		for offset := 0; ; offset += 25 {
+			resp, err := client.FindUsers(SearchRequest{Limit: 25, Offset: offset, Query: c.query})
+			if err != nil {
+				t.Fatalf("[%d] unexpected error: %v", i, err)
+			}
+			paged = append(paged, resp.Users...)
+			if !resp.NextPage {
+				break
+			}
+		}
+		if !reflect.DeepEqual(exported, paged) && len(exported)+len(paged) > 0 {
+			t.Errorf("[%d] export differs from FindUsers:\n %#v\n expected:\n %#v", i, exported, paged)
+		}
+	}
+}
+
+func TestExportUsersStop(t *testing.T) {
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	client := &SearchClient{URL: server.URL, AccessToken: "123"}
+
+	count := 0
+	for _, err := range client.ExportUsers(context.Background(), "") {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	if count != 3 {
+		t.Errorf("expected to stop after 3 users, got %d", count)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, err := range client.ExportUsers(ctx, "") {
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	}
+}
+
+func TestExportUsersErrors(t *testing.T) {
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	badJSONServer := httptest.NewServer(http.HandlerFunc(SearchBadJsonServer))
+	defer badJSONServer.Close()
+	internalErrorServer := httptest.NewServer(http.HandlerFunc(SearchInternalErrorServer))
+	defer internalErrorServer.Close()
+
+	cases := []struct {
+		client SearchClient
+		err    error
+	}{
+		{
+			client: SearchClient{URL: server.URL},
+			err:    fmt.Errorf("Bad AccessToken"),
+		},
+		{
+			client: SearchClient{URL: badJSONServer.URL, AccessToken: "123"},
+			err:    fmt.Errorf("cant unpack export json: unexpected EOF"),
+		},
+		{
+			client: SearchClient{URL: internalErrorServer.URL, AccessToken: "123"},
+			err:    fmt.Errorf("SearchServer export failed with status 500"),
+		},
+	}
+
+	for i, c := range cases {
+		for _, err := range c.client.ExportUsers(context.Background(), "") {
+			if err == nil || err.Error() != c.err.Error() {
+				t.Errorf("[%d] expected error %q, got %v", i, c.err, err)
+			}
+		}
+	}
+}
+
+func TestSearchExportServerFlush(t *testing.T) {
+	cases := []struct {
+		batchSize string
+		status    int
+		flushed   bool
+	}{
+		{batchSize: "10", status: http.StatusOK, flushed: true},
+		{batchSize: "100", status: http.StatusOK, flushed: false},
+		{batchSize: "0", status: http.StatusBadRequest},
+		{batchSize: "abc", status: http.StatusBadRequest},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/export?batch_size="+c.batchSize, nil)
+		req.Header.Set("AccessToken", "123")
+		rec := httptest.NewRecorder()
+		SearchExportServer(rec, req)
+
+		if rec.Code != c.status {
+			t.Errorf("[%d] wrong status: %d, expected: %d", i, rec.Code, c.status)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		if rec.Flushed != c.flushed {
+			t.Errorf("[%d] wrong flushed: %v, expected: %v", i, rec.Flushed, c.flushed)
+		}
+		if lines := strings.Count(rec.Body.String(), "\n"); lines != 35 {
+			t.Errorf("[%d] wrong line count: %d, expected: 35", i, lines)
+		}
+	}
+}
+
+// failingWriter - клиент отвалился посреди выгрузки
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestSearchExportServerAbortsOnWriteError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/export", nil)
+	req.Header.Set("AccessToken", "123")
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected http.ErrAbortHandler panic, got %v", r)
+		}
+	}()
+	SearchExportServer(failingWriter{httptest.NewRecorder()}, req)
+}
@@ -0,0 +1,174 @@
+package hw4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// форматы, в которых принимаются registered_from и registered_to
+var dateParamLayouts = []string{time.RFC3339, registeredLayout, time.DateOnly}
+
+// RegisteredAt разбирает registered вида "2017-02-05T06:23:27 -03:00"
+func (p Person) RegisteredAt() (time.Time, error) {
+	return time.Parse(registeredLayout, p.Registered)
+}
+
+// Location достаёт город и штат из address вида "586 Winthrop Street, Edneyville, Mississippi, 9555"
+func (p Person) Location() (city, state string) {
+	parts := strings.Split(p.Address, ",")
+	if len(parts) < 4 {
+		return "", ""
+	}
+	return strings.TrimSpace(parts[len(parts)-3]), strings.TrimSpace(parts[len(parts)-2])
+}
+
+func parseDateParam(name, value string) (time.Time, error) {
+	for _, layout := range dateParamLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid %s value", name)
+}
+
+// parseFilterParams разбирает фильтры по адресу и дате регистрации
+func parseFilterParams(params url.Values, sr *SearchRequest) error {
+	sr.State = params.Get("state")
+	sr.City = params.Get("city")
+
+	for name, value := range map[string]*time.Time{"registered_from": &sr.RegisteredFrom, "registered_to": &sr.RegisteredTo} {
+		if raw := params.Get(name); raw != "" {
+			t, err := parseDateParam(name, raw)
+			if err != nil {
+				return err
+			}
+			*value = t
+		}
+	}
+	return nil
+}
+
+// addFilterParams - обратное к parseFilterParams, пустые фильтры не передаются
+func addFilterParams(params url.Values, req SearchRequest) {
+	if req.State != "" {
+		params.Add("state", req.State)
+	}
+	if req.City != "" {
+		params.Add("city", req.City)
+	}
+	if !req.RegisteredFrom.IsZero() {
+		params.Add("registered_from", req.RegisteredFrom.Format(time.RFC3339Nano))
+	}
+	if !req.RegisteredTo.IsZero() {
+		params.Add("registered_to", req.RegisteredTo.Format(time.RFC3339Nano))
+	}
+}
+
+// Facets - сколько подходящих под запрос записей в каждом штате и городе
+type Facets struct {
+	States map[string]int `json:"states"`
+	Cities map[string]int `json:"cities"`
+}
+
+func (ds *Dataset) Facets(sr SearchRequest) Facets {
+	facets := Facets{States: map[string]int{}, Cities: map[string]int{}}
+	for i, rec := range ds.records {
+		if !ds.matches(i, sr) {
+			continue
+		}
+		if rec.State != "" {
+			facets.States[rec.State]++
+		}
+		if rec.City != "" {
+			facets.Cities[rec.City]++
+		}
+	}
+	return facets
+}
+
+// FacetsServer отдаёт Facets по тем же фильтрам, что и поиск
+func FacetsServer(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("AccessToken") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+
+	r.ParseForm()
+	sr, err := parseSearchParams(r.Form)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ds, err := storeFor(r).Get()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// по ответу видно, кто где живёт, так что в журнал идут все посчитанные записи
+	sr.OrderBy = OrderByAsIs
+	if err := auditRead(r, "facets", r.Header.Get("AccessToken"), ds.Snapshot, normalizedParams(sr), ds.searchIDs(sr)); err != nil {
+		writeAuditError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	json.NewEncoder(w).Encode(ds.Facets(sr))
+}
+
+// Facets считает штаты и города по фильтрам req, Limit, Offset и сортировка не важны
+func (srv *SearchClient) Facets(ctx context.Context, req SearchRequest) (*Facets, error) {
+	facetsURL, err := srv.datasetURL("facets")
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Add("query", req.Query)
+	addFilterParams(params, req)
+
+	facetsReq, err := http.NewRequestWithContext(ctx, http.MethodGet, facetsURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad URL %s: %s", facetsURL, err)
+	}
+	facetsReq.Header.Add("AccessToken", srv.AccessToken)
+	facetsReq.Header.Add(TraceParentHeader, outgoingTrace(ctx).String())
+
+	resp, err := srv.httpClient().Do(facetsReq)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %s", err)
+	}
+	defer drainAndClose(resp.Body)
+	body, err := io.ReadAll(limitBody(resp, srv.maxResponseBytes()))
+	if err != nil {
+		return nil, fmt.Errorf("cant read response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, ErrBadAccessToken
+	case http.StatusInternalServerError:
+		return nil, ErrServerFatal
+	case http.StatusNotFound:
+		return nil, ErrDatasetNotFound
+	default:
+		errResp := SearchErrorResponse{}
+		if err := json.Unmarshal(body, &errResp); err != nil {
+			return nil, fmt.Errorf("cant unpack error json: %s", err)
+		}
+		return nil, badRequestError("unknown bad request error: " + errResp.Error)
+	}
+
+	facets := &Facets{}
+	if err := json.Unmarshal(body, facets); err != nil {
+		return nil, fmt.Errorf("cant unpack facets json: %s", err)
+	}
+	return facets, nil
+}
@@ -0,0 +1,115 @@
+package hw4
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPersonLocationAndRegistered(t *testing.T) {
+	p := Person{Address: "586 Winthrop Street, Edneyville, Mississippi, 9555", Registered: "2017-02-05T06:23:27 -03:00"}
+	if city, state := p.Location(); city != "Edneyville" || state != "Mississippi" {
+		t.Errorf("wrong location %q, %q", city, state)
+	}
+	registered, err := p.RegisteredAt()
+	if err != nil || !registered.Equal(time.Date(2017, 2, 5, 9, 23, 27, 0, time.UTC)) {
+		t.Errorf("wrong registered %v, %v", registered, err)
+	}
+
+	if city, state := (Person{Address: "somewhere"}).Location(); city != "" || state != "" {
+		t.Errorf("expected empty location, got %q, %q", city, state)
+	}
+}
+
+func TestDatasetFilters(t *testing.T) {
+	ds := NewDataset([]Person{
+		{ID: 0, Address: "1 A Street, Hegins, New Jersey, 1", Registered: "2015-01-01T00:00:00 -03:00"},
+		{ID: 1, Address: "2 B Street, Hessville, Nevada, 2", Registered: "2014-01-01T00:00:00 -03:00"},
+		{ID: 2, Address: "3 C Street, Hegins, Nevada, 3", Registered: "2016-01-01T00:00:00 -03:00"},
+		{ID: 3, Address: "broken", Registered: "broken"},
+	})
+	ids := func(users []User) []int {
+		result := []int{}
+		for _, user := range users {
+			result = append(result, user.Id)
+		}
+		return result
+	}
+	date := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+
+	cases := []struct {
+		sr  SearchRequest
+		ids []int
+	}{
+		{sr: SearchRequest{}, ids: []int{0, 1, 2, 3}},
+		{sr: SearchRequest{State: "nevada"}, ids: []int{1, 2}},
+		{sr: SearchRequest{City: "Hegins", State: "Nevada"}, ids: []int{2}},
+		{sr: SearchRequest{RegisteredFrom: date("2015-01-01")}, ids: []int{0, 2}},
+		{sr: SearchRequest{RegisteredTo: date("2015-01-01")}, ids: []int{1}},
+		{sr: SearchRequest{OrderField: "Registered", OrderBy: OrderByAsc}, ids: []int{3, 1, 0, 2}},
+		{sr: SearchRequest{OrderField: "Registered", OrderBy: OrderByDesc, RegisteredFrom: date("2014-06-01")}, ids: []int{2, 0}},
+	}
+	for i, c := range cases {
+		if got := ids(ds.Search(c.sr)); !reflect.DeepEqual(got, c.ids) {
+			t.Errorf("[%d] expected %v, got %v", i, c.ids, got)
+		}
+	}
+
+	facets := ds.Facets(SearchRequest{RegisteredFrom: date("2015-01-01")})
+	want := Facets{States: map[string]int{"New Jersey": 1, "Nevada": 1}, Cities: map[string]int{"Hegins": 2}}
+	if !reflect.DeepEqual(facets, want) {
+		t.Errorf("expected facets %+v, got %+v", want, facets)
+	}
+}
+
+func TestSearchByRegisteredAndState(t *testing.T) {
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	client := &SearchClient{URL: server.URL, AccessToken: "token"}
+
+	from := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	resp, err := client.FindUsers(SearchRequest{Limit: 10, RegisteredFrom: from, OrderField: "Registered", OrderBy: OrderByAsc})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := []int{}
+	for _, user := range resp.Users {
+		got = append(got, user.Id)
+	}
+	if !reflect.DeepEqual(got, []int{0, 23, 8}) {
+		t.Errorf("expected users registered since 2017 [0 23 8], got %v", got)
+	}
+
+	resp, err = client.FindUsers(SearchRequest{Limit: 10, State: "mississippi"})
+	if err != nil || len(resp.Users) != 1 || resp.Users[0].Id != 0 {
+		t.Errorf("expected only user 0 in Mississippi, got %+v, %v", resp, err)
+	}
+
+	facets, err := client.Facets(context.Background(), SearchRequest{RegisteredFrom: from})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]int{"Mississippi": 1, "Nevada": 1, "New Jersey": 1}; !reflect.DeepEqual(facets.States, want) {
+		t.Errorf("expected states %v, got %v", want, facets.States)
+	}
+
+	if _, err := client.Facets(context.Background(), SearchRequest{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseDateParams(t *testing.T) {
+	for _, raw := range []string{"2017-02-05", "2017-02-05T06:23:27Z", "2017-02-05T06:23:27 -03:00"} {
+		if _, err := parseDateParam("registered_from", raw); err != nil {
+			t.Errorf("%q: unexpected error %v", raw, err)
+		}
+	}
+	if _, err := parseDateParam("registered_to", "05.02.2017"); err == nil || err.Error() != "Invalid registered_to value" {
+		t.Errorf("expected Invalid registered_to value, got %v", err)
+	}
+}
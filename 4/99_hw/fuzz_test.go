@@ -0,0 +1,94 @@
+package hw4
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func FuzzParseSearchParams(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"limit=26&offset=0&order_by=-1&order_field=Name&query=nisi",
+		"limit=1&offset=25&order_by=1&order_field=Age",
+		"order_by=2",
+		"order_field=About",
+		"limit=-1",
+		"offset=x",
+		"limit=99999999999999999999",
+		"snapshot=ff20f070c1cb&query=%00",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		params, err := url.ParseQuery(raw)
+		if err != nil {
+			t.Skip()
+		}
+		sr, err := parseSearchParams(params)
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), "Invalid ") {
+				t.Fatalf("unexpected error text %q", err)
+			}
+			return
+		}
+
+		if sr.OrderBy != OrderByAsc && sr.OrderBy != OrderByAsIs && sr.OrderBy != OrderByDesc {
+			t.Errorf("accepted order_by %d", sr.OrderBy)
+		}
+		if _, ok := comparator(sr.OrderField); !ok && sr.OrderField != "" {
+			t.Errorf("accepted order_field %q", sr.OrderField)
+		}
+		if sr.Limit < 0 {
+			t.Errorf("accepted limit %d", sr.Limit)
+		}
+		if sr.Query != params.Get("query") || sr.Snapshot != params.Get("snapshot") {
+			t.Errorf("query or snapshot changed: %+v for %q", sr, raw)
+		}
+	})
+}
+
+func FuzzDecodeDataset(f *testing.F) {
+	if data, err := os.ReadFile("dataset.xml"); err == nil {
+		f.Add(data)
+	}
+	f.Add([]byte(`<root><row><id>1</id><first_name>A</first_name><age>3</age></row></root>`))
+	f.Add([]byte(`<root><row><id>x</id></row></root>`))
+	f.Add([]byte(`<root>`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ds, err := decodeDataset(data)
+		if err != nil {
+			if !errors.Is(err, errDatasetDecode) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			return
+		}
+
+		if ds.Len() != len(ds.Persons) {
+			t.Fatalf("Len %d, persons %d", ds.Len(), len(ds.Persons))
+		}
+		for field, cmp := range userComparators {
+			positions := slices.Clone(ds.sorted[field])
+			if !slices.IsSortedFunc(ds.sorted[field], func(a, b int) int { return cmp(ds.Persons[a], ds.Persons[b]) }) {
+				t.Errorf("index %s is not sorted", field)
+			}
+			slices.Sort(positions)
+			for i, pos := range positions {
+				if pos != i {
+					t.Fatalf("index %s is not a permutation of the dataset", field)
+				}
+			}
+		}
+		if got := len(ds.Search(SearchRequest{})); got != ds.Len() {
+			t.Errorf("empty query found %d of %d", got, ds.Len())
+		}
+		if again, _ := decodeDataset(data); again.Snapshot != ds.Snapshot {
+			t.Errorf("snapshot id is not stable")
+		}
+	})
+}
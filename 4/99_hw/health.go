@@ -0,0 +1,210 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	warmupRetryInterval    = time.Second
+)
+
+// serverReady поднимается, когда датасет загружен и индексы построены, и опускается при остановке
+var serverReady atomic.Bool
+
+// HealthzServer - liveness: процесс жив и отвечает
+func HealthzServer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.Write([]byte(`{"status": "ok"}`))
+}
+
+// ReadyzServer - readiness: можно ли слать трафик. 503, пока датасет не загружен и во время остановки
+func ReadyzServer(w http.ResponseWriter, r *http.Request) {
+	if !serverReady.Load() {
+		writeError(w, http.StatusServiceUnavailable, "not ready")
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.Write([]byte(`{"status": "ready"}`))
+}
+
+// Warmup загружает все датасеты и строит индексы, после чего /readyz отвечает 200.
+// Нужен тем, кто поднимает NewSearchMux сам, Serve вызывает его за вас
+func Warmup() error {
+	if _, err := defaultDatasets.Get(); err != nil {
+		return err
+	}
+	for _, store := range tenantStores() {
+		if _, err := store.Get(); err != nil {
+			return err
+		}
+	}
+	serverReady.Store(true)
+	return nil
+}
+
+// ServerConfig - настройки SearchServer для Serve
+type ServerConfig struct {
+	DatasetPath string
+	// токены, которым виден основной датасет. Пусто - всем, кроме токенов именованных датасетов
+	DefaultTokens []string
+	WriteTokens   []string
+	AdminTokens   []string
+	// именованные датасеты кроме основного
+	Datasets []DatasetConfig
+	// политики редактирования персональных данных по токенам
+	RedactionPolicies map[string]RedactionPolicy
+	// ключ HMAC для режима RedactHashed. Пустой - случайный, и отпечатки меняются после перезапуска
+	RedactionKey []byte
+	// если заданы, поменявшийся файл основного датасета подхватывается сам, только если изменений не больше,
+	// иначе до POST /admin/reload
+	ReloadLimits *DiffLimits
+	// журнал аудита поисков, пустой - не пишется. Ротация по AuditMaxBytes, хранится AuditMaxFiles старых файлов
+	AuditLogPath  string
+	AuditMaxBytes int64
+	AuditMaxFiles int
+	// принимать HTTP/2 без TLS (h2c) рядом с HTTP/1.1, см. TransportConfig.H2C
+	H2C bool
+	// сколько после отмены ctx отвечать 503 на /readyz, не закрывая listener, чтобы балансировщик
+	// успел убрать сервер из ротации. 0 - закрывать сразу
+	DrainDelay time.Duration
+	// сколько ждать запросы в полёте при остановке, 0 - 30 секунд
+	ShutdownTimeout time.Duration
+}
+
+// Serve отдаёт NewSearchMux на ln, пока не отменят ctx. Датасет грузится в фоне,
+// до его загрузки /readyz отвечает 503. После отмены ctx /readyz снова отвечает 503,
+// новые соединения не принимаются, а запросы в полёте дорабатывают не дольше ShutdownTimeout
+func Serve(ctx context.Context, ln net.Listener, cfg ServerConfig) error {
+	return serve(ctx, ln, cfg, NewSearchMux())
+}
+
+func serve(ctx context.Context, ln net.Listener, cfg ServerConfig, handler http.Handler) error {
+	if cfg.DatasetPath != "" {
+		defaultDatasets = &datasetStore{path: cfg.DatasetPath}
+	}
+	defaultDatasets.limits = cfg.ReloadLimits
+	for _, token := range cfg.DefaultTokens {
+		defaultTokens[token] = true
+	}
+	for _, token := range cfg.WriteTokens {
+		writeTokens[token] = true
+	}
+	for _, token := range cfg.AdminTokens {
+		adminTokens[token] = true
+	}
+	for _, dataset := range cfg.Datasets {
+		RegisterDataset(dataset)
+	}
+	for token, policy := range cfg.RedactionPolicies {
+		tokenPolicies[token] = policy
+	}
+	if len(cfg.RedactionKey) > 0 {
+		redactionKey = cfg.RedactionKey
+	}
+	// остались ручки, которые не вернулись даже после отмены контекста
+	handlersStuck := false
+	if cfg.AuditLogPath != "" {
+		l, err := OpenAuditLog(cfg.AuditLogPath, cfg.AuditMaxBytes, cfg.AuditMaxFiles)
+		if err != nil {
+			return err
+		}
+		auditLog = l
+		defer func() {
+			// ручка, которая так и не вернулась, ещё может писать в журнал
+			if !handlersStuck {
+				auditLog = nil
+				l.Close()
+			}
+		}()
+	}
+	shutdownTimeout := cfg.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+
+	serverReady.Store(false)
+	go warmup(ctx)
+
+	// считаем ручки сами: после server.Close они ещё могут работать, а Shutdown их уже не ждёт
+	var handlers sync.WaitGroup
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		handler.ServeHTTP(w, r)
+	})}
+	if cfg.H2C {
+		server.Protocols = &http.Protocols{}
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	server.RegisterOnShutdown(savedSearches.stopStreams)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ln) }()
+
+	select {
+	case err := <-served:
+		serverReady.Store(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	serverReady.Store(false)
+	// балансировщик должен успеть увидеть 503 на /readyz, пока соединения ещё принимаются
+	if cfg.DrainDelay > 0 {
+		select {
+		case <-time.After(cfg.DrainDelay):
+		case err := <-served:
+			return err
+		}
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// Close отменяет контексты запросов, но не ждёт ручки: ждём их ещё shutdownTimeout
+		server.Close()
+		finished := make(chan struct{})
+		go func() {
+			handlers.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(shutdownTimeout):
+			handlersStuck = true
+			accessLog.Error("handlers still running after shutdown", "timeout", shutdownTimeout.String())
+		}
+		return fmt.Errorf("requests did not finish in %s: %w", shutdownTimeout, err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// warmup повторяет Warmup, пока датасет не загрузится или не отменят ctx
+func warmup(ctx context.Context) {
+	for {
+		err := Warmup()
+		if err == nil {
+			// остановка могла начаться, пока грузился датасет
+			if ctx.Err() != nil {
+				serverReady.Store(false)
+			}
+			return
+		}
+		accessLog.Error("dataset warmup failed", "error", err.Error())
+		select {
+		case <-time.After(warmupRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
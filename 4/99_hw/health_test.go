@@ -0,0 +1,210 @@
+package hw4
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func resetReadiness(t *testing.T) {
+	t.Helper()
+	serverReady.Store(false)
+	t.Cleanup(func() { serverReady.Store(false) })
+}
+
+func TestProbes(t *testing.T) {
+	useDatasetCopy(t)
+	resetReadiness(t)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	status := func(path string) int {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Errorf("expected healthz 200, got %d", code)
+	}
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected readyz 503 before warmup, got %d", code)
+	}
+	if err := Warmup(); err != nil {
+		t.Fatalf("unexpected warmup error: %v", err)
+	}
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("expected readyz 200 after warmup, got %d", code)
+	}
+
+	defaultDatasets = &datasetStore{path: "missing.xml"}
+	serverReady.Store(false)
+	if err := Warmup(); err == nil || serverReady.Load() {
+		t.Errorf("warmup with missing dataset should fail and stay not ready")
+	}
+}
+
+// startServe поднимает serve с медленной ручкой /slow, которая отвечает после release
+func startServe(t *testing.T, cfg ServerConfig) (url string, started, release chan struct{}, stop func() error) {
+	t.Helper()
+	path := useDatasetCopy(t)
+	resetReadiness(t)
+	old := defaultDatasets
+	t.Cleanup(func() { defaultDatasets = old })
+
+	started, release = make(chan struct{}), make(chan struct{})
+	mux := NewSearchMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cant listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		cfg.DatasetPath = path
+		served <- serve(ctx, ln, cfg, mux)
+	}()
+
+	url = "http://" + ln.Addr().String()
+	deadline := time.Now().Add(5 * time.Second)
+	for !serverReady.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return url, started, release, func() error {
+		cancel()
+		return <-served
+	}
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	url, started, release, stop := startServe(t, ServerConfig{ShutdownTimeout: 5 * time.Second})
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- stop() }()
+
+	// пока запрос в полёте, сервер уже не ready, но и не остановлен
+	deadline := time.Now().Add(time.Second)
+	for serverReady.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if serverReady.Load() {
+		t.Errorf("server is still ready during shutdown")
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("server stopped with request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request got %q", got)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	url, started, release, stop := startServe(t, ServerConfig{ShutdownTimeout: 50 * time.Millisecond})
+	defer close(release)
+
+	go http.Get(url + "/slow")
+	<-started
+
+	if err := stop(); err == nil || !strings.Contains(err.Error(), "did not finish in 50ms") {
+		t.Errorf("expected shutdown timeout error, got %v", err)
+	}
+}
+
+func TestServeDrainDelay(t *testing.T) {
+	url, _, _, stop := startServe(t, ServerConfig{DrainDelay: 300 * time.Millisecond, ShutdownTimeout: time.Second})
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- stop() }()
+	for serverReady.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// listener ещё открыт, и балансировщик видит 503
+	resp, err := http.Get(url + "/readyz")
+	if err != nil {
+		t.Fatalf("server stopped accepting before drain delay: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from /readyz while draining, got %d", resp.StatusCode)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+}
+
+func TestServeShutdownTimeoutWaitsForHandlers(t *testing.T) {
+	path := useDatasetCopy(t)
+	resetReadiness(t)
+	old := defaultDatasets
+	t.Cleanup(func() { defaultDatasets = old })
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+	mux := NewSearchMux()
+	// не укладывается в ShutdownTimeout, но выходит после отмены контекста
+	mux.HandleFunc("/cleanup", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cant listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, ln, ServerConfig{DatasetPath: path, ShutdownTimeout: 50 * time.Millisecond}, mux)
+	}()
+	go http.Get("http://" + ln.Addr().String() + "/cleanup")
+	<-started
+	cancel()
+
+	if err := <-served; err == nil || !strings.Contains(err.Error(), "did not finish in 50ms") {
+		t.Errorf("expected shutdown timeout error, got %v", err)
+	}
+	if !finished.Load() {
+		t.Errorf("serve returned while a handler was still running")
+	}
+}
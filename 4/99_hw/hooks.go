@@ -0,0 +1,179 @@
+package hw4
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RequestInfo - что SearchClient собирается запросить
+type RequestInfo struct {
+	URL     string
+	Request SearchRequest
+	// номер попытки, начиная с 1
+	Attempt int
+}
+
+// ResponseInfo - что пришло в ответ
+type ResponseInfo struct {
+	StatusCode int
+	Duration   time.Duration
+	// ответ разобран и страница получена
+	OK       bool
+	Users    int
+	NextPage bool
+}
+
+// ClientHooks вызываются SearchClient вокруг каждого запроса:
+// BeforeRequest - перед отправкой, AfterResponse - когда получен ответ с любым кодом,
+// OnError - когда запрос закончился ошибкой (после AfterResponse, если ответ был).
+// При переключении между репликами хуки вызываются на каждую попытку, отказ
+// CircuitBreaker приходит только в OnError
+type ClientHooks interface {
+	BeforeRequest(ctx context.Context, info RequestInfo)
+	AfterResponse(ctx context.Context, info RequestInfo, resp ResponseInfo)
+	OnError(ctx context.Context, info RequestInfo, err error)
+}
+
+// doWithHooks выполняет do, вызывая вокруг него srv.Hooks
+func (srv *SearchClient) doWithHooks(ctx context.Context, info RequestInfo, do func() (*SearchResponse, int, error)) (*SearchResponse, int, error) {
+	if srv.Hooks == nil {
+		return do()
+	}
+
+	srv.Hooks.BeforeRequest(ctx, info)
+	started := time.Now()
+	result, status, err := do()
+
+	if status != 0 {
+		resp := ResponseInfo{StatusCode: status, Duration: time.Since(started)}
+		if result != nil {
+			resp.OK, resp.Users, resp.NextPage = true, len(result.Users), result.NextPage
+		}
+		srv.Hooks.AfterResponse(ctx, info, resp)
+	}
+	if err != nil {
+		srv.Hooks.OnError(ctx, info, err)
+	}
+	return result, status, err
+}
+
+type chainHooks []ClientHooks
+
+// ChainHooks вызывает несколько ClientHooks по очереди
+func ChainHooks(hooks ...ClientHooks) ClientHooks {
+	return chainHooks(hooks)
+}
+
+func (c chainHooks) BeforeRequest(ctx context.Context, info RequestInfo) {
+	for _, h := range c {
+		h.BeforeRequest(ctx, info)
+	}
+}
+
+func (c chainHooks) AfterResponse(ctx context.Context, info RequestInfo, resp ResponseInfo) {
+	for _, h := range c {
+		h.AfterResponse(ctx, info, resp)
+	}
+}
+
+func (c chainHooks) OnError(ctx context.Context, info RequestInfo, err error) {
+	for _, h := range c {
+		h.OnError(ctx, info, err)
+	}
+}
+
+// SlogHooks пишет запросы SearchClient в slog: отправку в Debug, ответ в Info, ошибки в Error
+type SlogHooks struct {
+	Logger *slog.Logger
+}
+
+func (h SlogHooks) attrs(info RequestInfo) []slog.Attr {
+	return []slog.Attr{
+		slog.String("url", info.URL),
+		slog.String("query", info.Request.Query),
+		slog.String("order_field", info.Request.OrderField),
+		slog.Int("order_by", info.Request.OrderBy),
+		slog.Int("limit", info.Request.Limit),
+		slog.Int("offset", info.Request.Offset),
+		slog.Int("attempt", info.Attempt),
+	}
+}
+
+func (h SlogHooks) BeforeRequest(ctx context.Context, info RequestInfo) {
+	h.Logger.LogAttrs(ctx, slog.LevelDebug, "search request", h.attrs(info)...)
+}
+
+func (h SlogHooks) AfterResponse(ctx context.Context, info RequestInfo, resp ResponseInfo) {
+	attrs := append(h.attrs(info),
+		slog.Int("status", resp.StatusCode),
+		slog.Duration("duration", resp.Duration),
+		slog.Int("users", resp.Users),
+		slog.Bool("next_page", resp.NextPage),
+	)
+	h.Logger.LogAttrs(ctx, slog.LevelInfo, "search response", attrs...)
+}
+
+func (h SlogHooks) OnError(ctx context.Context, info RequestInfo, err error) {
+	attrs := append(h.attrs(info), slog.String("error", err.Error()))
+	h.Logger.LogAttrs(ctx, slog.LevelError, "search error", attrs...)
+}
+
+// ClientStats - снимок StatsRecorder
+type ClientStats struct {
+	Requests    int
+	Retries     int
+	Pages       int
+	Errors      int
+	StatusCodes map[int]int
+	Latencies   []time.Duration
+}
+
+// StatsRecorder копит статистику запросов в памяти, удобно для тестов
+type StatsRecorder struct {
+	mu    sync.Mutex
+	stats ClientStats
+}
+
+func (r *StatsRecorder) BeforeRequest(ctx context.Context, info RequestInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Requests++
+	if info.Attempt > 1 {
+		r.stats.Retries++
+	}
+}
+
+func (r *StatsRecorder) AfterResponse(ctx context.Context, info RequestInfo, resp ResponseInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats.StatusCodes == nil {
+		r.stats.StatusCodes = map[int]int{}
+	}
+	r.stats.StatusCodes[resp.StatusCode]++
+	if resp.OK {
+		r.stats.Pages++
+	}
+	r.stats.Latencies = append(r.stats.Latencies, resp.Duration)
+}
+
+func (r *StatsRecorder) OnError(ctx context.Context, info RequestInfo, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Errors++
+}
+
+// Stats отдаёт копию накопленной статистики
+func (r *StatsRecorder) Stats() ClientStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.StatusCodes = make(map[int]int, len(r.stats.StatusCodes))
+	for code, n := range r.stats.StatusCodes {
+		stats.StatusCodes[code] = n
+	}
+	stats.Latencies = append([]time.Duration(nil), r.stats.Latencies...)
+	return stats
+}
@@ -0,0 +1,81 @@
+package hw4
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+	errorServer := httptest.NewServer(http.HandlerFunc(SearchInternalErrorServer))
+	defer errorServer.Close()
+
+	stats := &StatsRecorder{}
+	logs := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	hooks := ChainHooks(stats, SlogHooks{Logger: logger})
+
+	client := &SearchClient{URL: server.URL, AccessToken: "123", Hooks: hooks}
+	for offset := 0; ; offset += 10 {
+		resp, err := client.FindUsers(SearchRequest{Limit: 10, Offset: offset})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !resp.NextPage {
+			break
+		}
+	}
+
+	// ошибка валидации до запроса хуки не вызывает
+	if _, err := client.FindUsers(SearchRequest{Limit: -1}); err == nil {
+		t.Fatalf("expected limit error")
+	}
+
+	badClient := &SearchClient{URL: errorServer.URL, AccessToken: "123", Hooks: hooks}
+	if _, err := badClient.FindUsers(SearchRequest{}); err == nil {
+		t.Fatalf("expected server error")
+	}
+
+	noServerClient := &SearchClient{URL: "123", Hooks: hooks}
+	if _, err := noServerClient.FindUsers(SearchRequest{}); err == nil {
+		t.Fatalf("expected transport error")
+	}
+
+	got := stats.Stats()
+	if got.Requests != 6 || got.Pages != 4 || got.Errors != 2 || got.Retries != 0 {
+		t.Errorf("wrong stats: %+v", got)
+	}
+	if got.StatusCodes[http.StatusOK] != 4 || got.StatusCodes[http.StatusInternalServerError] != 1 || len(got.StatusCodes) != 2 {
+		t.Errorf("wrong status codes: %v", got.StatusCodes)
+	}
+	if len(got.Latencies) != 5 {
+		t.Errorf("expected 5 latencies, got %d", len(got.Latencies))
+	}
+
+	for msg, count := range map[string]int{
+		`msg="search request"`:  6,
+		`msg="search response"`: 5,
+		`msg="search error"`:    2,
+	} {
+		if n := strings.Count(logs.String(), msg); n != count {
+			t.Errorf("expected %d log lines with %s, got %d", count, msg, n)
+		}
+	}
+}
+
+func TestStatsRecorderSnapshot(t *testing.T) {
+	stats := &StatsRecorder{}
+	stats.AfterResponse(context.Background(), RequestInfo{}, ResponseInfo{StatusCode: 200})
+
+	snapshot := stats.Stats()
+	snapshot.StatusCodes[200] = 100
+	if stats.Stats().StatusCodes[200] != 1 {
+		t.Errorf("snapshot must not share state with recorder")
+	}
+}
@@ -0,0 +1,244 @@
+package hw4
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LintSeverity - насколько серьёзна проблема в датасете
+type LintSeverity string
+
+const (
+	// запись отброшена из чистого файла, поиск по ней даёт неверный результат
+	LintError LintSeverity = "error"
+	// запись годится, но что-то в ней подозрительно; в строгом режиме тоже проваливает проверку
+	LintWarning LintSeverity = "warning"
+)
+
+// LintIssue - одна проблема в dataset.xml
+type LintIssue struct {
+	Line     int
+	RowID    string
+	Field    string
+	Severity LintSeverity
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	row := i.RowID
+	if row == "" {
+		row = "?"
+	}
+	return fmt.Sprintf("line %d: row %s: %s: %s: %s", i.Line, row, i.Field, i.Severity, i.Message)
+}
+
+// LintReport - результат LintDataset
+type LintReport struct {
+	Rows   int
+	Issues []LintIssue
+	// записи без ошибок с обрезанными пробелами, по возрастанию id, из повторов id - первая
+	Clean []Person
+}
+
+// Failed - надо ли провалить проверку: при ошибках всегда, в строгом режиме и при предупреждениях
+func (r *LintReport) Failed(strict bool) bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == LintError || strict {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalized - чистый датасет в том же формате, что пишет /persons
+func (r *LintReport) Normalized() ([]byte, error) {
+	return encodeDataset(r.Clean)
+}
+
+// lintFields - строковые поля row; id и age разбираются отдельно, about не обрезается
+var lintFields = map[string]func(p *Person) *string{
+	"guid":          func(p *Person) *string { return &p.Guid },
+	"isActive":      func(p *Person) *string { return &p.IsActive },
+	"balance":       func(p *Person) *string { return &p.Balance },
+	"picture":       func(p *Person) *string { return &p.Picture },
+	"eyeColor":      func(p *Person) *string { return &p.EyeColor },
+	"first_name":    func(p *Person) *string { return &p.FirstName },
+	"last_name":     func(p *Person) *string { return &p.LastName },
+	"gender":        func(p *Person) *string { return &p.Gender },
+	"company":       func(p *Person) *string { return &p.Company },
+	"email":         func(p *Person) *string { return &p.Email },
+	"phone":         func(p *Person) *string { return &p.Phone },
+	"address":       func(p *Person) *string { return &p.Address },
+	"about":         func(p *Person) *string { return &p.About },
+	"registered":    func(p *Person) *string { return &p.Registered },
+	"favoriteFruit": func(p *Person) *string { return &p.FavoriteFruit },
+}
+
+// lintRow - сырая запись: значения полей как в файле и строки, где они начинаются
+type lintRow struct {
+	line   int
+	values map[string]string
+	lines  map[string]int
+	// поля, которые встретились повторно, и строки повторов
+	repeated map[string]int
+}
+
+// LintDataset читает dataset.xml построчно и собирает все проблемы с номерами строк.
+// Ошибка возвращается, только если файл не разбирается как XML
+func LintDataset(r io.Reader) (*LintReport, error) {
+	report := &LintReport{}
+	seen := map[int]int{}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		line, _ := decoder.InputPos()
+		row, err := readLintRow(decoder, line)
+		if err != nil {
+			return nil, err
+		}
+		report.Rows++
+
+		person, issues := lintPerson(row)
+		if first, ok := seen[person.ID]; ok && issues.valid("id") {
+			issues.add(row.lines["id"], "id", LintError, fmt.Sprintf("duplicate id %d, first seen on line %d", person.ID, first))
+		} else if issues.valid("id") {
+			seen[person.ID] = row.line
+		}
+		slices.SortFunc(issues.list, func(a, b LintIssue) int {
+			return cmp.Or(cmp.Compare(a.Line, b.Line), strings.Compare(a.Field, b.Field), strings.Compare(a.Message, b.Message))
+		})
+		report.Issues = append(report.Issues, issues.list...)
+		if !issues.hasErrors() {
+			report.Clean = append(report.Clean, person)
+		}
+	}
+
+	slices.SortStableFunc(report.Clean, func(a, b Person) int { return cmp.Compare(a.ID, b.ID) })
+	return report, nil
+}
+
+func readLintRow(decoder *xml.Decoder, line int) (lintRow, error) {
+	row := lintRow{line: line, values: map[string]string{}, lines: map[string]int{}, repeated: map[string]int{}}
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return row, err
+		}
+		switch tok := tok.(type) {
+		case xml.EndElement:
+			return row, nil
+		case xml.StartElement:
+			fieldLine, _ := decoder.InputPos()
+			var value string
+			if err := decoder.DecodeElement(&value, &tok); err != nil {
+				return row, err
+			}
+			name := tok.Name.Local
+			if _, dup := row.values[name]; dup {
+				// как и xml.Unmarshal, берём последнее значение
+				row.repeated[name] = fieldLine
+			} else {
+				row.lines[name] = fieldLine
+			}
+			row.values[name] = value
+		}
+	}
+}
+
+// lintIssues - проблемы одной записи
+type lintIssues struct {
+	rowID string
+	list  []LintIssue
+}
+
+func (l *lintIssues) add(line int, field string, severity LintSeverity, message string) {
+	l.list = append(l.list, LintIssue{Line: line, RowID: l.rowID, Field: field, Severity: severity, Message: message})
+}
+
+// valid - нет ли ошибок в поле
+func (l *lintIssues) valid(field string) bool {
+	return !slices.ContainsFunc(l.list, func(i LintIssue) bool { return i.Field == field && i.Severity == LintError })
+}
+
+func (l *lintIssues) hasErrors() bool {
+	return slices.ContainsFunc(l.list, func(i LintIssue) bool { return i.Severity == LintError })
+}
+
+func lintPerson(row lintRow) (Person, *lintIssues) {
+	issues := &lintIssues{rowID: strings.TrimSpace(row.values["id"])}
+	line := func(field string) int {
+		if line, ok := row.lines[field]; ok {
+			return line
+		}
+		return row.line
+	}
+
+	person := Person{}
+	for name, repeatLine := range row.repeated {
+		issues.add(repeatLine, name, LintWarning, name+" is set more than once")
+	}
+	for name := range row.values {
+		if name != "id" && name != "age" && lintFields[name] == nil {
+			issues.add(line(name), name, LintWarning, "unknown field")
+		}
+	}
+	for name, field := range lintFields {
+		value := row.values[name]
+		if trimmed := strings.TrimSpace(value); trimmed != value && name != "about" {
+			issues.add(line(name), name, LintWarning, "surrounding whitespace")
+			value = trimmed
+		}
+		*field(&person) = value
+	}
+
+	for name, target := range map[string]*int{"id": &person.ID, "age": &person.Age} {
+		raw, ok := row.values[name]
+		if !ok {
+			issues.add(row.line, name, LintError, name+" is missing")
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			issues.add(line(name), name, LintError, fmt.Sprintf("%s %q is not a number", name, raw))
+			continue
+		}
+		*target = n
+	}
+
+	for _, problem := range personProblems(person) {
+		// id и age, которые не разобрались, уже в отчёте, а нулевое значение на их месте валидно
+		issues.add(line(problem.field), problem.field, LintError, problem.message)
+	}
+	if person.LastName == "" {
+		issues.add(line("last_name"), "last_name", LintWarning, "last_name is empty")
+	}
+	if person.Email == "" {
+		issues.add(line("email"), "email", LintWarning, "email is empty")
+	}
+	if _, ok := person.BalanceCents(); !ok {
+		issues.add(line("balance"), "balance", LintWarning, fmt.Sprintf("balance %q does not look like $1,234.56", person.Balance))
+	}
+	if city, state := person.Location(); city == "" || state == "" {
+		issues.add(line("address"), "address", LintWarning, "address has no city and state")
+	}
+	if person.Registered == "" {
+		issues.add(line("registered"), "registered", LintWarning, "registered is empty")
+	}
+	return person, issues
+}
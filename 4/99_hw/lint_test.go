@@ -0,0 +1,126 @@
+package hw4
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const lintFixture = `<?xml version="1.0" encoding="UTF-8" ?>
+<root>
+  <row>
+    <id>1</id>
+    <isActive>true</isActive>
+    <balance>$1,000.50</balance>
+    <age>30</age>
+    <first_name> Ann </first_name>
+    <last_name>Lee</last_name>
+    <gender>female</gender>
+    <email>ann@example.com</email>
+    <address>1 Main Street, Springfield, Ohio, 1000</address>
+    <registered>2017-02-05T06:23:27 -03:00</registered>
+  </row>
+  <row>
+    <id>1</id>
+    <age>thirty</age>
+    <first_name></first_name>
+    <last_name>Dup</last_name>
+    <gender>male</gender>
+    <email>not an email</email>
+    <address>1 Main Street, Springfield, Ohio, 1000</address>
+    <balance>$1.00</balance>
+    <registered>yesterday</registered>
+  </row>
+  <row>
+    <id>x</id>
+    <age>20</age>
+    <first_name>Bob</first_name>
+    <last_name>Ray</last_name>
+    <gender>male</gender>
+    <email>bob@example.com</email>
+    <address>1 Main Street, Springfield, Ohio, 1000</address>
+    <balance>$1.00</balance>
+    <registered>2017-02-05T06:23:27 -03:00</registered>
+  </row>
+  <row>
+    <id>0</id>
+    <age>20</age>
+    <age>21</age>
+    <first_name>Cy</first_name>
+    <last_name>Ho</last_name>
+    <gender>male</gender>
+    <email>cy@example.com</email>
+    <address>nowhere</address>
+    <balance>lots</balance>
+    <registered>2017-02-05T06:23:27 -03:00</registered>
+    <salary>1</salary>
+  </row>
+</root>
+`
+
+func TestLintDataset(t *testing.T) {
+	report, err := LintDataset(strings.NewReader(lintFixture))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, issue := range report.Issues {
+		got = append(got, issue.String())
+	}
+	want := []string{
+		`line 8: row 1: first_name: warning: surrounding whitespace`,
+		`line 16: row 1: id: error: duplicate id 1, first seen on line 3`,
+		`line 17: row 1: age: error: age "thirty" is not a number`,
+		`line 18: row 1: first_name: error: first_name is required`,
+		`line 21: row 1: email: error: email is malformed`,
+		`line 24: row 1: registered: error: registered must look like 2006-01-02T15:04:05 -07:00`,
+		`line 27: row x: id: error: id "x" is not a number`,
+		`line 40: row 0: age: warning: age is set more than once`,
+		`line 45: row 0: address: warning: address has no city and state`,
+		`line 46: row 0: balance: warning: balance "lots" does not look like $1,234.56`,
+		`line 48: row 0: salary: warning: unknown field`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong issues:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if report.Rows != 4 || len(report.Clean) != 2 || report.Clean[0].ID != 0 || report.Clean[1].ID != 1 {
+		t.Fatalf("wrong clean rows: %d of %d", len(report.Clean), report.Rows)
+	}
+	if report.Clean[0].Age != 21 || report.Clean[1].FirstName != "Ann" {
+		t.Errorf("wrong normalized values: %+v", report.Clean)
+	}
+	if !report.Failed(false) || !report.Failed(true) {
+		t.Errorf("report with errors must fail")
+	}
+
+	data, err := report.Normalized()
+	if err != nil {
+		t.Fatalf("cant normalize: %v", err)
+	}
+	again, err := LintDataset(bytes.NewReader(data))
+	if err != nil || again.Rows != 2 || again.Failed(false) {
+		t.Errorf("normalized dataset must have no errors: %+v, %v", again, err)
+	}
+}
+
+func TestLintDatasetClean(t *testing.T) {
+	f, err := os.Open("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	report, err := LintDataset(f)
+	if err != nil || report.Rows != 35 || len(report.Issues) != 0 || report.Failed(true) {
+		t.Errorf("dataset.xml must be clean: %+v, %v", report.Issues, err)
+	}
+}
+
+func TestLintDatasetBadXML(t *testing.T) {
+	if _, err := LintDataset(strings.NewReader("<root><row><id>1</row></root>")); err == nil {
+		t.Errorf("expected XML error")
+	}
+}
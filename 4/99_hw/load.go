@@ -0,0 +1,431 @@
+package hw4
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultLoadConcurrency = 64
+
+// классы ошибок в LoadReport.Errors - по тем же причинам, что различает SearchClient
+const (
+	LoadOK               = "ok"
+	LoadBadAccessToken   = "bad_access_token"
+	LoadBadRequest       = "bad_request"
+	LoadServerFatal      = "server_fatal"
+	LoadCircuitOpen      = "circuit_open"
+	LoadSnapshotExpired  = "snapshot_expired"
+	LoadDatasetNotFound  = "dataset_not_found"
+	LoadResponseTooLarge = "response_too_large"
+	LoadTimeout          = "timeout"
+	LoadCanceled         = "canceled"
+	LoadOther            = "other"
+)
+
+// LoadTarget - куда слать нагрузку, обычно *SearchClient
+type LoadTarget interface {
+	FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error)
+}
+
+// LoadPlan - какие запросы слать. Без весов запросы идут по кругу в исходном порядке,
+// с весами - выбираются случайно, но одинаково при одном LoadConfig.Seed
+type LoadPlan struct {
+	Requests []SearchRequest
+	// вес каждого из Requests, nil - по порядку
+	Weights []int
+}
+
+// LoadPlanFromAudit - записанные в журнал аудита поиски в исходном порядке.
+// dataset отбирает поиски по одному датасету, пустой - по основному
+func LoadPlanFromAudit(r io.Reader, dataset string) (LoadPlan, error) {
+	plan := LoadPlan{}
+	// строки журнала не ограничены по длине, см. AuditQuery.scan
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return LoadPlan{}, err
+		}
+		var rec AuditRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return LoadPlan{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		// выгрузки, фасеты и остальные ручки не поиск, их не повторить через FindUsers
+		if rec.Dataset != dataset || rec.Endpoint != "" {
+			continue
+		}
+		req, err := parseLoadParams(rec.Params)
+		if err != nil {
+			return LoadPlan{}, fmt.Errorf("line %d: %w", line, err)
+		}
+		// FindUsers сам добавит к limit запись, которую добавил и при записи в журнал
+		if rec.LimitProbe && req.Limit > 0 {
+			req.Limit--
+		}
+		plan.Requests = append(plan.Requests, req)
+	}
+	if len(plan.Requests) == 0 {
+		return LoadPlan{}, errors.New("no searches to replay")
+	}
+	return plan, nil
+}
+
+// LoadPlanFromMix читает синтетическую смесь: json-массив [{"weight": 3, "params": "query=Boyd&limit=10"}],
+// params - как в запросе к SearchServer
+func LoadPlanFromMix(r io.Reader) (LoadPlan, error) {
+	var mix []struct {
+		Weight int    `json:"weight"`
+		Params string `json:"params"`
+	}
+	if err := json.NewDecoder(r).Decode(&mix); err != nil {
+		return LoadPlan{}, err
+	}
+	plan := LoadPlan{}
+	for i, entry := range mix {
+		if entry.Weight <= 0 {
+			return LoadPlan{}, fmt.Errorf("mix entry %d: weight must be > 0", i)
+		}
+		req, err := parseLoadParams(entry.Params)
+		if err != nil {
+			return LoadPlan{}, fmt.Errorf("mix entry %d: %w", i, err)
+		}
+		plan.Requests = append(plan.Requests, req)
+		plan.Weights = append(plan.Weights, entry.Weight)
+	}
+	if len(plan.Requests) == 0 {
+		return LoadPlan{}, errors.New("empty mix")
+	}
+	return plan, nil
+}
+
+func parseLoadParams(raw string) (SearchRequest, error) {
+	params, err := url.ParseQuery(raw)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	return parseSearchParams(params)
+}
+
+// loadSequence - i-й запрос плана
+type loadSequence struct {
+	plan  LoadPlan
+	rng   *rand.Rand
+	total int
+}
+
+func newLoadSequence(plan LoadPlan, seed uint64) *loadSequence {
+	seq := &loadSequence{plan: plan, rng: rand.New(rand.NewPCG(seed, seed))}
+	for _, w := range plan.Weights {
+		seq.total += w
+	}
+	return seq
+}
+
+func (s *loadSequence) request(i int) SearchRequest {
+	if s.plan.Weights == nil {
+		return s.plan.Requests[i%len(s.plan.Requests)]
+	}
+	n := s.rng.IntN(s.total)
+	for j, w := range s.plan.Weights {
+		if n < w {
+			return s.plan.Requests[j]
+		}
+		n -= w
+	}
+	return s.plan.Requests[len(s.plan.Requests)-1]
+}
+
+// LoadConfig - как слать нагрузку
+type LoadConfig struct {
+	// сколько запросов в секунду запускать
+	QPS float64
+	// сколько всего запросов
+	Requests int
+	// не больше стольких запросов одновременно, 0 - 64. Запрос, которому не хватило места,
+	// ждёт, и ожидание входит в его задержку
+	Concurrency int
+	// от него зависит, какие запросы выберутся из плана с весами
+	Seed uint64
+	// подпись прогона в отчёте, например версия сборки
+	Label string
+
+	clock loadClock
+}
+
+// loadClock - время для RunLoad, в тестах подменяется
+type loadClock interface {
+	Now() time.Time
+	// SleepUntil ждёт до t или отмены ctx
+	SleepUntil(ctx context.Context, t time.Time) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) SleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LatencySummary - задержки успешных запросов
+type LatencySummary struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// LoadReport - итог RunLoad, в json - для сравнения прогонов разных сборок. Длительности в наносекундах
+type LoadReport struct {
+	Label       string    `json:"label,omitempty"`
+	Started     time.Time `json:"started"`
+	TargetQPS   float64   `json:"target_qps"`
+	Concurrency int       `json:"concurrency"`
+	Seed        uint64    `json:"seed"`
+	// сколько запросов отправлено и сколько из них успешны
+	Requests  int `json:"requests"`
+	Succeeded int `json:"succeeded"`
+	// от первого запуска до последнего ответа
+	Elapsed time.Duration `json:"elapsed"`
+	// ответов в секунду, успешных и нет
+	Throughput float64 `json:"throughput"`
+	// неуспешные запросы по классам: LoadBadRequest, LoadTimeout, ...
+	Errors  map[string]int `json:"errors"`
+	Latency LatencySummary `json:"latency"`
+}
+
+// RunLoad запускает запросы плана по расписанию QPS, не дожидаясь ответов на предыдущие.
+// Задержка считается от запланированного момента запуска, так что если SearchServer
+// не успевает, ожидание в очереди тоже попадает в перцентили. Отмена ctx останавливает
+// запуск новых запросов, отчёт собирается по уже отправленным
+func RunLoad(ctx context.Context, target LoadTarget, plan LoadPlan, cfg LoadConfig) (*LoadReport, error) {
+	if cfg.QPS <= 0 {
+		return nil, errors.New("qps must be > 0")
+	}
+	if cfg.Requests <= 0 {
+		return nil, errors.New("requests must be > 0")
+	}
+	if len(plan.Requests) == 0 {
+		return nil, errors.New("empty load plan")
+	}
+	if plan.Weights != nil && len(plan.Weights) != len(plan.Requests) {
+		return nil, errors.New("load plan weights do not match requests")
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultLoadConcurrency
+	}
+	clock := cfg.clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	type outcome struct {
+		class   string
+		latency time.Duration
+	}
+	outcomes := make([]outcome, 0, cfg.Requests)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	seq := newLoadSequence(plan, cfg.Seed)
+	interval := time.Duration(float64(time.Second) / cfg.QPS)
+	slots := make(chan struct{}, concurrency)
+	started := clock.Now()
+	for i := 0; i < cfg.Requests; i++ {
+		req := seq.request(i)
+		// место занимается до ожидания: с одним местом запросы строго по очереди, и время в тестах детерминировано
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		scheduled := started.Add(time.Duration(i) * interval)
+		if err := clock.SleepUntil(ctx, scheduled); err != nil {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			_, err := target.FindUsersContext(ctx, req)
+			result := outcome{class: LoadErrorClass(err), latency: clock.Now().Sub(scheduled)}
+			if err != nil && ctx.Err() != nil {
+				// SearchClient не оборачивает ошибку контекста
+				result.class = LoadCanceled
+			}
+			mu.Lock()
+			outcomes = append(outcomes, result)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	report := &LoadReport{
+		Label:       cfg.Label,
+		Started:     started,
+		TargetQPS:   cfg.QPS,
+		Concurrency: concurrency,
+		Seed:        cfg.Seed,
+		Requests:    len(outcomes),
+		Elapsed:     clock.Now().Sub(started),
+		Errors:      map[string]int{},
+	}
+	var latencies []time.Duration
+	for _, o := range outcomes {
+		if o.class != LoadOK {
+			report.Errors[o.class]++
+			continue
+		}
+		latencies = append(latencies, o.latency)
+	}
+	report.Succeeded = len(latencies)
+	if report.Elapsed > 0 {
+		report.Throughput = float64(report.Requests) / report.Elapsed.Seconds()
+	}
+	report.Latency = summarizeLatencies(latencies)
+	return report, nil
+}
+
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	slices.Sort(latencies)
+	var sum time.Duration
+	for _, l := range latencies {
+		sum += l
+	}
+	// ближайший ранг: наименьшая задержка, не меньше которой p долей запросов
+	percentile := func(p float64) time.Duration {
+		rank := int(math.Ceil(p * float64(len(latencies))))
+		return latencies[max(rank, 1)-1]
+	}
+	return LatencySummary{
+		Min:  latencies[0],
+		Mean: sum / time.Duration(len(latencies)),
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		P999: percentile(0.999),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// LoadErrorClass - класс ошибки FindUsers для LoadReport.Errors, LoadOK для nil
+func LoadErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return LoadOK
+	case errors.Is(err, ErrBadAccessToken):
+		return LoadBadAccessToken
+	case errors.Is(err, ErrBadRequest):
+		return LoadBadRequest
+	case errors.Is(err, ErrServerFatal):
+		return LoadServerFatal
+	case errors.Is(err, ErrCircuitOpen):
+		return LoadCircuitOpen
+	case errors.Is(err, ErrSnapshotExpired):
+		return LoadSnapshotExpired
+	case errors.Is(err, ErrDatasetNotFound):
+		return LoadDatasetNotFound
+	case errors.Is(err, ErrResponseTooLarge):
+		return LoadResponseTooLarge
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return LoadTimeout
+	case errors.Is(err, context.Canceled):
+		return LoadCanceled
+	}
+	return LoadOther
+}
+
+// WriteJSON пишет отчёт для сравнения с другими прогонами через CompareLoadReports
+func (r *LoadReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func ReadLoadReport(r io.Reader) (*LoadReport, error) {
+	report := &LoadReport{}
+	if err := json.NewDecoder(r).Decode(report); err != nil {
+		return nil, fmt.Errorf("cant unpack load report: %w", err)
+	}
+	return report, nil
+}
+
+// ErrorRate - доля неуспешных запросов в процентах
+func (r *LoadReport) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Requests-r.Succeeded) / float64(r.Requests) * 100
+}
+
+// LoadDelta - как изменилась одна метрика между прогонами
+type LoadDelta struct {
+	Metric     string
+	Base, Next float64
+	// на сколько процентов стало хуже, отрицательное - лучше. Для error_rate - в процентных пунктах
+	Regression float64
+}
+
+// CompareLoadReports сравнивает пропускную способность, долю ошибок и перцентили задержки (в миллисекундах)
+func CompareLoadReports(base, next *LoadReport) []LoadDelta {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	// worse - на сколько процентов next хуже base, если больше - хуже
+	worse := func(base, next float64) float64 {
+		switch {
+		case base == next:
+			return 0
+		case base == 0:
+			return math.Inf(1)
+		}
+		return (next - base) / base * 100
+	}
+
+	deltas := []LoadDelta{
+		{Metric: "throughput", Base: base.Throughput, Next: next.Throughput, Regression: -worse(base.Throughput, next.Throughput)},
+		{Metric: "error_rate", Base: base.ErrorRate(), Next: next.ErrorRate(), Regression: next.ErrorRate() - base.ErrorRate()},
+	}
+	for _, p := range []struct {
+		name       string
+		base, next time.Duration
+	}{
+		{"p50", base.Latency.P50, next.Latency.P50},
+		{"p90", base.Latency.P90, next.Latency.P90},
+		{"p99", base.Latency.P99, next.Latency.P99},
+		{"p999", base.Latency.P999, next.Latency.P999},
+		{"max", base.Latency.Max, next.Latency.Max},
+	} {
+		deltas = append(deltas, LoadDelta{Metric: p.name, Base: ms(p.base), Next: ms(p.next), Regression: worse(ms(p.base), ms(p.next))})
+	}
+	return deltas
+}
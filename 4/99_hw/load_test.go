@@ -0,0 +1,218 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock - время, которое идёт, только когда его двигают
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) SleepUntil(ctx context.Context, t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+	return ctx.Err()
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// fakeTarget "отвечает" за заданное по Query время и с заданной ошибкой
+type fakeTarget struct {
+	clock   *fakeClock
+	latency map[string]time.Duration
+	errs    map[string]error
+	queries []string
+}
+
+func (f *fakeTarget) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	f.queries = append(f.queries, req.Query)
+	f.clock.advance(f.latency[req.Query])
+	if err := f.errs[req.Query]; err != nil {
+		return nil, err
+	}
+	return &SearchResponse{}, nil
+}
+
+func TestRunLoad(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	target := &fakeTarget{
+		clock:   clock,
+		latency: map[string]time.Duration{"fast": 10 * time.Millisecond, "slow": 250 * time.Millisecond, "bad": time.Millisecond},
+		errs:    map[string]error{"bad": badRequestError("unknown bad request error: nope")},
+	}
+	plan := LoadPlan{Requests: []SearchRequest{{Query: "fast"}, {Query: "fast"}, {Query: "slow"}, {Query: "bad"}}}
+
+	// 10 запросов в секунду, по одному: медленный занимает 250ms, и следующие за ним стартуют с опозданием,
+	// которое входит в их задержку
+	report, err := RunLoad(context.Background(), target, plan, LoadConfig{QPS: 10, Requests: 8, Concurrency: 1, Label: "test", clock: clock})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := &LoadReport{
+		Label:       "test",
+		Started:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		TargetQPS:   10,
+		Concurrency: 1,
+		Requests:    8,
+		Succeeded:   6,
+		Elapsed:     851 * time.Millisecond,
+		Throughput:  8 / 0.851,
+		Errors:      map[string]int{LoadBadRequest: 2},
+		Latency: LatencySummary{
+			Min:  10 * time.Millisecond,
+			Mean: 98500 * time.Microsecond,
+			P50:  10 * time.Millisecond,
+			P90:  250 * time.Millisecond,
+			P99:  250 * time.Millisecond,
+			P999: 250 * time.Millisecond,
+			Max:  250 * time.Millisecond,
+		},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("wrong report:\n %#v\n expected:\n %#v", report, expected)
+	}
+}
+
+func TestRunLoadWeightedIsDeterministic(t *testing.T) {
+	plan := LoadPlan{Requests: []SearchRequest{{Query: "a"}, {Query: "b"}}, Weights: []int{3, 1}}
+	run := func(seed uint64) []string {
+		clock := &fakeClock{}
+		target := &fakeTarget{clock: clock}
+		if _, err := RunLoad(context.Background(), target, plan, LoadConfig{QPS: 1000, Requests: 400, Concurrency: 1, Seed: seed, clock: clock}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return target.queries
+	}
+
+	first := run(1)
+	if !reflect.DeepEqual(first, run(1)) {
+		t.Errorf("same seed gave different sequences")
+	}
+	if reflect.DeepEqual(first, run(2)) {
+		t.Errorf("different seeds gave the same sequence")
+	}
+	if a := strings.Count(strings.Join(first, ""), "a"); a < 250 || a > 350 {
+		t.Errorf("expected about 300 of 400 requests with weight 3, got %d", a)
+	}
+}
+
+func TestRunLoadCanceled(t *testing.T) {
+	clock := &fakeClock{}
+	target := &fakeTarget{clock: clock}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := RunLoad(ctx, target, LoadPlan{Requests: []SearchRequest{{}}}, LoadConfig{QPS: 1, Requests: 10, clock: clock})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Requests != 0 || len(target.queries) != 0 {
+		t.Errorf("expected no requests after cancel, got %d", report.Requests)
+	}
+}
+
+func TestLoadErrorClass(t *testing.T) {
+	cases := map[error]string{
+		nil:                                 LoadOK,
+		ErrBadAccessToken:                   LoadBadAccessToken,
+		badRequestError("limit"):            LoadBadRequest,
+		ErrServerFatal:                      LoadServerFatal,
+		ErrCircuitOpen:                      LoadCircuitOpen,
+		ErrSnapshotExpired:                  LoadSnapshotExpired,
+		ErrDatasetNotFound:                  LoadDatasetNotFound,
+		ErrResponseTooLarge:                 LoadResponseTooLarge,
+		fmt.Errorf("%w for q=", ErrTimeout): LoadTimeout,
+		errors.New("timeout for q="):        LoadOther,
+		context.Canceled:                    LoadCanceled,
+		errors.New("unknown error"):         LoadOther,
+	}
+	for err, expected := range cases {
+		if class := LoadErrorClass(err); class != expected {
+			t.Errorf("%v: expected %s, got %s", err, expected, class)
+		}
+	}
+}
+
+func TestLoadPlanFromAudit(t *testing.T) {
+	log := strings.Join([]string{
+		`{"token":"sha256:1","params":"limit=10&offset=0&order_by=-1&order_field=Age&query=Boyd","limit_probe":true}`,
+		`{"token":"sha256:1","dataset":"eu","params":"limit=5&query=Hilda","limit_probe":true}`,
+		`{"token":"sha256:2","params":"limit=3&state=mississippi"}`,
+		`{"token":"sha256:2","params":"limit=0&query=Boyd"}`,
+	}, "\n")
+	plan, err := LoadPlanFromAudit(strings.NewReader(log), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := LoadPlan{Requests: []SearchRequest{
+		{Limit: 9, Query: "Boyd", OrderField: "Age", OrderBy: OrderByAsc},
+		{Limit: 3, State: "mississippi"},
+		{Query: "Boyd"},
+	}}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("wrong plan:\n %#v\n expected:\n %#v", plan, expected)
+	}
+
+	if _, err := LoadPlanFromAudit(strings.NewReader(`{"params":"order_by=7"}`), ""); err == nil || err.Error() != "line 1: Invalid order_by value" {
+		t.Errorf("expected order_by error, got %v", err)
+	}
+}
+
+func TestLoadPlanFromMix(t *testing.T) {
+	plan, err := LoadPlanFromMix(strings.NewReader(`[{"weight": 3, "params": "query=Boyd&limit=10"}, {"weight": 1, "params": "city=Sanford"}]`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := LoadPlan{Requests: []SearchRequest{{Query: "Boyd", Limit: 10}, {City: "Sanford"}}, Weights: []int{3, 1}}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("wrong plan:\n %#v\n expected:\n %#v", plan, expected)
+	}
+
+	for _, mix := range []string{`[]`, `[{"weight": 0, "params": "query=a"}]`, `[{"weight": 1, "params": "limit=x"}]`} {
+		if _, err := LoadPlanFromMix(strings.NewReader(mix)); err == nil {
+			t.Errorf("expected error for %s", mix)
+		}
+	}
+}
+
+func TestRunLoadAgainstServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer server.Close()
+
+	client := &SearchClient{URL: server.URL, AccessToken: "123"}
+	plan := LoadPlan{Requests: []SearchRequest{{Limit: 10, Query: "Boyd"}, {Limit: 10, OrderField: "Unknown"}}}
+	report, err := RunLoad(context.Background(), client, plan, LoadConfig{QPS: 500, Requests: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Requests != 20 || report.Succeeded != 10 || report.Errors[LoadBadRequest] != 10 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	if report.Latency.P50 <= 0 || report.Latency.P99 < report.Latency.P50 || report.Throughput <= 0 {
+		t.Errorf("wrong latency: %+v", report.Latency)
+	}
+}
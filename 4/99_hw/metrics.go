@@ -0,0 +1,138 @@
+package hw4
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// границы бакетов гистограммы времени ответа, в секундах
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+type requestLabels struct {
+	handler    string
+	orderField string
+	status     int
+}
+
+type latencyHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// searchMetrics собирает метрики SearchServer и отдаёт их в текстовом формате Prometheus
+type searchMetrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]*latencyHistogram
+	// по имени датасета, основной - под пустым именем
+	datasets map[string]datasetGauges
+}
+
+type datasetGauges struct {
+	size              int
+	indexBuildSeconds float64
+}
+
+var defaultMetrics = newSearchMetrics()
+
+func newSearchMetrics() *searchMetrics {
+	return &searchMetrics{requests: map[requestLabels]*latencyHistogram{}, datasets: map[string]datasetGauges{}}
+}
+
+func (m *searchMetrics) observeRequest(labels requestLabels, duration time.Duration) {
+	seconds := duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.requests[labels]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[labels] = h
+	}
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *searchMetrics) observeDataset(name string, ds *Dataset) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.datasets[name] = datasetGauges{size: ds.Len(), indexBuildSeconds: ds.IndexBuildTime.Seconds()}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (m *searchMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	slices.SortFunc(labels, func(a, b requestLabels) int {
+		return cmp.Or(
+			cmp.Compare(a.handler, b.handler),
+			cmp.Compare(a.orderField, b.orderField),
+			cmp.Compare(a.status, b.status),
+		)
+	})
+
+	buf := &bytes.Buffer{}
+
+	fmt.Fprintln(buf, "# HELP search_requests_total Number of handled SearchServer requests.")
+	fmt.Fprintln(buf, "# TYPE search_requests_total counter")
+	for _, l := range labels {
+		fmt.Fprintf(buf, "search_requests_total{handler=%q,order_field=%q,status=\"%d\"} %d\n",
+			l.handler, l.orderField, l.status, m.requests[l].count)
+	}
+
+	fmt.Fprintln(buf, "# HELP search_request_duration_seconds SearchServer request latency.")
+	fmt.Fprintln(buf, "# TYPE search_request_duration_seconds histogram")
+	for _, l := range labels {
+		h := m.requests[l]
+		prefix := fmt.Sprintf("handler=%q,order_field=%q,status=\"%d\"", l.handler, l.orderField, l.status)
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(buf, "search_request_duration_seconds_bucket{%s,le=%q} %d\n", prefix, formatFloat(le), h.buckets[i])
+		}
+		fmt.Fprintf(buf, "search_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", prefix, h.count)
+		fmt.Fprintf(buf, "search_request_duration_seconds_sum{%s} %s\n", prefix, formatFloat(h.sum))
+		fmt.Fprintf(buf, "search_request_duration_seconds_count{%s} %d\n", prefix, h.count)
+	}
+
+	datasets := slices.Sorted(maps.Keys(m.datasets))
+
+	fmt.Fprintln(buf, "# HELP search_dataset_size Number of persons in the loaded dataset, dataset=\"\" is the default one.")
+	fmt.Fprintln(buf, "# TYPE search_dataset_size gauge")
+	for _, name := range datasets {
+		fmt.Fprintf(buf, "search_dataset_size{dataset=%q} %d\n", name, m.datasets[name].size)
+	}
+
+	fmt.Fprintln(buf, "# HELP search_index_build_seconds Time spent building dataset indexes on the last load.")
+	fmt.Fprintln(buf, "# TYPE search_index_build_seconds gauge")
+	for _, name := range datasets {
+		fmt.Fprintf(buf, "search_index_build_seconds{dataset=%q} %s\n", name, formatFloat(m.datasets[name].indexBuildSeconds))
+	}
+
+	return buf.WriteTo(w)
+}
+
+func (m *searchMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
@@ -0,0 +1,114 @@
+package hw4
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// accessLog - структурированный лог запросов к SearchServer
+var accessLog = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// redactToken - в логи вместо токена идёт его отпечаток, по которому можно сопоставить запросы
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
+
+// metricOrderField ограничивает значения лейбла order_field известными полями
+func metricOrderField(orderField string) string {
+	if orderField == "" {
+		return defaultOrderField
+	}
+	if _, ok := comparator(orderField); !ok {
+		return "invalid"
+	}
+	return orderField
+}
+
+// statusWriter запоминает код ответа и размер тела для логов и метрик
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// instrument оборачивает ручку: продолжает трассу из traceparent, пишет access-лог и метрики
+func instrument(handler string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+
+		parent, hasParent := ParseTraceParent(r.Header.Get(TraceParentHeader))
+		span := NewTraceContext()
+		if hasParent {
+			span = parent.Child()
+		}
+		r = r.WithContext(ContextWithTrace(r.Context(), span))
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		// 404 - так tenantHandler отказывает токенам чужого датасета
+		if sw.status != http.StatusUnauthorized && sw.status != http.StatusForbidden && sw.status != http.StatusNotFound {
+			activeTokens.seen(r.Header.Get("AccessToken"))
+		}
+		duration := time.Since(started)
+
+		defaultMetrics.observeRequest(requestLabels{
+			handler:    handler,
+			orderField: metricOrderField(r.FormValue("order_field")),
+			status:     sw.status,
+		}, duration)
+
+		attrs := []slog.Attr{
+			slog.String("handler", handler),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("params", r.URL.Query().Encode()),
+			slog.String("token", redactToken(r.Header.Get("AccessToken"))),
+			slog.Int("status", sw.status),
+			slog.Int("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+			slog.String("trace_id", span.TraceIDString()),
+			slog.String("span_id", span.SpanIDString()),
+		}
+		if hasParent {
+			attrs = append(attrs, slog.String("parent_span_id", parent.SpanIDString()))
+		}
+		accessLog.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
@@ -0,0 +1,108 @@
+package hw4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	cases := []struct {
+		value string
+		ok    bool
+	}{
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: true},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", ok: true},
+		{value: "", ok: false},
+		{value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: false},
+		{value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", ok: false},
+		{value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", ok: false},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", ok: false},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", ok: false},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", ok: false},
+	}
+
+	for i, c := range cases {
+		tc, ok := ParseTraceParent(c.value)
+		if ok != c.ok {
+			t.Errorf("[%d] unexpected ok for %q: %v", i, c.value, ok)
+			continue
+		}
+		if ok && tc.String() != c.value {
+			t.Errorf("[%d] wrong round trip: %s, expected: %s", i, tc.String(), c.value)
+		}
+	}
+}
+
+func TestSearchMuxObservability(t *testing.T) {
+	logs := &bytes.Buffer{}
+	oldLog, oldMetrics := accessLog, defaultMetrics
+	accessLog = slog.New(slog.NewJSONHandler(logs, nil))
+	defaultMetrics = newSearchMetrics()
+	defer func() { accessLog, defaultMetrics = oldLog, oldMetrics }()
+
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	trace := NewTraceContext()
+	ctx := ContextWithTrace(context.Background(), trace)
+	client := &SearchClient{URL: server.URL, AccessToken: "secret-token"}
+	if _, err := client.FindUsersContext(ctx, SearchRequest{Limit: 5, OrderField: "Age", OrderBy: OrderByAsc}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.FindUsers(SearchRequest{OrderField: "Salary"}); err == nil {
+		t.Fatalf("expected bad order field error")
+	}
+
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("access log leaks token: %s", logs.String())
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 access log lines, got %d: %s", len(lines), logs.String())
+	}
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("cant unpack access log: %v", err)
+	}
+	expected := map[string]interface{}{
+		"msg":      "request",
+		"handler":  "search",
+		"status":   float64(200),
+		"token":    redactToken("secret-token"),
+		"trace_id": trace.TraceIDString(),
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("wrong access log %s: %v, expected: %v", key, entry[key], value)
+		}
+	}
+	if entry["parent_span_id"] == nil || entry["parent_span_id"] == trace.SpanIDString() {
+		t.Errorf("client must send a child span of the context trace, got parent %v", entry["parent_span_id"])
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		`search_requests_total{handler="search",order_field="Age",status="200"} 1`,
+		`search_requests_total{handler="search",order_field="invalid",status="400"} 1`,
+		`search_request_duration_seconds_bucket{handler="search",order_field="Age",status="200",le="+Inf"} 1`,
+		`search_request_duration_seconds_count{handler="search",order_field="invalid",status="400"} 1`,
+		"# TYPE search_dataset_size gauge",
+		"# TYPE search_index_build_seconds gauge",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics miss %q:\n%s", line, body)
+		}
+	}
+}
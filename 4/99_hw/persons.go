@@ -0,0 +1,398 @@
+package hw4
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// формат поля registered в dataset.xml, например "2017-02-05T06:23:27 -03:00"
+const registeredLayout = "2006-01-02T15:04:05 -07:00"
+
+const (
+	minPersonAge = 0
+	maxPersonAge = 150
+)
+
+var ErrPersonNotFound = errors.New("person not found")
+
+// maxPersonBodyBytes - предел тела запроса к /persons, одна запись столько не занимает
+const maxPersonBodyBytes = 1 << 20
+
+// writeTokens - токены, которым разрешено менять датасет через /persons
+var writeTokens = map[string]bool{}
+
+// personProblem - проблема в одном поле записи
+type personProblem struct {
+	field   string
+	message string
+}
+
+// personProblems проверяет поля записи и возвращает сразу все найденные проблемы
+func personProblems(p Person) []personProblem {
+	var problems []personProblem
+	if p.ID < 0 {
+		problems = append(problems, personProblem{"id", "id must be >= 0"})
+	}
+	if strings.TrimSpace(p.FirstName) == "" {
+		problems = append(problems, personProblem{"first_name", "first_name is required"})
+	}
+	if p.Age < minPersonAge || p.Age > maxPersonAge {
+		problems = append(problems, personProblem{"age", fmt.Sprintf("age must be between %d and %d", minPersonAge, maxPersonAge)})
+	}
+	if p.Gender != "male" && p.Gender != "female" {
+		problems = append(problems, personProblem{"gender", "gender must be male or female"})
+	}
+	if p.Email != "" {
+		addr, err := mail.ParseAddress(p.Email)
+		if err != nil || addr.Name != "" || addr.Address != p.Email {
+			problems = append(problems, personProblem{"email", "email is malformed"})
+		}
+	}
+	if p.IsActive != "" && p.IsActive != "true" && p.IsActive != "false" {
+		problems = append(problems, personProblem{"isActive", "isActive must be true or false"})
+	}
+	if p.Registered != "" {
+		if _, err := time.Parse(registeredLayout, p.Registered); err != nil {
+			problems = append(problems, personProblem{"registered", "registered must look like " + registeredLayout})
+		}
+	}
+	return problems
+}
+
+// validatePerson - personProblems одной ошибкой
+func validatePerson(p Person) error {
+	problems := personProblems(p)
+	if len(problems) == 0 {
+		return nil
+	}
+	messages := make([]string, len(problems))
+	for i, problem := range problems {
+		messages[i] = problem.message
+	}
+	return errors.New("invalid person: " + strings.Join(messages, "; "))
+}
+
+func newGuid() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func findPerson(persons []Person, id int) int {
+	return slices.IndexFunc(persons, func(p Person) bool { return p.ID == id })
+}
+
+// PersonsServer - запись датасета:
+// POST /persons создаёт запись с новым id, GET /persons/{id} отдаёт запись,
+// PUT /persons/{id} создаёт или целиком заменяет, PATCH /persons/{id} меняет переданные поля,
+// DELETE /persons/{id} удаляет. Менять датасет могут только токены из writeTokens,
+// читать запись - они же и токены из adminTokens
+func PersonsServer(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("AccessToken")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+
+	var id int
+	if idValue := r.PathValue("id"); idValue != "" {
+		var err error
+		if id, err = strconv.Atoi(idValue); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid id value")
+			return
+		}
+	}
+
+	if (r.PathValue("id") == "") != (r.Method == http.MethodPost) {
+		writeError(w, http.StatusMethodNotAllowed, "unknown method")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		// запись целиком, с персональными данными мимо политик редактирования,
+		// видна только тем, кто может её менять, и админам
+		if !writeTokens[token] && !adminTokens[token] {
+			writeError(w, http.StatusForbidden, "AccessToken has no access to persons")
+			return
+		}
+		getPerson(w, r, token, id)
+		return
+	}
+	if !writeTokens[token] {
+		writeError(w, http.StatusForbidden, "AccessToken has no write access")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPersonBodyBytes)
+
+	var (
+		status = http.StatusOK
+		result Person
+		err    error
+	)
+	switch r.Method {
+	case http.MethodPost:
+		status = http.StatusCreated
+		result, err = createPerson(storeFor(r), r.Body)
+	case http.MethodPut:
+		var created bool
+		result, created, err = replacePerson(storeFor(r), r.Body, id)
+		if created {
+			status = http.StatusCreated
+		}
+	case http.MethodPatch:
+		result, err = patchPerson(storeFor(r), r.Body, id)
+	case http.MethodDelete:
+		status = http.StatusNoContent
+		err = deletePerson(storeFor(r), id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "unknown method")
+		return
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrPersonNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errDatasetOpen), errors.Is(err, errDatasetDecode), errors.Is(err, errDatasetWrite):
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	case errors.As(err, new(*http.MaxBytesError)):
+		writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func getPerson(w http.ResponseWriter, r *http.Request, token string, id int) {
+	ds, err := storeFor(r).Get()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	i := findPerson(ds.Persons, id)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, ErrPersonNotFound.Error())
+		return
+	}
+	// в журнал идёт только чтение: запись отдаёт обратно то, что прислал сам клиент
+	if err := auditRead(r, "persons", token, ds.Snapshot, "id="+strconv.Itoa(id), []int{id}); err != nil {
+		writeAuditError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	json.NewEncoder(w).Encode(ds.Persons[i])
+}
+
+func decodePerson(body io.Reader, person *Person) error {
+	if err := json.NewDecoder(body).Decode(person); err != nil {
+		return fmt.Errorf("cant unpack person json: %s", err)
+	}
+	return nil
+}
+
+func createPerson(store *datasetStore, body io.Reader) (Person, error) {
+	person := Person{}
+	if err := decodePerson(body, &person); err != nil {
+		return Person{}, err
+	}
+
+	err := store.update(func(persons []Person) ([]Person, error) {
+		person.ID = 0
+		for _, p := range persons {
+			person.ID = max(person.ID, p.ID+1)
+		}
+		if person.Guid == "" {
+			person.Guid = newGuid()
+		}
+		if err := validatePerson(person); err != nil {
+			return nil, err
+		}
+		return append(persons, person), nil
+	})
+	return person, err
+}
+
+func replacePerson(store *datasetStore, body io.Reader, id int) (Person, bool, error) {
+	person := Person{ID: id}
+	if err := decodePerson(body, &person); err != nil {
+		return Person{}, false, err
+	}
+	if person.ID != id {
+		return Person{}, false, errors.New("id in body does not match url")
+	}
+
+	created := false
+	err := store.update(func(persons []Person) ([]Person, error) {
+		if err := validatePerson(person); err != nil {
+			return nil, err
+		}
+		i := findPerson(persons, id)
+		if i < 0 {
+			created = true
+			if person.Guid == "" {
+				person.Guid = newGuid()
+			}
+			return append(persons, person), nil
+		}
+		persons[i] = person
+		return persons, nil
+	})
+	return person, created, err
+}
+
+func patchPerson(store *datasetStore, body io.Reader, id int) (Person, error) {
+	patch, err := io.ReadAll(body)
+	if err != nil {
+		return Person{}, err
+	}
+
+	var person Person
+	err = store.update(func(persons []Person) ([]Person, error) {
+		i := findPerson(persons, id)
+		if i < 0 {
+			return nil, ErrPersonNotFound
+		}
+		// поля из тела ложатся поверх текущей записи
+		person = persons[i]
+		if err := decodePerson(bytes.NewReader(patch), &person); err != nil {
+			return nil, err
+		}
+		if person.ID != id {
+			return nil, errors.New("id cant be changed")
+		}
+		if err := validatePerson(person); err != nil {
+			return nil, err
+		}
+		persons[i] = person
+		return persons, nil
+	})
+	return person, err
+}
+
+func deletePerson(store *datasetStore, id int) error {
+	return store.update(func(persons []Person) ([]Person, error) {
+		i := findPerson(persons, id)
+		if i < 0 {
+			return nil, ErrPersonNotFound
+		}
+		return slices.Delete(persons, i, i+1), nil
+	})
+}
+
+// GetPerson отдаёт полную запись датасета по id
+func (srv *SearchClient) GetPerson(ctx context.Context, id int) (*Person, error) {
+	return srv.doPerson(ctx, http.MethodGet, strconv.Itoa(id), nil)
+}
+
+// CreatePerson добавляет запись, id назначает SearchServer
+func (srv *SearchClient) CreatePerson(ctx context.Context, person Person) (*Person, error) {
+	return srv.doPerson(ctx, http.MethodPost, "", person)
+}
+
+// ReplacePerson создаёт или целиком заменяет запись с person.ID
+func (srv *SearchClient) ReplacePerson(ctx context.Context, person Person) (*Person, error) {
+	return srv.doPerson(ctx, http.MethodPut, strconv.Itoa(person.ID), person)
+}
+
+// PatchPerson меняет только переданные поля, ключи - как в dataset.xml (first_name, age, ...)
+func (srv *SearchClient) PatchPerson(ctx context.Context, id int, fields map[string]interface{}) (*Person, error) {
+	return srv.doPerson(ctx, http.MethodPatch, strconv.Itoa(id), fields)
+}
+
+func (srv *SearchClient) DeletePerson(ctx context.Context, id int) error {
+	_, err := srv.doPerson(ctx, http.MethodDelete, strconv.Itoa(id), nil)
+	return err
+}
+
+func (srv *SearchClient) doPerson(ctx context.Context, method, id string, payload interface{}) (*Person, error) {
+	personURL, err := srv.datasetURL("persons", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("cant pack person json: %s", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	personReq, err := http.NewRequestWithContext(ctx, method, personURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("bad URL %s: %s", personURL, err)
+	}
+	personReq.Header.Add("AccessToken", srv.AccessToken)
+	personReq.Header.Add(TraceParentHeader, outgoingTrace(ctx).String())
+	if payload != nil {
+		personReq.Header.Add("Content-Type", ContentTypeJSON)
+	}
+
+	resp, err := srv.httpClient().Do(personReq)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %s", err)
+	}
+	defer drainAndClose(resp.Body)
+	respBody, err := io.ReadAll(limitBody(resp, srv.maxResponseBytes()))
+	if err != nil {
+		return nil, fmt.Errorf("cant read response: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusUnauthorized:
+		return nil, ErrBadAccessToken
+	case http.StatusInternalServerError:
+		return nil, ErrServerFatal
+	case http.StatusNotFound:
+		// 404 бывает и от tenantHandler, если датасета нет или он не виден токену
+		if bytes.Contains(respBody, []byte(ErrDatasetNotFound.Error())) {
+			return nil, ErrDatasetNotFound
+		}
+		return nil, ErrPersonNotFound
+	default:
+		errResp := SearchErrorResponse{}
+		if err := json.Unmarshal(respBody, &errResp); err != nil {
+			return nil, fmt.Errorf("cant unpack error json: %s", err)
+		}
+		if resp.StatusCode == http.StatusBadRequest {
+			return nil, badRequestError(errResp.Error)
+		}
+		return nil, errors.New(errResp.Error)
+	}
+
+	person := &Person{}
+	if err := json.Unmarshal(respBody, person); err != nil {
+		return nil, fmt.Errorf("cant unpack person json: %s", err)
+	}
+	return person, nil
+}
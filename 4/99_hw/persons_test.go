@@ -0,0 +1,193 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useDatasetCopy подменяет датасет на копию dataset.xml во временной папке
+func useDatasetCopy(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("dataset.xml")
+	if err != nil {
+		t.Fatalf("cant read dataset: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "dataset.xml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("cant write dataset: %v", err)
+	}
+
+	old := defaultDatasets
+	defaultDatasets = &datasetStore{path: path}
+	t.Cleanup(func() { defaultDatasets = old })
+	return path
+}
+
+func useWriteTokens(t *testing.T, tokens ...string) {
+	t.Helper()
+	old := writeTokens
+	writeTokens = map[string]bool{}
+	for _, token := range tokens {
+		writeTokens[token] = true
+	}
+	t.Cleanup(func() { writeTokens = old })
+}
+
+func TestValidatePerson(t *testing.T) {
+	valid := Person{FirstName: "Boyd", Age: 22, Gender: "male", Email: "boydwolf@hopeli.com", IsActive: "true", Registered: "2017-02-05T06:23:27 -03:00"}
+
+	cases := []struct {
+		change  func(p *Person)
+		problem string
+	}{
+		{change: func(p *Person) {}},
+		{change: func(p *Person) { p.ID = -1 }, problem: "id must be >= 0"},
+		{change: func(p *Person) { p.FirstName = "  " }, problem: "first_name is required"},
+		{change: func(p *Person) { p.Age = 151 }, problem: "age must be between 0 and 150"},
+		{change: func(p *Person) { p.Age = -1 }, problem: "age must be between 0 and 150"},
+		{change: func(p *Person) { p.Gender = "unknown" }, problem: "gender must be male or female"},
+		{change: func(p *Person) { p.Email = "boydwolf.hopeli.com" }, problem: "email is malformed"},
+		{change: func(p *Person) { p.Email = "Boyd <boydwolf@hopeli.com>" }, problem: "email is malformed"},
+		{change: func(p *Person) { p.IsActive = "yes" }, problem: "isActive must be true or false"},
+		{change: func(p *Person) { p.Registered = "2017-02-05T06:23:27-03:00" }, problem: "registered must look like"},
+	}
+
+	for i, c := range cases {
+		p := valid
+		c.change(&p)
+		err := validatePerson(p)
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("[%d] unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("[%d] expected %q, got %v", i, c.problem, err)
+		}
+	}
+
+	err := validatePerson(Person{Age: 200})
+	if err == nil || strings.Count(err.Error(), ";") != 2 {
+		t.Errorf("expected all problems at once, got %v", err)
+	}
+}
+
+func TestPersonsCRUD(t *testing.T) {
+	path := useDatasetCopy(t)
+	useWriteTokens(t, "writer")
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	ctx := context.Background()
+	writer := &SearchClient{URL: server.URL, AccessToken: "writer"}
+	reader := &SearchClient{URL: server.URL, AccessToken: "reader"}
+
+	if _, err := reader.CreatePerson(ctx, Person{FirstName: "New", Gender: "male"}); err == nil || err.Error() != "AccessToken has no write access" {
+		t.Fatalf("expected write access error, got %v", err)
+	}
+	if _, err := writer.CreatePerson(ctx, Person{FirstName: "New", Gender: "robot", Age: 500}); err == nil || !strings.HasPrefix(err.Error(), "invalid person: ") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+
+	created, err := writer.CreatePerson(ctx, Person{FirstName: "Zed", LastName: "Uniquename", Age: 40, Gender: "male", About: "created in test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if created.ID != 35 || created.Guid == "" {
+		t.Errorf("expected id 35 and generated guid, got %+v", created)
+	}
+
+	resp, err := reader.FindUsers(SearchRequest{Limit: 5, Query: "Uniquename"})
+	if err != nil || len(resp.Users) != 1 || resp.Users[0].Id != 35 {
+		t.Fatalf("created person is not searchable: %+v, %v", resp, err)
+	}
+
+	patched, err := writer.PatchPerson(ctx, 35, map[string]interface{}{"age": 41, "last_name": "Patched"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patched.Age != 41 || patched.LastName != "Patched" || patched.About != "created in test" {
+		t.Errorf("wrong patched person: %+v", patched)
+	}
+	if _, err := writer.PatchPerson(ctx, 35, map[string]interface{}{"id": 36}); !errors.Is(err, ErrBadRequest) || err.Error() != "id cant be changed" {
+		t.Errorf("expected id change error, got %v", err)
+	}
+	if _, err := writer.PatchPerson(ctx, 35, map[string]interface{}{"about": strings.Repeat("x", maxPersonBodyBytes)}); err == nil || err.Error() != "request body too large" {
+		t.Errorf("expected body size error, got %v", err)
+	}
+	if _, err := writer.PatchPerson(ctx, 1000, map[string]interface{}{"age": 1}); !errors.Is(err, ErrPersonNotFound) {
+		t.Errorf("expected ErrPersonNotFound, got %v", err)
+	}
+
+	replaced, err := writer.ReplacePerson(ctx, Person{ID: 0, FirstName: "Boyd", LastName: "Replaced", Age: 23, Gender: "male"})
+	if err != nil || replaced.LastName != "Replaced" {
+		t.Fatalf("unexpected replace result: %+v, %v", replaced, err)
+	}
+	if _, err := writer.ReplacePerson(ctx, Person{ID: 100, FirstName: "Put", Gender: "female"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := writer.DeletePerson(ctx, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := reader.GetPerson(ctx, 0); err == nil || err.Error() != "AccessToken has no access to persons" {
+		t.Errorf("expected read access error, got %v", err)
+	}
+	if _, err := writer.GetPerson(ctx, 1); !errors.Is(err, ErrPersonNotFound) {
+		t.Errorf("expected ErrPersonNotFound after delete, got %v", err)
+	}
+	if err := writer.DeletePerson(ctx, 1); !errors.Is(err, ErrPersonNotFound) {
+		t.Errorf("expected ErrPersonNotFound on second delete, got %v", err)
+	}
+
+	// изменения пережили перечитывание файла
+	ds, err := LoadDataset(path)
+	if err != nil {
+		t.Fatalf("cant reload dataset: %v", err)
+	}
+	if ds.Len() != 36 {
+		t.Errorf("expected 36 persons on disk, got %d", ds.Len())
+	}
+	onDisk := map[int]Person{}
+	for _, p := range ds.Persons {
+		onDisk[p.ID] = p
+	}
+	if onDisk[35].LastName != "Patched" || onDisk[0].LastName != "Replaced" || onDisk[100].FirstName != "Put" {
+		t.Errorf("changes are not persisted: %+v %+v %+v", onDisk[35], onDisk[0], onDisk[100])
+	}
+	if _, ok := onDisk[1]; ok {
+		t.Errorf("deleted person is still on disk")
+	}
+	if strings.TrimSpace(onDisk[2].About) != strings.TrimSpace(ds.Persons[1].About) {
+		t.Errorf("untouched persons must be kept as is")
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
+
+func TestPersonClientErrors(t *testing.T) {
+	fatal := httptest.NewServer(http.HandlerFunc(SearchInternalErrorServer))
+	defer fatal.Close()
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	ctx := context.Background()
+	noToken := &SearchClient{URL: server.URL}
+	if _, err := noToken.GetPerson(ctx, 0); !errors.Is(err, ErrBadAccessToken) {
+		t.Errorf("expected ErrBadAccessToken, got %v", err)
+	}
+	broken := &SearchClient{URL: fatal.URL, AccessToken: "writer"}
+	if err := broken.DeletePerson(ctx, 0); !errors.Is(err, ErrServerFatal) {
+		t.Errorf("expected ErrServerFatal, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package hw4
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ExtraFields - какие поля сверх пяти основных отдать в User, запрашиваются параметром fields
+type ExtraFields uint8
+
+const (
+	FieldEmail ExtraFields = 1 << iota
+	FieldPhone
+	FieldAddress
+	FieldBalance
+)
+
+var extraFieldNames = []struct {
+	field ExtraFields
+	name  string
+}{
+	{FieldEmail, "email"},
+	{FieldPhone, "phone"},
+	{FieldAddress, "address"},
+	{FieldBalance, "balance"},
+}
+
+// String - значение параметра fields, например "email,phone"
+func (f ExtraFields) String() string {
+	var names []string
+	for _, extra := range extraFieldNames {
+		if f&extra.field != 0 {
+			names = append(names, extra.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseExtraFields разбирает список полей через запятую: "email,phone"
+func ParseExtraFields(value string) (ExtraFields, error) {
+	var fields ExtraFields
+	if value == "" {
+		return fields, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		known := false
+		for _, extra := range extraFieldNames {
+			if extra.name == strings.TrimSpace(name) {
+				fields |= extra.field
+				known = true
+			}
+		}
+		if !known {
+			return 0, errors.New("Invalid fields value")
+		}
+	}
+	return fields, nil
+}
+
+// RedactionMode - в каком виде отдавать поле с персональными данными
+type RedactionMode string
+
+const (
+	RedactFull    RedactionMode = "full"
+	RedactMasked  RedactionMode = "masked"
+	RedactHashed  RedactionMode = "hashed"
+	RedactOmitted RedactionMode = "omitted"
+)
+
+// redactionKey - ключ HMAC для режима hashed. Без ключа телефоны и email перебором восстанавливаются
+// по хешу, поэтому по умолчанию ключ случайный и живёт, пока работает процесс, см. ServerConfig.RedactionKey
+var redactionKey = newRedactionKey()
+
+func newRedactionKey() []byte {
+	key := make([]byte, sha256.Size)
+	rand.Read(key)
+	return key
+}
+
+// hashValue - отпечаток значения для режима hashed, 128 бит, чтобы разные значения не совпадали
+func hashValue(value string) string {
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write([]byte(value))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// RedactionPolicy - режим для каждого дополнительного поля по имени (email, phone, address, balance).
+// Поля, которых нет в политике, не отдаются
+type RedactionPolicy map[string]RedactionMode
+
+// RedactionHeader - заголовок ответа SearchServer, в котором перечислено,
+// в каком виде пришли запрошенные поля, например "email=masked,phone=full"
+const RedactionHeader = "X-Redaction"
+
+var (
+	// tokenPolicies - политики для отдельных токенов, остальные получают defaultRedactionPolicy
+	tokenPolicies = map[string]RedactionPolicy{}
+
+	defaultRedactionPolicy = RedactionPolicy{
+		"email":   RedactMasked,
+		"phone":   RedactMasked,
+		"address": RedactMasked,
+		"balance": RedactOmitted,
+	}
+)
+
+func redactionPolicyFor(token string) RedactionPolicy {
+	if policy, ok := tokenPolicies[token]; ok {
+		return policy
+	}
+	return defaultRedactionPolicy
+}
+
+func (policy RedactionPolicy) mode(name string) RedactionMode {
+	switch mode := policy[name]; mode {
+	case RedactFull, RedactMasked, RedactHashed:
+		return mode
+	}
+	return RedactOmitted
+}
+
+// apply заполняет в user запрошенные поля из person в виде, который разрешает политика
+func (policy RedactionPolicy) apply(user User, person Person, fields ExtraFields) User {
+	values := map[ExtraFields]*string{
+		FieldEmail:   &user.Email,
+		FieldPhone:   &user.Phone,
+		FieldAddress: &user.Address,
+		FieldBalance: &user.Balance,
+	}
+	raw := map[ExtraFields]string{
+		FieldEmail:   person.Email,
+		FieldPhone:   person.Phone,
+		FieldAddress: person.Address,
+		FieldBalance: person.Balance,
+	}
+	for _, extra := range extraFieldNames {
+		if fields&extra.field == 0 {
+			continue
+		}
+		*values[extra.field] = redact(extra.field, policy.mode(extra.name), raw[extra.field])
+	}
+	return user
+}
+
+// header - значение RedactionHeader для запрошенных полей
+func (policy RedactionPolicy) header(fields ExtraFields) string {
+	var modes []string
+	for _, extra := range extraFieldNames {
+		if fields&extra.field != 0 {
+			modes = append(modes, extra.name+"="+string(policy.mode(extra.name)))
+		}
+	}
+	return strings.Join(modes, ",")
+}
+
+// parseRedactionHeader - обратное к header
+func parseRedactionHeader(value string) map[string]RedactionMode {
+	if value == "" {
+		return nil
+	}
+	modes := map[string]RedactionMode{}
+	for _, pair := range strings.Split(value, ",") {
+		if name, mode, ok := strings.Cut(pair, "="); ok {
+			modes[name] = RedactionMode(mode)
+		}
+	}
+	return modes
+}
+
+func redact(field ExtraFields, mode RedactionMode, value string) string {
+	if value == "" {
+		return ""
+	}
+	switch mode {
+	case RedactFull:
+		return value
+	case RedactHashed:
+		return hashValue(value)
+	case RedactMasked:
+		return mask(field, value)
+	}
+	return ""
+}
+
+// mask оставляет от значения ровно столько, чтобы его можно было узнать
+func mask(field ExtraFields, value string) string {
+	switch field {
+	case FieldEmail:
+		// "boydwolf@hopeli.com" -> "b***@hopeli.com"
+		local, domain, ok := strings.Cut(value, "@")
+		if !ok || local == "" {
+			return "***"
+		}
+		// первый символ, а не байт, иначе от не-ASCII адреса останется половина руны
+		first, _ := utf8.DecodeRuneInString(local)
+		return string(first) + "***@" + domain
+	case FieldPhone:
+		// видны только последние 4 цифры: "+1 (956) 593-2402" -> "+* (***) ***-2402"
+		digits := 0
+		for _, r := range value {
+			if unicode.IsDigit(r) {
+				digits++
+			}
+		}
+		var b strings.Builder
+		for _, r := range value {
+			if unicode.IsDigit(r) {
+				digits--
+				if digits >= 4 {
+					r = '*'
+				}
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	case FieldAddress:
+		// без улицы и индекса: "***, Edneyville, Mississippi"
+		city, state := Person{Address: value}.Location()
+		if city == "" {
+			return "***"
+		}
+		return "***, " + city + ", " + state
+	}
+	return "***"
+}
+
+func addFieldsParam(params url.Values, fields ExtraFields) {
+	if fields != 0 {
+		params.Add("fields", fields.String())
+	}
+}
@@ -0,0 +1,123 @@
+package hw4
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func useRedactionPolicies(t *testing.T, policies map[string]RedactionPolicy) {
+	t.Helper()
+	old := tokenPolicies
+	tokenPolicies = policies
+	t.Cleanup(func() { tokenPolicies = old })
+}
+
+func TestRedact(t *testing.T) {
+	cases := []struct {
+		field ExtraFields
+		mode  RedactionMode
+		value string
+		want  string
+	}{
+		{FieldEmail, RedactMasked, "boydwolf@hopeli.com", "b***@hopeli.com"},
+		{FieldEmail, RedactMasked, "юля@почта.рф", "ю***@почта.рф"},
+		{FieldEmail, RedactMasked, "not-an-email", "***"},
+		{FieldPhone, RedactMasked, "+1 (956) 593-2402", "+* (***) ***-2402"},
+		{FieldAddress, RedactMasked, "586 Winthrop Street, Edneyville, Mississippi, 9555", "***, Edneyville, Mississippi"},
+		{FieldBalance, RedactMasked, "$2,144.93", "***"},
+		{FieldEmail, RedactFull, "boydwolf@hopeli.com", "boydwolf@hopeli.com"},
+		{FieldEmail, RedactHashed, "boydwolf@hopeli.com", hashValue("boydwolf@hopeli.com")},
+		{FieldEmail, RedactOmitted, "boydwolf@hopeli.com", ""},
+		{FieldEmail, "unknown", "boydwolf@hopeli.com", ""},
+		{FieldEmail, RedactMasked, "", ""},
+	}
+	for _, c := range cases {
+		if got := redact(c.field, c.mode, c.value); got != c.want {
+			t.Errorf("%s %s %q: expected %q, got %q", c.field, c.mode, c.value, c.want, got)
+		}
+	}
+}
+
+func TestHashValueIsKeyed(t *testing.T) {
+	old := redactionKey
+	t.Cleanup(func() { redactionKey = old })
+
+	redactionKey = []byte("first")
+	first := hashValue("+1 (956) 593-2402")
+	if len(first) != len("hmac:")+32 || first != hashValue("+1 (956) 593-2402") {
+		t.Errorf("expected stable 128-bit hmac, got %q", first)
+	}
+	redactionKey = []byte("second")
+	if hashValue("+1 (956) 593-2402") == first {
+		t.Errorf("hash must depend on the key")
+	}
+}
+
+func TestExtraFieldsParam(t *testing.T) {
+	fields, err := ParseExtraFields("phone, email")
+	if err != nil || fields != FieldEmail|FieldPhone || fields.String() != "email,phone" {
+		t.Errorf("unexpected fields %q, %v", fields, err)
+	}
+	if _, err := ParseExtraFields("email,password"); err == nil || err.Error() != "Invalid fields value" {
+		t.Errorf("expected Invalid fields value, got %v", err)
+	}
+}
+
+func TestSearchRedaction(t *testing.T) {
+	useRedactionPolicies(t, map[string]RedactionPolicy{
+		"auditor": {"email": RedactFull, "phone": RedactHashed, "balance": RedactFull},
+	})
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	req := SearchRequest{Limit: 1, OrderField: "Id", OrderBy: OrderByAsc, Fields: FieldEmail | FieldPhone | FieldAddress | FieldBalance}
+	cases := []struct {
+		token     string
+		user      User
+		redaction map[string]RedactionMode
+	}{
+		{
+			token:     "auditor",
+			user:      User{Email: "boydwolf@hopeli.com", Phone: hashValue("+1 (956) 593-2402"), Balance: "$2,144.93"},
+			redaction: map[string]RedactionMode{"email": RedactFull, "phone": RedactHashed, "address": RedactOmitted, "balance": RedactFull},
+		},
+		{
+			token:     "reader",
+			user:      User{Email: "b***@hopeli.com", Phone: "+* (***) ***-2402", Address: "***, Edneyville, Mississippi"},
+			redaction: map[string]RedactionMode{"email": RedactMasked, "phone": RedactMasked, "address": RedactMasked, "balance": RedactOmitted},
+		},
+	}
+	for _, c := range cases {
+		for _, accept := range []string{"", ContentTypeCSV, ContentTypeProtobuf} {
+			client := &SearchClient{URL: server.URL, AccessToken: c.token, Accept: accept}
+			resp, err := client.FindUsers(req)
+			if err != nil {
+				t.Fatalf("%s %s: unexpected error: %v", c.token, accept, err)
+			}
+			got := resp.Users[0]
+			got.Id, got.Name, got.Age, got.About, got.Gender = 0, "", 0, "", ""
+			if got != c.user {
+				t.Errorf("%s %s: expected %+v, got %+v", c.token, accept, c.user, got)
+			}
+			if !reflect.DeepEqual(resp.Redaction, c.redaction) {
+				t.Errorf("%s %s: expected redaction %v, got %v", c.token, accept, c.redaction, resp.Redaction)
+			}
+		}
+	}
+
+	// без fields дополнительных полей и заголовка нет
+	client := &SearchClient{URL: server.URL, AccessToken: "auditor"}
+	resp, err := client.FindUsers(SearchRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u := resp.Users[0]; u.Email != "" || u.Phone != "" || u.Balance != "" || resp.Redaction != nil {
+		t.Errorf("extra fields leaked without request: %+v, %v", u, resp.Redaction)
+	}
+	body, _ := marshalUsersJSON(resp.Users)
+	if strings.Contains(string(body), "Email") {
+		t.Errorf("empty extra fields must not be serialized: %s", body)
+	}
+}
@@ -0,0 +1,190 @@
+package hw4
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// небольшой словарь, чтобы в случайных датасетах были совпадения и равные значения
+var propertyWords = []string{"Boyd", "Hilda", "Wolf", "Mayer", "nisi", "ut", "amet", "Lorem"}
+
+func randomPersons(r *rand.Rand) []Person {
+	persons := make([]Person, r.IntN(30))
+	for i, id := range r.Perm(len(persons)) {
+		about := make([]string, r.IntN(4))
+		for j := range about {
+			about[j] = propertyWords[r.IntN(len(propertyWords))]
+		}
+		persons[i] = Person{
+			ID:        id,
+			FirstName: propertyWords[r.IntN(4)],
+			LastName:  propertyWords[r.IntN(4)],
+			Age:       20 + r.IntN(5),
+			About:     strings.Join(about, " "),
+			IsActive:  []string{"true", "false", ""}[r.IntN(3)],
+			Balance:   []string{"$1,000.50", "$999.99", "$1,000.5", "", "$12.00"}[r.IntN(5)],
+			Address:   []string{"1 A Street, Hegins, Nevada, 1", "2 B Street, Marion, Maryland, 2", "broken"}[r.IntN(3)],
+		}
+		if r.IntN(5) > 0 {
+			persons[i].Registered = time.Date(2014+r.IntN(3), 1, 1, 0, 0, 0, 0, time.UTC).Format(registeredLayout)
+		}
+	}
+	return persons
+}
+
+func randomSearch(r *rand.Rand) SearchRequest {
+	fields := []string{"", "Id", "Age", "Name", "IsActive", "Balance", "Registered", "State"}
+	sr := SearchRequest{
+		OrderField: fields[r.IntN(len(fields))],
+		OrderBy:    r.IntN(3) - 1,
+	}
+	if r.IntN(4) > 0 {
+		sr.Query = propertyWords[r.IntN(len(propertyWords))]
+	}
+	return sr
+}
+
+// naiveSearch - эталон: фильтр полным перебором и сортировка на каждый запрос
+func naiveSearch(persons []Person, sr SearchRequest) []User {
+	matched := []Person{}
+	for _, p := range persons {
+		if strings.Contains(p.Name(), sr.Query) || strings.Contains(p.About, sr.Query) {
+			matched = append(matched, p)
+		}
+	}
+	if sr.OrderBy != OrderByAsIs {
+		compare, _ := comparator(cmpOr(sr.OrderField, defaultOrderField))
+		slices.SortStableFunc(matched, compare)
+		if sr.OrderBy == OrderByDesc {
+			slices.Reverse(matched)
+		}
+	}
+
+	result := []User{}
+	for _, p := range matched {
+		result = append(result, p.User())
+	}
+	return result
+}
+
+func TestSearchProperties(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for iter := 0; iter < 500; iter++ {
+		persons := randomPersons(r)
+		sr := randomSearch(r)
+		got := NewDataset(persons).Search(sr)
+
+		if want := naiveSearch(persons, sr); !slices.Equal(got, want) {
+			t.Fatalf("[%d] %+v: got %v, want %v", iter, sr, got, want)
+		}
+
+		// фильтр не пропускает лишних
+		for _, user := range got {
+			if !strings.Contains(user.Name, sr.Query) && !strings.Contains(user.About, sr.Query) {
+				t.Fatalf("[%d] %+v: user %d does not match", iter, sr, user.Id)
+			}
+		}
+		// и не теряет нужных
+		matching := 0
+		for _, p := range persons {
+			if p.Matches(sr.Query) {
+				matching++
+			}
+		}
+		if len(got) != matching {
+			t.Fatalf("[%d] %+v: found %d of %d matching", iter, sr, len(got), matching)
+		}
+
+		if sr.OrderBy != OrderByAsIs {
+			compare, _ := comparator(cmpOr(sr.OrderField, defaultOrderField))
+			byID := map[int]Person{}
+			for _, p := range persons {
+				byID[p.ID] = p
+			}
+			for i := 1; i < len(got); i++ {
+				if c := compare(byID[got[i-1].Id], byID[got[i].Id]); c*sr.OrderBy < 0 {
+					t.Fatalf("[%d] %+v: users %d and %d are out of order", iter, sr, got[i-1].Id, got[i].Id)
+				}
+			}
+		}
+	}
+}
+
+func cmpOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func TestPaginationProperties(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	old := defaultDatasets
+	t.Cleanup(func() { defaultDatasets = old })
+
+	for iter := 0; iter < 100; iter++ {
+		persons := randomPersons(r)
+		data, err := encodeDataset(persons)
+		if err != nil {
+			t.Fatalf("cant encode dataset: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "dataset.xml")
+		if err := writeDatasetFile(path, data); err != nil {
+			t.Fatalf("cant write dataset: %v", err)
+		}
+		defaultDatasets = &datasetStore{path: path}
+
+		sr := randomSearch(r)
+		full := searchPage(t, sr)
+		if want := naiveSearch(persons, sr); !slices.Equal(full, want) {
+			t.Fatalf("[%d] %+v: got %v, want %v", iter, sr, full, want)
+		}
+
+		sr.Limit = 1 + r.IntN(10)
+		pages := []User{}
+		for sr.Offset = 0; sr.Offset < len(full); sr.Offset += sr.Limit {
+			page := searchPage(t, sr)
+			if len(page) == 0 || len(page) > sr.Limit {
+				t.Fatalf("[%d] %+v: page of %d users", iter, sr, len(page))
+			}
+			pages = append(pages, page...)
+		}
+		if !slices.Equal(pages, full) {
+			t.Fatalf("[%d] %+v: pages %v differ from full result %v", iter, sr, pages, full)
+		}
+	}
+}
+
+// searchPage зовёт SearchServer напрямую, без клиента и его limit+1
+func searchPage(t *testing.T, sr SearchRequest) []User {
+	t.Helper()
+	params := url.Values{}
+	params.Set("query", sr.Query)
+	params.Set("order_field", sr.OrderField)
+	params.Set("order_by", strconv.Itoa(sr.OrderBy))
+	params.Set("offset", strconv.Itoa(sr.Offset))
+	params.Set("limit", strconv.Itoa(sr.Limit))
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+params.Encode(), nil)
+	req.Header.Set("AccessToken", "token")
+	rec := httptest.NewRecorder()
+	SearchServer(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%+v: status %d: %s", sr, rec.Code, rec.Body)
+	}
+
+	users := []User{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &users); err != nil {
+		t.Fatalf("cant unpack result: %v", err)
+	}
+	return users
+}
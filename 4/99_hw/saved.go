@@ -0,0 +1,731 @@
+package hw4
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ContentTypeEventStream = "text/event-stream"
+
+	// сколько последних изменений помнит сохранённый поиск для отставших подписчиков
+	savedSearchHistory = 100
+	defaultChangesWait = 30 * time.Second
+	maxChangesWait     = 5 * time.Minute
+	sseKeepAlive       = 15 * time.Second
+	// больше стольких сохранённых поисков на токен не создаётся, каждый пересчитывается на каждой версии датасета
+	maxSavedSearchesPerToken = 100
+)
+
+var (
+	ErrSavedSearchNotFound = errors.New("saved search not found")
+	// подписчик отстал больше, чем на savedSearchHistory изменений, надо заново взять SavedSearch
+	ErrChangesExpired = errors.New("saved search changes expired")
+	// у токена уже maxSavedSearchesPerToken поисков
+	ErrTooManySavedSearches = errors.New("too many saved searches")
+)
+
+// SavedSearch - поиск, который SearchServer пересчитывает при каждой перезагрузке датасета
+type SavedSearch struct {
+	ID string `json:"id"`
+	// без Limit, Offset, Snapshot и Fields: сохраняются все подходящие id по текущей версии датасета
+	Request  SearchRequest `json:"request"`
+	Snapshot string        `json:"snapshot"`
+	// растёт на 1 с каждым SearchDiff
+	Version int   `json:"version"`
+	UserIDs []int `json:"user_ids"`
+}
+
+// SearchDiff - чем результат сохранённого поиска на новой версии датасета отличается от прошлой
+type SearchDiff struct {
+	SearchID string    `json:"search_id"`
+	Version  int       `json:"version"`
+	Snapshot string    `json:"snapshot"`
+	At       time.Time `json:"at"`
+	Added    []int     `json:"added"`
+	Removed  []int     `json:"removed"`
+}
+
+type savedSearch struct {
+	SavedSearch
+	owner ownerKey
+	store *datasetStore
+	// Dataset.generation, по которой посчитан UserIDs
+	generation uint64
+	history    []SearchDiff
+	// закрывается и заменяется новым при каждом изменении и при удалении
+	changed chan struct{}
+	deleted bool
+}
+
+// savedSearchStore - сохранённые поиски всех токенов. Токен видит только свои и только в своём датасете
+type savedSearchStore struct {
+	mu       sync.Mutex
+	searches map[string]*savedSearch
+	perOwner map[ownerKey]int
+	// закрывается при остановке сервера, чтобы подписки не держали Shutdown
+	stop chan struct{}
+}
+
+var savedSearches = &savedSearchStore{}
+
+// ownerKey - полный sha256 токена владельца. Сам токен не хранится, а короткий отпечаток
+// из redactToken может совпасть у двух токенов
+type ownerKey [sha256.Size]byte
+
+func ownerOf(token string) ownerKey {
+	return sha256.Sum256([]byte(token))
+}
+
+func (ss *savedSearchStore) create(store *datasetStore, token string, sr SearchRequest) (SavedSearch, error) {
+	ds, err := store.Get()
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	s := &savedSearch{
+		SavedSearch: SavedSearch{ID: newGuid(), Request: sr, Snapshot: ds.Snapshot, UserIDs: ds.searchIDs(sr)},
+		owner:       ownerOf(token),
+		store:       store,
+		generation:  ds.generation,
+		changed:     make(chan struct{}),
+	}
+
+	ss.mu.Lock()
+	if ss.perOwner[s.owner] >= maxSavedSearchesPerToken {
+		ss.mu.Unlock()
+		return SavedSearch{}, ErrTooManySavedSearches
+	}
+	if ss.searches == nil {
+		ss.searches = map[string]*savedSearch{}
+		ss.perOwner = map[ownerKey]int{}
+	}
+	ss.searches[s.ID] = s
+	ss.perOwner[s.owner]++
+	ss.mu.Unlock()
+
+	// датасет мог перезагрузиться между Get и добавлением поиска
+	if ds, err := store.Get(); err == nil {
+		ss.reevaluate(store, ds)
+	}
+	return ss.snapshot(s), nil
+}
+
+func (ss *savedSearchStore) snapshot(s *savedSearch) SavedSearch {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	saved := s.SavedSearch
+	saved.UserIDs = slices.Clone(s.UserIDs)
+	return saved
+}
+
+func (ss *savedSearchStore) get(store *datasetStore, token, id string) (*savedSearch, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.searches[id]
+	if !ok || s.store != store || s.owner != ownerOf(token) {
+		return nil, false
+	}
+	return s, true
+}
+
+func (ss *savedSearchStore) list(store *datasetStore, token string) []SavedSearch {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	result := []SavedSearch{}
+	owner := ownerOf(token)
+	for _, s := range ss.searches {
+		if s.store == store && s.owner == owner {
+			saved := s.SavedSearch
+			saved.UserIDs = slices.Clone(s.UserIDs)
+			result = append(result, saved)
+		}
+	}
+	slices.SortFunc(result, func(a, b SavedSearch) int { return strings.Compare(a.ID, b.ID) })
+	return result
+}
+
+func (ss *savedSearchStore) delete(s *savedSearch) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if !s.deleted {
+		s.deleted = true
+		close(s.changed)
+		delete(ss.searches, s.ID)
+		if ss.perOwner[s.owner]--; ss.perOwner[s.owner] <= 0 {
+			delete(ss.perOwner, s.owner)
+		}
+	}
+}
+
+// reevaluate пересчитывает поиски по store на новой версии ds. datasetStore.replace запускает его
+// в отдельной горутине, чтобы не держать датасет, поэтому версии могут прийти не по порядку:
+// поиск, уже посчитанный по более новой версии, не трогается. Сами поиски считаются без mu
+func (ss *savedSearchStore) reevaluate(store *datasetStore, ds *Dataset) {
+	type result struct {
+		s   *savedSearch
+		ids []int
+	}
+	var results []result
+	ss.mu.Lock()
+	for _, s := range ss.searches {
+		if s.store == store && s.generation < ds.generation {
+			results = append(results, result{s: s})
+		}
+	}
+	ss.mu.Unlock()
+
+	for i := range results {
+		results[i].ids = ds.searchIDs(results[i].s.Request)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, r := range results {
+		s, ids := r.s, r.ids
+		if s.deleted || s.generation >= ds.generation {
+			continue
+		}
+		s.generation = ds.generation
+		added, removed := diffIDs(s.UserIDs, ids)
+		s.Snapshot, s.UserIDs = ds.Snapshot, ids
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		s.Version++
+		s.history = append(s.history, SearchDiff{
+			SearchID: s.ID,
+			Version:  s.Version,
+			Snapshot: ds.Snapshot,
+			At:       time.Now().UTC(),
+			Added:    added,
+			Removed:  removed,
+		})
+		if len(s.history) > savedSearchHistory {
+			s.history = s.history[len(s.history)-savedSearchHistory:]
+		}
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// changes - изменения после версии since и канал, который закроется при следующем
+func (ss *savedSearchStore) changes(s *savedSearch, since int) ([]SearchDiff, <-chan struct{}, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if s.deleted {
+		return nil, nil, ErrSavedSearchNotFound
+	}
+	diffs := []SearchDiff{}
+	if since >= s.Version {
+		return diffs, s.changed, nil
+	}
+	if len(s.history) == 0 || since < s.history[0].Version-1 {
+		return nil, nil, ErrChangesExpired
+	}
+	for _, diff := range s.history {
+		if diff.Version > since {
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, s.changed, nil
+}
+
+func (ss *savedSearchStore) stopping() <-chan struct{} {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.stop == nil {
+		ss.stop = make(chan struct{})
+	}
+	return ss.stop
+}
+
+// stopStreams завершает ожидающие long-poll и SSE, вызывается при остановке сервера
+func (ss *savedSearchStore) stopStreams() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.stop != nil {
+		close(ss.stop)
+		ss.stop = nil
+	}
+}
+
+// searchIDs - id всех подходящих записей в порядке выдачи
+func (ds *Dataset) searchIDs(sr SearchRequest) []int {
+	positions := ds.search(sr)
+	ids := make([]int, len(positions))
+	for i, pos := range positions {
+		ids[i] = ds.Persons[pos].ID
+	}
+	return ids
+}
+
+// diffIDs - какие id появились в next и какие пропали из prev, в порядке выдачи
+func diffIDs(prev, next []int) (added, removed []int) {
+	inPrev := make(map[int]bool, len(prev))
+	for _, id := range prev {
+		inPrev[id] = true
+	}
+	inNext := make(map[int]bool, len(next))
+	for _, id := range next {
+		inNext[id] = true
+	}
+
+	added, removed = []int{}, []int{}
+	for _, id := range next {
+		if !inPrev[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range prev {
+		if !inNext[id] {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// SavedSearchesServer - сохранённые поиски токена:
+// POST /searches с параметрами поиска создаёт, GET /searches перечисляет,
+// GET /searches/{id} отдаёт текущий результат, DELETE /searches/{id} удаляет
+func SavedSearchesServer(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("AccessToken")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+	store := storeFor(r)
+
+	id := r.PathValue("id")
+	if id == "" {
+		switch r.Method {
+		case http.MethodPost:
+			r.ParseForm()
+			sr, err := parseSearchParams(r.Form)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			sr.Limit, sr.Offset, sr.Snapshot, sr.Fields = 0, 0, "", 0
+			saved, err := savedSearches.create(store, token, sr)
+			if errors.Is(err, ErrTooManySavedSearches) {
+				writeError(w, http.StatusTooManyRequests, err.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			// сохранённый поиск сразу отдаёт найденных, так что пишется в аудит как обычный поиск
+			if err := auditSearch(r, token, saved.Snapshot, sr, saved.UserIDs); err != nil {
+				if s, ok := savedSearches.get(store, token, saved.ID); ok {
+					savedSearches.delete(s)
+				}
+				writeAuditError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, saved)
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, savedSearches.list(store, token))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "unknown method")
+		}
+		return
+	}
+
+	s, ok := savedSearches.get(store, token, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrSavedSearchNotFound.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		saved := savedSearches.snapshot(s)
+		if err := auditRead(r, "searches", token, saved.Snapshot, "id="+url.QueryEscape(saved.ID), saved.UserIDs); err != nil {
+			writeAuditError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, saved)
+	case http.MethodDelete:
+		savedSearches.delete(s)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "unknown method")
+	}
+}
+
+// SearchChangesServer - GET /searches/{id}/changes?since=N отдаёт изменения после версии N.
+// С Accept: text/event-stream - потоком SSE, id события - версия, так что переподключение
+// с Last-Event-ID продолжает с того же места. Иначе long-poll: если изменений ещё нет,
+// ждёт до wait (по умолчанию 30s) и отдаёт то, что появилось, или пустой список
+func SearchChangesServer(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("AccessToken")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "unknown method")
+		return
+	}
+	s, ok := savedSearches.get(storeFor(r), token, r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrSavedSearchNotFound.Error())
+		return
+	}
+
+	since, wait := 0, defaultChangesWait
+	if raw := r.FormValue("since"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid since value")
+			return
+		}
+		since = n
+	}
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > since {
+			since = n
+		}
+	}
+	if raw := r.FormValue("wait"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid wait value")
+			return
+		}
+		wait = min(d, maxChangesWait)
+	}
+
+	diffs, changed, err := savedSearches.changes(s, since)
+	switch {
+	case errors.Is(err, ErrSavedSearchNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	stop := savedSearches.stopping()
+
+	if !strings.Contains(r.Header.Get("Accept"), ContentTypeEventStream) {
+		if len(diffs) == 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-changed:
+				if diffs, _, err = savedSearches.changes(s, since); err != nil {
+					writeError(w, http.StatusNotFound, err.Error())
+					return
+				}
+			case <-timer.C:
+			case <-stop:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if err := auditChanges(r, token, s, since, diffs); err != nil {
+			writeAuditError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, diffs)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", ContentTypeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		if err := auditChanges(r, token, s, since, diffs); err != nil {
+			// статус уже отдан, так что просто обрываем поток
+			accessLog.Error("audit log write failed", "error", err.Error())
+			return
+		}
+		for _, diff := range diffs {
+			data, _ := json.Marshal(diff)
+			fmt.Fprintf(w, "id: %d\nevent: diff\ndata: %s\n\n", diff.Version, data)
+			since = diff.Version
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		case <-stop:
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		diffs, changed, err = savedSearches.changes(s, since)
+		if err != nil {
+			event := "deleted"
+			if errors.Is(err, ErrChangesExpired) {
+				event = "expired"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", event)
+			return
+		}
+	}
+}
+
+// auditChanges записывает в журнал id, которые появились в отдаваемых изменениях
+func auditChanges(r *http.Request, token string, s *savedSearch, since int, diffs []SearchDiff) error {
+	if len(diffs) == 0 {
+		return nil
+	}
+	var ids []int
+	for _, diff := range diffs {
+		ids = append(ids, diff.Added...)
+	}
+	params := url.Values{"id": {s.ID}, "since": {strconv.Itoa(since)}}
+	return auditRead(r, "changes", token, diffs[len(diffs)-1].Snapshot, params.Encode(), ids)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// subscribeClient без таймаута: long-poll и SSE ждут дольше секунды
+var subscribeClient = &http.Client{Transport: defaultTransport}
+
+func (srv *SearchClient) savedSearchesURL(parts ...string) (string, error) {
+	return srv.datasetURL(append([]string{"searches"}, parts...)...)
+}
+
+// savedSearchRequest делает запрос к /searches и разбирает JSON-ответ в result
+func (srv *SearchClient) savedSearchRequest(ctx context.Context, method string, body url.Values, result interface{}, parts ...string) error {
+	searchesURL, err := srv.savedSearchesURL(parts...)
+	if err != nil {
+		return err
+	}
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = strings.NewReader(body.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, searchesURL, reqBody)
+	if err != nil {
+		return fmt.Errorf("bad URL %s: %s", searchesURL, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return srv.doSavedSearch(req, result)
+}
+
+func (srv *SearchClient) doSavedSearch(req *http.Request, result interface{}) error {
+	req.Header.Add("AccessToken", srv.AccessToken)
+	req.Header.Add(TraceParentHeader, outgoingTrace(req.Context()).String())
+
+	resp, err := srv.streamClient(subscribeClient).Do(req)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return req.Context().Err()
+		}
+		return fmt.Errorf("unknown error %s", err)
+	}
+	defer drainAndClose(resp.Body)
+	body, err := io.ReadAll(limitBody(resp, srv.maxResponseBytes()))
+	if err != nil {
+		return fmt.Errorf("cant read response: %w", err)
+	}
+	if err := savedSearchError(resp.StatusCode, body); err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("cant unpack saved search json: %s", err)
+	}
+	return nil
+}
+
+func savedSearchError(status int, body []byte) error {
+	switch status {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrBadAccessToken
+	case http.StatusInternalServerError:
+		return ErrServerFatal
+	case http.StatusGone:
+		return ErrChangesExpired
+	}
+	errResp := SearchErrorResponse{}
+	if err := json.Unmarshal(body, &errResp); err != nil {
+		return fmt.Errorf("cant unpack error json: %s", err)
+	}
+	switch {
+	case status == http.StatusTooManyRequests && errResp.Error == ErrTooManySavedSearches.Error():
+		return ErrTooManySavedSearches
+	case status == http.StatusNotFound && errResp.Error == ErrDatasetNotFound.Error():
+		return ErrDatasetNotFound
+	case status == http.StatusNotFound:
+		return ErrSavedSearchNotFound
+	}
+	return badRequestError("unknown bad request error: " + errResp.Error)
+}
+
+// SaveSearch сохраняет поиск на SearchServer. Limit, Offset, Snapshot и Fields не учитываются.
+// Если у токена уже слишком много поисков - ErrTooManySavedSearches, пока какой-нибудь не удалят
+func (srv *SearchClient) SaveSearch(ctx context.Context, req SearchRequest) (*SavedSearch, error) {
+	params := url.Values{}
+	params.Add("query", req.Query)
+	params.Add("order_field", req.OrderField)
+	params.Add("order_by", strconv.Itoa(req.OrderBy))
+	addFilterParams(params, req)
+
+	saved := &SavedSearch{}
+	if err := srv.savedSearchRequest(ctx, http.MethodPost, params, saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// SavedSearch отдаёт текущий результат сохранённого поиска
+func (srv *SearchClient) SavedSearch(ctx context.Context, id string) (*SavedSearch, error) {
+	saved := &SavedSearch{}
+	if err := srv.savedSearchRequest(ctx, http.MethodGet, nil, saved, id); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// SavedSearches - все сохранённые поиски токена
+func (srv *SearchClient) SavedSearches(ctx context.Context) ([]SavedSearch, error) {
+	var saved []SavedSearch
+	if err := srv.savedSearchRequest(ctx, http.MethodGet, nil, &saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// DeleteSearch удаляет сохранённый поиск, его подписки завершаются с ErrSavedSearchNotFound
+func (srv *SearchClient) DeleteSearch(ctx context.Context, id string) error {
+	return srv.savedSearchRequest(ctx, http.MethodDelete, nil, nil, id)
+}
+
+// PollChanges - long-poll: изменения после версии since, если их нет - ждёт до wait.
+// Пустой результат значит, что за wait ничего не поменялось
+func (srv *SearchClient) PollChanges(ctx context.Context, id string, since int, wait time.Duration) ([]SearchDiff, error) {
+	changesURL, err := srv.savedSearchesURL(id, "changes")
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Add("since", strconv.Itoa(since))
+	params.Add("wait", wait.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, changesURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("bad URL %s: %s", changesURL, err)
+	}
+	var diffs []SearchDiff
+	if err := srv.doSavedSearch(req, &diffs); err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// Subscribe подписывается на изменения сохранённого поиска после версии since через SSE.
+// Подписка длится, пока не отменят ctx или не выйдут из цикла; если поиск удалили,
+// приходит ErrSavedSearchNotFound, если подписчик безнадёжно отстал - ErrChangesExpired
+func (srv *SearchClient) Subscribe(ctx context.Context, id string, since int) iter.Seq2[*SearchDiff, error] {
+	return func(yield func(*SearchDiff, error) bool) {
+		changesURL, err := srv.savedSearchesURL(id, "changes")
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, changesURL+"?since="+strconv.Itoa(since), nil)
+		if err != nil {
+			yield(nil, fmt.Errorf("bad URL %s: %s", changesURL, err))
+			return
+		}
+		req.Header.Add("AccessToken", srv.AccessToken)
+		req.Header.Add(TraceParentHeader, outgoingTrace(ctx).String())
+		req.Header.Add("Accept", ContentTypeEventStream)
+
+		resp, err := srv.streamClient(subscribeClient).Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				yield(nil, fmt.Errorf("unknown error %s", err))
+			}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxDrainBytes))
+			yield(nil, savedSearchError(resp.StatusCode, body))
+			return
+		}
+
+		// событие SSE - строки "поле: значение" до пустой строки, строки с ":" в начале - комментарии
+		var event string
+		var data []byte
+		// не bufio.Scanner: в одном diff могут быть все id датасета, и длину строки не ограничить
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				yield(nil, fmt.Errorf("subscription closed: %w", err))
+				return
+			}
+			line = bytes.TrimRight(line, "\r\n")
+			switch {
+			case len(line) == 0:
+				switch event {
+				case "diff":
+					diff := &SearchDiff{}
+					if err := json.Unmarshal(data, diff); err != nil {
+						yield(nil, fmt.Errorf("cant unpack diff json: %s", err))
+						return
+					}
+					if !yield(diff, nil) {
+						return
+					}
+				case "deleted":
+					yield(nil, ErrSavedSearchNotFound)
+					return
+				case "expired":
+					yield(nil, ErrChangesExpired)
+					return
+				}
+				event, data = "", nil
+			case bytes.HasPrefix(line, []byte("event:")):
+				event = string(bytes.TrimSpace(line[len("event:"):]))
+			case bytes.HasPrefix(line, []byte("data:")):
+				data = append(data, bytes.TrimSpace(line[len("data:"):])...)
+			}
+		}
+	}
+}
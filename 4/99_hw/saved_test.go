@@ -0,0 +1,303 @@
+package hw4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// useSavedSearches подменяет хранилище сохранённых поисков на пустое
+func useSavedSearches(t *testing.T) {
+	t.Helper()
+	old := savedSearches
+	savedSearches = &savedSearchStore{}
+	t.Cleanup(func() { savedSearches = old })
+}
+
+func addPerson(t *testing.T, p Person) {
+	t.Helper()
+	err := defaultDatasets.update(func(persons []Person) ([]Person, error) {
+		return append(persons, p), nil
+	})
+	if err != nil {
+		t.Fatalf("cant add person: %v", err)
+	}
+}
+
+func removePerson(t *testing.T, id int) {
+	t.Helper()
+	err := defaultDatasets.update(func(persons []Person) ([]Person, error) {
+		return append(persons[:findPerson(persons, id)], persons[findPerson(persons, id)+1:]...), nil
+	})
+	if err != nil {
+		t.Fatalf("cant remove person: %v", err)
+	}
+}
+
+func TestSavedSearchChanges(t *testing.T) {
+	useDatasetCopy(t)
+	useSavedSearches(t)
+	audit := useAuditLog(t, 0, 0)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	ctx := context.Background()
+
+	crm := &SearchClient{AccessToken: "crm", URL: server.URL}
+	saved, err := crm.SaveSearch(ctx, SearchRequest{Query: "Zed", Limit: 1, Offset: 3})
+	if err != nil {
+		t.Fatalf("cant save search: %v", err)
+	}
+	if saved.ID == "" || saved.Version != 0 || len(saved.UserIDs) != 0 || saved.Request.Limit != 0 || saved.Request.Offset != 0 {
+		t.Fatalf("wrong saved search: %+v", saved)
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	diffs := make(chan *SearchDiff)
+	subErr := make(chan error, 1)
+	go func() {
+		for diff, err := range crm.Subscribe(subCtx, saved.ID, 0) {
+			if err != nil {
+				subErr <- err
+				return
+			}
+			diffs <- diff
+		}
+	}()
+
+	// long-poll без изменений ждёт wait и отдаёт пустой список
+	started := time.Now()
+	if got, err := crm.PollChanges(ctx, saved.ID, 0, 50*time.Millisecond); err != nil || len(got) != 0 || time.Since(started) < 50*time.Millisecond {
+		t.Fatalf("expected empty long-poll after wait, got %+v, %v", got, err)
+	}
+
+	// а тот, что начался до изменения, возвращается сразу после него
+	polled := make(chan []SearchDiff, 1)
+	go func() {
+		got, _ := crm.PollChanges(ctx, saved.ID, 0, 5*time.Second)
+		polled <- got
+	}()
+	time.Sleep(50 * time.Millisecond)
+	addPerson(t, Person{ID: 100, FirstName: "Zed", LastName: "Ray", Age: 30, Gender: "male"})
+
+	want := SearchDiff{SearchID: saved.ID, Version: 1, Added: []int{100}, Removed: []int{}}
+	for _, got := range []SearchDiff{*<-diffs, (<-polled)[0]} {
+		if got.Snapshot == "" || got.At.IsZero() {
+			t.Errorf("diff without snapshot or time: %+v", got)
+		}
+		got.Snapshot, got.At = "", time.Time{}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong diff %+v, want %+v", got, want)
+		}
+	}
+
+	removePerson(t, 100)
+	if got := <-diffs; got.Version != 2 || !reflect.DeepEqual(got.Removed, []int{100}) || len(got.Added) != 0 {
+		t.Errorf("wrong removal diff: %+v", got)
+	}
+	if got, err := crm.PollChanges(ctx, saved.ID, 0, 0); err != nil || len(got) != 2 {
+		t.Errorf("expected both diffs since 0, got %+v, %v", got, err)
+	}
+	// отданные в изменениях id попадают в журнал аудита
+	records, err := audit.Query(AuditQuery{Token: "crm", Limit: 1})
+	if err != nil || len(records) != 1 || records[0].Endpoint != "changes" || !reflect.DeepEqual(records[0].UserIDs, []int{100}) {
+		t.Errorf("wrong changes audit record: %+v, %v", records, err)
+	}
+	current, err := crm.SavedSearch(ctx, saved.ID)
+	if err != nil || current.Version != 2 || len(current.UserIDs) != 0 {
+		t.Errorf("wrong current state: %+v, %v", current, err)
+	}
+
+	// чужой токен и чужой датасет поиска не видят
+	other := &SearchClient{AccessToken: "other", URL: server.URL}
+	if _, err := other.SavedSearch(ctx, saved.ID); !errors.Is(err, ErrSavedSearchNotFound) {
+		t.Errorf("expected ErrSavedSearchNotFound for other token, got %v", err)
+	}
+	if list, err := other.SavedSearches(ctx); err != nil || len(list) != 0 {
+		t.Errorf("other token must see no searches: %+v, %v", list, err)
+	}
+	if list, err := crm.SavedSearches(ctx); err != nil || len(list) != 1 || list[0].ID != saved.ID {
+		t.Errorf("wrong list: %+v, %v", list, err)
+	}
+
+	if err := crm.DeleteSearch(ctx, saved.ID); err != nil {
+		t.Fatalf("cant delete: %v", err)
+	}
+	if err := <-subErr; !errors.Is(err, ErrSavedSearchNotFound) {
+		t.Errorf("subscription must end with ErrSavedSearchNotFound, got %v", err)
+	}
+	if err := crm.DeleteSearch(ctx, saved.ID); !errors.Is(err, ErrSavedSearchNotFound) {
+		t.Errorf("second delete: expected ErrSavedSearchNotFound, got %v", err)
+	}
+}
+
+func TestSavedSearchErrors(t *testing.T) {
+	useSavedSearches(t)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	ctx := context.Background()
+
+	if _, err := (&SearchClient{URL: server.URL}).SaveSearch(ctx, SearchRequest{}); !errors.Is(err, ErrBadAccessToken) {
+		t.Errorf("expected ErrBadAccessToken, got %v", err)
+	}
+	crm := &SearchClient{AccessToken: "crm", URL: server.URL}
+	if _, err := crm.SaveSearch(ctx, SearchRequest{OrderField: "Salary"}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest, got %v", err)
+	}
+	missing := &SearchClient{AccessToken: "crm", URL: server.URL, Dataset: "missing"}
+	if _, err := missing.SaveSearch(ctx, SearchRequest{}); !errors.Is(err, ErrDatasetNotFound) {
+		t.Errorf("expected ErrDatasetNotFound, got %v", err)
+	}
+	for _, err := range crm.Subscribe(ctx, "nope", 0) {
+		if !errors.Is(err, ErrSavedSearchNotFound) {
+			t.Errorf("expected ErrSavedSearchNotFound, got %v", err)
+		}
+	}
+}
+
+// diff больше буфера bufio.Scanner по умолчанию не обрывает подписку
+func TestSubscribeLargeDiff(t *testing.T) {
+	diff := SearchDiff{SearchID: "big", Version: 1, Added: make([]int, 100000)}
+	for i := range diff.Added {
+		diff.Added[i] = i
+	}
+	data, _ := json.Marshal(diff)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentTypeEventStream)
+		fmt.Fprintf(w, "event: diff\r\ndata: %s\r\n\r\n", data)
+	}))
+	defer server.Close()
+
+	client := &SearchClient{AccessToken: "crm", URL: server.URL}
+	for got, err := range client.Subscribe(context.Background(), "big", 0) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got.Added) != len(diff.Added) || len(data) < 64<<10 {
+			t.Errorf("wrong diff: %d ids in %d bytes", len(got.Added), len(data))
+		}
+		break
+	}
+}
+
+func TestSavedSearchHistory(t *testing.T) {
+	ss := &savedSearchStore{}
+	s := &savedSearch{SavedSearch: SavedSearch{Version: 7}, changed: make(chan struct{})}
+	s.history = []SearchDiff{{Version: 6}, {Version: 7}}
+
+	if diffs, _, err := ss.changes(s, 5); err != nil || len(diffs) != 2 {
+		t.Errorf("since 5: expected 2 diffs, got %+v, %v", diffs, err)
+	}
+	if diffs, _, err := ss.changes(s, 7); err != nil || len(diffs) != 0 {
+		t.Errorf("since 7: expected no diffs, got %+v, %v", diffs, err)
+	}
+	if _, _, err := ss.changes(s, 4); !errors.Is(err, ErrChangesExpired) {
+		t.Errorf("since 4: expected ErrChangesExpired, got %v", err)
+	}
+}
+
+func TestServeEndsSubscriptions(t *testing.T) {
+	useSavedSearches(t)
+	url, _, _, stop := startServe(t, ServerConfig{ShutdownTimeout: 5 * time.Second})
+	ctx := context.Background()
+
+	crm := &SearchClient{AccessToken: "crm", URL: url}
+	saved, err := crm.SaveSearch(ctx, SearchRequest{Query: "Zed"})
+	if err != nil {
+		t.Fatalf("cant save search: %v", err)
+	}
+	subErr := make(chan error, 1)
+	go func() {
+		for _, err := range crm.Subscribe(ctx, saved.ID, 0) {
+			subErr <- err
+			return
+		}
+		subErr <- nil
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// подписка не должна держать остановку до ShutdownTimeout
+	started := time.Now()
+	if err := stop(); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("shutdown waited for subscription: %s", elapsed)
+	}
+	if err := <-subErr; err == nil {
+		t.Errorf("expected subscription to report closed stream")
+	}
+}
+
+func TestSavedSearchLimitPerToken(t *testing.T) {
+	useSavedSearches(t)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	ctx := context.Background()
+
+	for i := 0; i < maxSavedSearchesPerToken; i++ {
+		if _, err := savedSearches.create(defaultDatasets, "crm", SearchRequest{Query: "Boyd"}); err != nil {
+			t.Fatalf("search %d: %v", i, err)
+		}
+	}
+	crm := &SearchClient{AccessToken: "crm", URL: server.URL}
+	if _, err := crm.SaveSearch(ctx, SearchRequest{Query: "Boyd"}); !errors.Is(err, ErrTooManySavedSearches) {
+		t.Fatalf("expected ErrTooManySavedSearches, got %v", err)
+	}
+	// лимит у каждого токена свой
+	if _, err := (&SearchClient{AccessToken: "other", URL: server.URL}).SaveSearch(ctx, SearchRequest{Query: "Boyd"}); err != nil {
+		t.Errorf("other token: %v", err)
+	}
+
+	list, err := crm.SavedSearches(ctx)
+	if err != nil || len(list) != maxSavedSearchesPerToken {
+		t.Fatalf("expected %d searches, got %d, %v", maxSavedSearchesPerToken, len(list), err)
+	}
+	if err := crm.DeleteSearch(ctx, list[0].ID); err != nil {
+		t.Fatalf("cant delete: %v", err)
+	}
+	if _, err := crm.SaveSearch(ctx, SearchRequest{Query: "Boyd"}); err != nil {
+		t.Errorf("expected a free slot after delete, got %v", err)
+	}
+}
+
+// reevaluate идёт в своей горутине, и версия, которая пришла позже более новой, не откатывает поиск
+func TestSavedSearchReevaluateOutOfOrder(t *testing.T) {
+	ss := &savedSearchStore{}
+	store := &datasetStore{}
+	older := NewDataset([]Person{{ID: 1, FirstName: "Zed"}})
+	older.Snapshot, older.generation = "older", 2
+	newer := NewDataset([]Person{{ID: 1, FirstName: "Zed"}, {ID: 2, FirstName: "Zed"}})
+	newer.Snapshot, newer.generation = "newer", 3
+
+	s := &savedSearch{SavedSearch: SavedSearch{ID: "s", Request: SearchRequest{Query: "Zed"}, UserIDs: []int{}}, store: store, generation: 1, changed: make(chan struct{})}
+	ss.searches = map[string]*savedSearch{s.ID: s}
+
+	ss.reevaluate(store, newer)
+	ss.reevaluate(store, older)
+	if got := ss.snapshot(s); got.Snapshot != "newer" || got.Version != 1 || !reflect.DeepEqual(got.UserIDs, []int{1, 2}) {
+		t.Errorf("older dataset overwrote the newer one: %+v", got)
+	}
+}
+
+// владелец сравнивается по полному хэшу токена, а не по короткому отпечатку из логов
+func TestSavedSearchOwner(t *testing.T) {
+	ss := &savedSearchStore{}
+	saved, err := ss.create(defaultDatasets, "crm", SearchRequest{Query: "Boyd"})
+	if err != nil {
+		t.Fatalf("cant save search: %v", err)
+	}
+	s := ss.searches[saved.ID]
+	if s.owner != ownerOf("crm") || len(s.owner) != 32 {
+		t.Errorf("wrong owner key %x", s.owner)
+	}
+	if _, ok := ss.get(defaultDatasets, "other", saved.ID); ok {
+		t.Errorf("other token sees the search")
+	}
+}
@@ -0,0 +1,369 @@
+// Package searchtest - фейковый SearchServer для тестов кода, который ходит в поиск через hw4.SearchClient.
+// Сервер живёт в процессе, отдаёт заданных пользователей, умеет по сценарию ломаться
+// и запоминает все пришедшие запросы, чтобы по ним можно было проверить параметры
+package searchtest
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hw4"
+)
+
+// Fault - поломка, которую фейк изображает вместо обычного ответа
+type Fault struct {
+	// задержка перед ответом
+	Latency time.Duration
+	// не отвечать вовсе, пока клиент не уйдёт по таймауту
+	Hang bool
+	// ответить этим кодом и {"error": Message}
+	Status  int
+	Message string
+	// ответить 200 с битым телом
+	BadJSON bool
+}
+
+// Latency отвечает как обычно, но через d
+func Latency(d time.Duration) Fault {
+	return Fault{Latency: d}
+}
+
+// Timeout не отвечает, так что клиент уходит по таймауту
+func Timeout() Fault {
+	return Fault{Hang: true}
+}
+
+func InternalError() Fault {
+	return Fault{Status: http.StatusInternalServerError, Message: "internal error"}
+}
+
+func Unauthorized() Fault {
+	return Fault{Status: http.StatusUnauthorized, Message: "Bad AccessToken"}
+}
+
+func BadJSON() Fault {
+	return Fault{BadJSON: true}
+}
+
+// BadRequest отвечает 400 с текстом ошибки, как SearchServer на неверные параметры
+func BadRequest(message string) Fault {
+	return Fault{Status: http.StatusBadRequest, Message: message}
+}
+
+// Request - запрос, который получил фейк
+type Request struct {
+	AccessToken string
+	Header      http.Header
+	Params      url.Values
+	// Search - разобранные параметры. Limit - как его передали в FindUsers,
+	// то есть на 1 меньше пришедшего limit, сам параметр остаётся в Params
+	Search hw4.SearchRequest
+	// код ответа, 0 - если фейк не ответил
+	Status int
+}
+
+// Server - фейковый SearchServer. Закрывать через Close, как обычный httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	token    string
+	users    []hw4.User
+	faults   []Fault
+	always   *Fault
+	requests []Request
+}
+
+// NewServer запускает фейк, который ищет по users так же, как SearchServer:
+// подстрока в Name или About, сортировка по Id, Age или Name, offset и limit.
+// Дополнительные поля (Email, Phone, Address, Balance) отдаются как заданы, если запрошены в fields,
+// политик редактирования у фейка нет. Фильтры state, city, registered_from и registered_to
+// фейк только записывает в Request, но не применяет
+func NewServer(users ...hw4.User) *Server {
+	s := &Server{users: slices.Clone(users)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client - SearchClient, настроенный на фейк
+func (s *Server) Client(accessToken string) *hw4.SearchClient {
+	return &hw4.SearchClient{URL: s.URL, AccessToken: accessToken}
+}
+
+// SetUsers заменяет пользователей, по которым идёт поиск
+func (s *Server) SetUsers(users ...hw4.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = slices.Clone(users)
+}
+
+// RequireToken включает проверку токена: с любым другим AccessToken фейк отвечает 401
+func (s *Server) RequireToken(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = accessToken
+}
+
+// FailNext ставит поломки в очередь: каждая достаётся одному следующему запросу
+func (s *Server) FailNext(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+// FailAlways ломает все запросы, после того как кончится очередь FailNext
+func (s *Server) FailAlways(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.always = &fault
+}
+
+// Heal убирает все поломки
+func (s *Server) Heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults, s.always = nil, nil
+}
+
+// Requests отдаёт копию всех полученных запросов по порядку
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	req := Request{
+		AccessToken: r.Header.Get("AccessToken"),
+		Header:      r.Header.Clone(),
+		Params:      r.Form,
+		Search:      parseSearch(r.Form),
+	}
+
+	s.mu.Lock()
+	var fault *Fault
+	if len(s.faults) > 0 {
+		fault = &s.faults[0]
+		s.faults = s.faults[1:]
+	} else {
+		fault = s.always
+	}
+	if s.token != "" && req.AccessToken != s.token {
+		fault = &Fault{Status: http.StatusUnauthorized, Message: "Bad AccessToken"}
+	}
+	users := s.users
+	i := len(s.requests)
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	status := s.respond(w, r, fault, users)
+
+	s.mu.Lock()
+	s.requests[i].Status = status
+	s.mu.Unlock()
+}
+
+// respond отвечает на запрос и возвращает код ответа
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, fault *Fault, users []hw4.User) int {
+	if fault != nil {
+		if fault.Hang {
+			<-r.Context().Done()
+			return 0
+		}
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return 0
+			}
+		}
+		if fault.BadJSON {
+			w.Header().Set("Content-Type", hw4.ContentTypeJSON)
+			w.Write([]byte(`{"broken`))
+			return http.StatusOK
+		}
+		if fault.Status != 0 {
+			writeError(w, fault.Status, fault.Message)
+			return fault.Status
+		}
+	}
+
+	found, status, message := search(users, r.Form)
+	if status != http.StatusOK {
+		writeError(w, status, message)
+		return status
+	}
+	fields := parseSearch(r.Form).Fields
+	for i := range found {
+		found[i] = withFields(found[i], fields)
+	}
+	body, _ := json.Marshal(found)
+	w.Header().Set("Content-Type", hw4.ContentTypeJSON)
+	w.Write(body)
+	return http.StatusOK
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(hw4.SearchErrorResponse{Error: message})
+	w.Header().Set("Content-Type", hw4.ContentTypeJSON)
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func parseSearch(params url.Values) hw4.SearchRequest {
+	sr := hw4.SearchRequest{
+		Query:      params.Get("query"),
+		OrderField: params.Get("order_field"),
+		Snapshot:   params.Get("snapshot"),
+		State:      params.Get("state"),
+		City:       params.Get("city"),
+	}
+	for _, name := range strings.Split(params.Get("fields"), ",") {
+		sr.Fields |= extraFields[name]
+	}
+	sr.RegisteredFrom, _ = time.Parse(time.RFC3339, params.Get("registered_from"))
+	sr.RegisteredTo, _ = time.Parse(time.RFC3339, params.Get("registered_to"))
+	sr.OrderBy, _ = strconv.Atoi(params.Get("order_by"))
+	sr.Offset, _ = strconv.Atoi(params.Get("offset"))
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil {
+		sr.Limit = limit - 1
+	}
+	return sr
+}
+
+var extraFields = map[string]hw4.ExtraFields{
+	"email":   hw4.FieldEmail,
+	"phone":   hw4.FieldPhone,
+	"address": hw4.FieldAddress,
+	"balance": hw4.FieldBalance,
+}
+
+// withFields убирает незапрошенные дополнительные поля
+func withFields(user hw4.User, fields hw4.ExtraFields) hw4.User {
+	if fields&hw4.FieldEmail == 0 {
+		user.Email = ""
+	}
+	if fields&hw4.FieldPhone == 0 {
+		user.Phone = ""
+	}
+	if fields&hw4.FieldAddress == 0 {
+		user.Address = ""
+	}
+	if fields&hw4.FieldBalance == 0 {
+		user.Balance = ""
+	}
+	return user
+}
+
+var comparators = map[string]func(a, b hw4.User) int{
+	"Id":   func(a, b hw4.User) int { return cmp.Compare(a.Id, b.Id) },
+	"Age":  func(a, b hw4.User) int { return cmp.Compare(a.Age, b.Age) },
+	"Name": func(a, b hw4.User) int { return strings.Compare(a.Name, b.Name) },
+	"":     func(a, b hw4.User) int { return strings.Compare(a.Name, b.Name) },
+}
+
+// search повторяет логику и тексты ошибок SearchServer
+func search(users []hw4.User, params url.Values) ([]hw4.User, int, string) {
+	var offset, limit, orderBy int
+	for name, value := range map[string]*int{"offset": &offset, "limit": &limit, "order_by": &orderBy} {
+		if raw := params.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				return nil, http.StatusBadRequest, "Invalid " + name + " value"
+			}
+			*value = n
+		}
+	}
+	if orderBy != hw4.OrderByAsIs && orderBy != hw4.OrderByAsc && orderBy != hw4.OrderByDesc {
+		return nil, http.StatusBadRequest, "Invalid order_by value"
+	}
+	compare, ok := comparators[params.Get("order_field")]
+	if !ok {
+		return nil, http.StatusBadRequest, "Invalid order_field value"
+	}
+
+	query := params.Get("query")
+	found := []hw4.User{}
+	for _, user := range users {
+		if strings.Contains(user.Name, query) || strings.Contains(user.About, query) {
+			found = append(found, user)
+		}
+	}
+	if orderBy != hw4.OrderByAsIs {
+		slices.SortStableFunc(found, compare)
+		if orderBy == hw4.OrderByDesc {
+			slices.Reverse(found)
+		}
+	}
+
+	if offset < 0 || offset > len(found) {
+		return nil, http.StatusBadRequest, "Invalid offset value"
+	}
+	found = found[offset:]
+	if limit < 0 {
+		return nil, http.StatusBadRequest, "Invalid limit value"
+	}
+	if limit != 0 && limit < len(found) {
+		found = found[:limit]
+	}
+	return found, http.StatusOK, ""
+}
+
+// AssertRequestCount проверяет, сколько запросов получил фейк
+func (s *Server) AssertRequestCount(t testing.TB, want int) {
+	t.Helper()
+	if got := len(s.Requests()); got != want {
+		t.Errorf("searchtest: got %d requests, want %d", got, want)
+	}
+}
+
+// AssertRequest проверяет разобранные параметры i-го запроса, считая с 0
+func (s *Server) AssertRequest(t testing.TB, i int, want hw4.SearchRequest) {
+	t.Helper()
+	req, ok := s.request(t, i)
+	if ok && !equalSearch(req.Search, want) {
+		t.Errorf("searchtest: request %d is %+v, want %+v", i, req.Search, want)
+	}
+}
+
+func equalSearch(a, b hw4.SearchRequest) bool {
+	if !a.RegisteredFrom.Equal(b.RegisteredFrom) || !a.RegisteredTo.Equal(b.RegisteredTo) {
+		return false
+	}
+	a.RegisteredFrom, a.RegisteredTo = b.RegisteredFrom, b.RegisteredTo
+	return a == b
+}
+
+// AssertParam проверяет сырой параметр i-го запроса так, как он пришёл по сети
+func (s *Server) AssertParam(t testing.TB, i int, name, want string) {
+	t.Helper()
+	req, ok := s.request(t, i)
+	if !ok {
+		return
+	}
+	if got, present := req.Params[name]; !present {
+		t.Errorf("searchtest: request %d has no %s param, want %q", i, name, want)
+	} else if got[0] != want {
+		t.Errorf("searchtest: request %d has %s=%q, want %q", i, name, got[0], want)
+	}
+}
+
+func (s *Server) request(t testing.TB, i int) (Request, bool) {
+	t.Helper()
+	requests := s.Requests()
+	if i < 0 || i >= len(requests) {
+		t.Errorf("searchtest: no request %d, got %d requests", i, len(requests))
+		return Request{}, false
+	}
+	return requests[i], true
+}
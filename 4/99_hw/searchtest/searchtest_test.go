@@ -0,0 +1,99 @@
+package searchtest
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"hw4"
+)
+
+var users = []hw4.User{
+	{Id: 0, Name: "Boyd Wolf", Age: 22, About: "Nulla cillum", Gender: "male"},
+	{Id: 1, Name: "Hilda Mayer", Age: 21, About: "Sit commodo", Gender: "female"},
+	{Id: 2, Name: "Brooks Aguilar", Age: 25, About: "Velit ullamco", Gender: "male"},
+}
+
+func TestServerSearch(t *testing.T) {
+	srv := NewServer(users...)
+	defer srv.Close()
+	client := srv.Client("token")
+
+	resp, err := client.FindUsers(hw4.SearchRequest{Limit: 1, OrderField: "Age", OrderBy: hw4.OrderByAsc})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].Id != 1 || !resp.NextPage {
+		t.Errorf("wrong first page: %+v", resp)
+	}
+
+	resp, err = client.FindUsers(hw4.SearchRequest{Limit: 10, Query: "B"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Users) != 2 || resp.NextPage {
+		t.Errorf("wrong search result: %+v", resp)
+	}
+
+	if _, err := client.FindUsers(hw4.SearchRequest{Limit: 1, OrderField: "About"}); err == nil || !strings.Contains(err.Error(), "Invalid order_field value") {
+		t.Errorf("expected order_field error, got %v", err)
+	}
+
+	srv.SetUsers(hw4.User{Id: 7, Name: "Boyd Wolf", Email: "boydwolf@hopeli.com"})
+	if resp, _ := client.FindUsers(hw4.SearchRequest{Limit: 1}); resp == nil || resp.Users[0].Email != "" {
+		t.Errorf("email returned without fields: %+v", resp)
+	}
+	if resp, _ := client.FindUsers(hw4.SearchRequest{Limit: 1, Fields: hw4.FieldEmail}); resp == nil || resp.Users[0].Email != "boydwolf@hopeli.com" {
+		t.Errorf("email not returned with fields: %+v", resp)
+	}
+
+	srv.AssertRequestCount(t, 5)
+	srv.AssertParam(t, 4, "fields", "email")
+	srv.AssertRequest(t, 0, hw4.SearchRequest{Limit: 1, OrderField: "Age", OrderBy: hw4.OrderByAsc})
+	srv.AssertParam(t, 0, "limit", "2")
+	srv.AssertParam(t, 1, "query", "B")
+	if got := srv.Requests()[2].Status; got != 400 {
+		t.Errorf("expected recorded status 400, got %d", got)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	srv := NewServer(users...)
+	defer srv.Close()
+	client := srv.Client("token")
+	req := hw4.SearchRequest{Limit: 5}
+
+	srv.FailNext(InternalError(), BadJSON(), Unauthorized(), Timeout())
+	for _, want := range []string{"SearchServer fatal error", "cant unpack result", "Bad AccessToken", "timeout for"} {
+		if _, err := client.FindUsers(req); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("expected %q error, got %v", want, err)
+		}
+	}
+
+	srv.FailNext(Latency(50 * time.Millisecond))
+	started := time.Now()
+	if _, err := client.FindUsers(req); err != nil {
+		t.Errorf("unexpected error after latency: %v", err)
+	}
+	if time.Since(started) < 50*time.Millisecond {
+		t.Errorf("latency was not injected")
+	}
+
+	srv.FailAlways(InternalError())
+	if _, err := client.FindUsers(req); err == nil {
+		t.Errorf("expected error from FailAlways")
+	}
+	srv.Heal()
+	if _, err := client.FindUsers(req); err != nil {
+		t.Errorf("unexpected error after Heal: %v", err)
+	}
+
+	srv.RequireToken("secret")
+	if _, err := client.FindUsers(req); err == nil || err.Error() != "Bad AccessToken" {
+		t.Errorf("expected Bad AccessToken, got %v", err)
+	}
+	if _, err := srv.Client("secret").FindUsers(req); err != nil {
+		t.Errorf("unexpected error with right token: %v", err)
+	}
+	srv.AssertRequestCount(t, 9)
+}
@@ -0,0 +1,225 @@
+package hw4
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type Person struct {
+	ID            int    `xml:"id" json:"id"`
+	Guid          string `xml:"guid" json:"guid"`
+	IsActive      string `xml:"isActive" json:"isActive"`
+	Balance       string `xml:"balance" json:"balance"`
+	Picture       string `xml:"picture" json:"picture"`
+	Age           int    `xml:"age" json:"age"`
+	EyeColor      string `xml:"eyeColor" json:"eyeColor"`
+	FirstName     string `xml:"first_name" json:"first_name"`
+	LastName      string `xml:"last_name" json:"last_name"`
+	Gender        string `xml:"gender" json:"gender"`
+	Company       string `xml:"company" json:"company"`
+	Email         string `xml:"email" json:"email"`
+	Phone         string `xml:"phone" json:"phone"`
+	Address       string `xml:"address" json:"address"`
+	About         string `xml:"about" json:"about"`
+	Registered    string `xml:"registered" json:"registered"`
+	FavoriteFruit string `xml:"favoriteFruit" json:"favoriteFruit"`
+}
+
+type Root struct {
+	XMLName xml.Name `xml:"root"`
+	Persons []Person `xml:"row"`
+}
+
+// Name - это first_name + last_name
+func (p Person) Name() string {
+	return p.FirstName + " " + p.LastName
+}
+
+// Matches ищет подстроку query в Name и About, пустой query подходит всем
+func (p Person) Matches(query string) bool {
+	return strings.Contains(p.Name(), query) || strings.Contains(p.About, query)
+}
+
+func (p Person) User() User {
+	return User{
+		Id:     p.ID,
+		Name:   p.Name(),
+		Age:    p.Age,
+		About:  strings.TrimSpace(p.About),
+		Gender: p.Gender,
+	}
+}
+
+func SearchServer(w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("AccessToken")
+	if authHeader == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("AccessToken header is required"))
+		return
+	}
+
+	enc, ok := negotiateEncoding(r.Header.Get("Accept"))
+	if !ok {
+		w.WriteHeader(http.StatusNotAcceptable)
+		w.Write([]byte(`{"error": "Unsupported Accept value"}`))
+		return
+	}
+
+	r.ParseForm()
+	sr, err := parseSearchParams(r.Form)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// с snapshot ищем по той же версии датасета, что и на первой странице
+	var ds *Dataset
+	if sr.Snapshot != "" {
+		ds, err = storeFor(r).Snapshot(sr.Snapshot)
+	} else {
+		ds, err = storeFor(r).Get()
+	}
+	if errors.Is(err, ErrSnapshotExpired) {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	positions := ds.search(sr)
+
+	if sr.Offset < 0 || sr.Offset > len(positions) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "Invalid offset value"}`))
+		return
+	}
+	positions = positions[sr.Offset:]
+
+	if sr.Limit != 0 && sr.Limit <= len(positions) {
+		positions = positions[:sr.Limit]
+	}
+
+	// персональные данные попадают в ответ только через политику токена
+	policy := redactionPolicyFor(authHeader)
+	var filteredUsers []User
+	ids := make([]int, 0, len(positions))
+	for _, i := range positions {
+		filteredUsers = append(filteredUsers, policy.apply(ds.records[i].User, ds.Persons[i], sr.Fields))
+		ids = append(ids, ds.Persons[i].ID)
+	}
+
+	// без записи в журнале аудита результат не отдаём
+	if err := auditSearch(r, authHeader, ds.Snapshot, sr, ids); err != nil {
+		writeAuditError(w, err)
+		return
+	}
+
+	body, err := enc.marshal(filteredUsers)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Failed to convert users to ` + enc.name + `"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", enc.contentType)
+	w.Header().Set(SnapshotHeader, ds.Snapshot)
+	if sr.Fields != 0 {
+		w.Header().Set(RedactionHeader, policy.header(sr.Fields))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// parseSearchParams разбирает и проверяет параметры поиска, ошибка - текст для ответа 400.
+// offset проверяется уже по результату поиска
+func parseSearchParams(params url.Values) (SearchRequest, error) {
+	sr := SearchRequest{
+		Query:      params.Get("query"),
+		OrderField: params.Get("order_field"),
+		Snapshot:   params.Get("snapshot"),
+	}
+
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"order_by", &sr.OrderBy}, {"offset", &sr.Offset}, {"limit", &sr.Limit}} {
+		raw := params.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return SearchRequest{}, fmt.Errorf("Invalid %s value", p.name)
+		}
+		*p.value = n
+	}
+
+	if sr.OrderBy != OrderByAsIs && sr.OrderBy != OrderByDesc && sr.OrderBy != OrderByAsc {
+		return SearchRequest{}, errors.New("Invalid order_by value")
+	}
+	if _, ok := comparator(sr.OrderField); !ok && sr.OrderField != "" {
+		return SearchRequest{}, errors.New("Invalid order_field value")
+	}
+	if sr.Limit < 0 {
+		return SearchRequest{}, errors.New("Invalid limit value")
+	}
+	if err := parseFilterParams(params, &sr); err != nil {
+		return SearchRequest{}, err
+	}
+	fields, err := ParseExtraFields(params.Get("fields"))
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	sr.Fields = fields
+	return sr, nil
+}
+
+// NewSearchMux собирает ручки SearchServer: поиск на корне, выгрузку на /export,
+// штаты и города на /facets, запись датасета на /persons, метрики на /metrics
+// служебные ручки на /admin и пробы на /healthz и /readyz.
+// Основной датасет виден токенам из ServerConfig.DefaultTokens, см. defaultDatasetAllows.
+// Именованные датасеты из RegisterDataset отдаются на тех же путях под /datasets/{name}:
+// /datasets/{name}/search, /datasets/{name}/facets, /datasets/{name}/persons, /datasets/{name}/admin и т.д.
+func NewSearchMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/", instrument("search", defaultHandler(SearchServer)))
+	mux.Handle("/export", instrument("export", defaultHandler(SearchExportServer)))
+	mux.Handle("/facets", instrument("facets", defaultHandler(FacetsServer)))
+	mux.Handle("/datasets/{name}/search", instrument("search", tenantHandler(SearchServer)))
+	mux.Handle("/datasets/{name}/facets", instrument("facets", tenantHandler(FacetsServer)))
+	mux.Handle("/datasets/{name}/export", instrument("export", tenantHandler(SearchExportServer)))
+	mux.Handle("/searches", instrument("searches", defaultHandler(SavedSearchesServer)))
+	mux.Handle("/searches/{id}", instrument("searches", defaultHandler(SavedSearchesServer)))
+	mux.Handle("/searches/{id}/changes", instrument("changes", defaultHandler(SearchChangesServer)))
+	mux.Handle("/datasets/{name}/searches", instrument("searches", tenantHandler(SavedSearchesServer)))
+	mux.Handle("/datasets/{name}/searches/{id}", instrument("searches", tenantHandler(SavedSearchesServer)))
+	mux.Handle("/datasets/{name}/searches/{id}/changes", instrument("changes", tenantHandler(SearchChangesServer)))
+	mux.Handle("/persons", instrument("persons", defaultHandler(PersonsServer)))
+	mux.Handle("/persons/{id}", instrument("persons", defaultHandler(PersonsServer)))
+	mux.Handle("/datasets/{name}/persons", instrument("persons", tenantHandler(PersonsServer)))
+	mux.Handle("/datasets/{name}/persons/{id}", instrument("persons", tenantHandler(PersonsServer)))
+	mux.Handle("/admin", instrument("admin", http.HandlerFunc(AdminServer)))
+	mux.Handle("/admin/{action}", instrument("admin", http.HandlerFunc(AdminServer)))
+	mux.Handle("/datasets/{name}/admin", instrument("admin", adminTenantHandler(AdminServer)))
+	mux.Handle("/datasets/{name}/admin/{action}", instrument("admin", adminTenantHandler(AdminServer)))
+	mux.Handle("/metrics", defaultMetrics)
+	mux.HandleFunc("/healthz", HealthzServer)
+	mux.HandleFunc("/readyz", ReadyzServer)
+	return mux
+}
+
+// writeError отвечает ошибкой в том же виде, что и SearchServer: {"error": "..."}
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
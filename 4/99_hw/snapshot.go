@@ -0,0 +1,35 @@
+package hw4
+
+import (
+	"context"
+	"iter"
+)
+
+// SnapshotHeader - заголовок ответа SearchServer с версией датасета, по которой шёл поиск
+const SnapshotHeader = "X-Snapshot-Id"
+
+// Pages обходит все страницы выдачи, начиная с req.Offset. Страницы после первой
+// запрашиваются по той же версии датасета, что и первая, так что перезагрузка датасета
+// посреди обхода не дублирует и не теряет пользователей. Если версия успела устареть,
+// обход заканчивается ошибкой ErrSnapshotExpired
+func (srv *SearchClient) Pages(ctx context.Context, req SearchRequest) iter.Seq2[*SearchResponse, error] {
+	return func(yield func(*SearchResponse, error) bool) {
+		if req.Limit <= 0 {
+			req.Limit = 25
+		}
+		for {
+			resp, err := srv.FindUsersContext(ctx, req)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(resp, nil) || !resp.NextPage {
+				return
+			}
+			req.Offset += len(resp.Users)
+			if req.Snapshot == "" {
+				req.Snapshot = resp.Snapshot
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package hw4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestPagesPinnedToSnapshot(t *testing.T) {
+	useDatasetCopy(t)
+	useWriteTokens(t, "writer")
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	ctx := context.Background()
+	writer := &SearchClient{URL: server.URL, AccessToken: "writer"}
+	reader := &SearchClient{URL: server.URL, AccessToken: "reader"}
+
+	var (
+		ids      []int
+		snapshot string
+	)
+	for resp, err := range reader.Pages(ctx, SearchRequest{Limit: 10, OrderField: "Id", OrderBy: OrderByAsc}) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if snapshot == "" {
+			snapshot = resp.Snapshot
+			// датасет меняется посреди обхода: без снимка дальше потерялся бы один пользователь
+			if err := writer.DeletePerson(ctx, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if resp.Snapshot != snapshot {
+			t.Errorf("page served from snapshot %q, expected %q", resp.Snapshot, snapshot)
+		}
+		for _, user := range resp.Users {
+			ids = append(ids, user.Id)
+		}
+	}
+	if len(ids) != 35 {
+		t.Fatalf("expected 35 users, got %d: %v", len(ids), ids)
+	}
+	for i, id := range ids {
+		if id != i {
+			t.Fatalf("expected id %d at position %d, got %v", i, i, ids)
+		}
+	}
+
+	current, err := reader.FindUsers(SearchRequest{Limit: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current.Snapshot == snapshot || current.Snapshot == "" {
+		t.Errorf("expected new snapshot after delete, got %q", current.Snapshot)
+	}
+
+	// старые версии вытесняются новыми
+	for id := 1; id <= keepSnapshots; id++ {
+		if err := writer.DeletePerson(ctx, id); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	_, err = reader.FindUsers(SearchRequest{Limit: 1, Snapshot: snapshot})
+	if !errors.Is(err, ErrSnapshotExpired) {
+		t.Errorf("expected ErrSnapshotExpired, got %v", err)
+	}
+}
+
+// ошибки хранилища отдаются через writeError, как и остальные ошибки SearchServer
+func TestSearchServerStoreErrorIsJSON(t *testing.T) {
+	old := defaultDatasets
+	defaultDatasets = &datasetStore{path: filepath.Join(t.TempDir(), "missing.xml")}
+	t.Cleanup(func() { defaultDatasets = old })
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/?limit=1", nil)
+	r.Header.Set("AccessToken", "123")
+	SearchServer(w, r)
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != ContentTypeJSON {
+		t.Fatalf("expected 500 with json, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	errResp := SearchErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil || errResp.Error != errDatasetOpen.Error() {
+		t.Errorf("bad error json %q: %v", w.Body.String(), err)
+	}
+}
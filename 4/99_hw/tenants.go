@@ -0,0 +1,128 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var ErrDatasetNotFound = errors.New("dataset not found")
+
+// DatasetConfig - именованный датасет со своим файлом, индексами и токенами
+type DatasetConfig struct {
+	Name string
+	Path string
+	// токены, которым виден датасет, остальные получают 404, как будто его нет
+	Tokens []string
+}
+
+type tenant struct {
+	store  *datasetStore
+	tokens map[string]bool
+}
+
+var (
+	tenantsMu sync.RWMutex
+	tenants   = map[string]*tenant{}
+
+	// defaultTokens - токены основного датасета. Пока их нет, он открыт всем токенам,
+	// кроме выданных только для именованных датасетов
+	defaultTokens = map[string]bool{}
+)
+
+// RegisterDataset добавляет или заменяет датасет, доступный на /datasets/{name}/search,
+// /datasets/{name}/facets и остальных ручках основного датасета под /datasets/{name}.
+// Загружается он при первом запросе или в Warmup
+func RegisterDataset(cfg DatasetConfig) {
+	t := &tenant{store: &datasetStore{name: cfg.Name, path: cfg.Path}, tokens: map[string]bool{}}
+	for _, token := range cfg.Tokens {
+		t.tokens[token] = true
+	}
+
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	tenants[cfg.Name] = t
+}
+
+func lookupTenant(name string) (*tenant, bool) {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+	t, ok := tenants[name]
+	return t, ok
+}
+
+func tenantStores() []*datasetStore {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+	stores := make([]*datasetStore, 0, len(tenants))
+	for _, t := range tenants {
+		stores = append(stores, t.store)
+	}
+	return stores
+}
+
+// defaultDatasetAllows - виден ли токену основной датасет
+func defaultDatasetAllows(token string) bool {
+	if len(defaultTokens) > 0 {
+		return defaultTokens[token]
+	}
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+	for _, t := range tenants {
+		if t.tokens[token] {
+			return false
+		}
+	}
+	return true
+}
+
+type datasetStoreKey struct{}
+
+// storeFor - датасет, выбранный для запроса по пути, или основной
+func storeFor(r *http.Request) *datasetStore {
+	if store, ok := r.Context().Value(datasetStoreKey{}).(*datasetStore); ok {
+		return store
+	}
+	return defaultDatasets
+}
+
+// tenantHandler пускает к next только токены датасета из пути и подставляет его вместо основного
+func tenantHandler(next http.HandlerFunc) http.HandlerFunc {
+	return datasetHandler(next, func(t *tenant, token string) bool { return t.tokens[token] })
+}
+
+// defaultHandler закрывает ручки основного датасета от токенов, которым он не виден, тем же 404,
+// что и tenantHandler. Запрос без токена отклоняет сама ручка
+func defaultHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("AccessToken"); token != "" && !defaultDatasetAllows(token) {
+			writeError(w, http.StatusNotFound, ErrDatasetNotFound.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminTenantHandler - то же для /datasets/{name}/admin: админам виден любой датасет,
+// права на сами действия проверяет AdminServer
+func adminTenantHandler(next http.HandlerFunc) http.HandlerFunc {
+	return datasetHandler(next, func(t *tenant, token string) bool { return adminTokens[token] })
+}
+
+func datasetHandler(next http.HandlerFunc, allowed func(t *tenant, token string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("AccessToken")
+		if token == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("AccessToken header is required"))
+			return
+		}
+		t, ok := lookupTenant(r.PathValue("name"))
+		if !ok || !allowed(t, token) {
+			writeError(w, http.StatusNotFound, ErrDatasetNotFound.Error())
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), datasetStoreKey{}, t.store)))
+	}
+}
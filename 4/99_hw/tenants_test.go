@@ -0,0 +1,194 @@
+package hw4
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useTenants подменяет набор именованных датасетов на время теста
+func useTenants(t *testing.T, configs ...DatasetConfig) {
+	t.Helper()
+	tenantsMu.Lock()
+	old := tenants
+	tenants = map[string]*tenant{}
+	tenantsMu.Unlock()
+	t.Cleanup(func() {
+		tenantsMu.Lock()
+		tenants = old
+		tenantsMu.Unlock()
+	})
+	for _, cfg := range configs {
+		RegisterDataset(cfg)
+	}
+}
+
+func useDefaultTokens(t *testing.T, tokens ...string) {
+	t.Helper()
+	old := defaultTokens
+	defaultTokens = map[string]bool{}
+	for _, token := range tokens {
+		defaultTokens[token] = true
+	}
+	t.Cleanup(func() { defaultTokens = old })
+}
+
+// writeSmallDataset пишет датасет из первых n персон основного
+func writeSmallDataset(t *testing.T, n int) string {
+	t.Helper()
+	ds, err := LoadDataset("dataset.xml")
+	if err != nil {
+		t.Fatalf("cant load dataset: %v", err)
+	}
+	data, err := encodeDataset(ds.Persons[:n])
+	if err != nil {
+		t.Fatalf("cant encode dataset: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "small.xml")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("cant write dataset: %v", err)
+	}
+	return path
+}
+
+func TestDatasetsIsolation(t *testing.T) {
+	useTenants(t,
+		DatasetConfig{Name: "full", Path: "dataset.xml", Tokens: []string{"alice"}},
+		DatasetConfig{Name: "small", Path: writeSmallDataset(t, 3), Tokens: []string{"alice", "bob"}},
+	)
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+
+	full := &SearchClient{AccessToken: "alice", URL: server.URL, Dataset: "full"}
+	resp, err := full.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || len(resp.Users) != 25 || !resp.NextPage {
+		t.Fatalf("full dataset: %+v, %v", resp, err)
+	}
+
+	small := &SearchClient{AccessToken: "bob", URL: server.URL, Dataset: "small"}
+	resp, err = small.FindUsers(SearchRequest{Limit: 25})
+	if err != nil || len(resp.Users) != 3 || resp.NextPage {
+		t.Fatalf("small dataset: %+v, %v", resp, err)
+	}
+	facets, err := small.Facets(context.Background(), SearchRequest{})
+	if err != nil {
+		t.Fatalf("small facets: %v", err)
+	}
+	total := 0
+	for _, count := range facets.States {
+		total += count
+	}
+	if total != 3 {
+		t.Errorf("small facets count %d persons, want 3", total)
+	}
+
+	cases := []*SearchClient{
+		{AccessToken: "bob", URL: server.URL, Dataset: "full"},
+		{AccessToken: "bob", URL: server.URL, Dataset: "missing"},
+	}
+	for _, srv := range cases {
+		if _, err := srv.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrDatasetNotFound) {
+			t.Errorf("dataset %q for %q: want ErrDatasetNotFound, got %v", srv.Dataset, srv.AccessToken, err)
+		}
+		if _, err := srv.Facets(context.Background(), SearchRequest{}); !errors.Is(err, ErrDatasetNotFound) {
+			t.Errorf("facets %q for %q: want ErrDatasetNotFound, got %v", srv.Dataset, srv.AccessToken, err)
+		}
+	}
+
+	noToken := &SearchClient{URL: server.URL, Dataset: "small"}
+	if _, err := noToken.FindUsers(SearchRequest{Limit: 1}); err == nil || err.Error() != "Bad AccessToken" {
+		t.Errorf("want Bad AccessToken, got %v", err)
+	}
+
+	metrics := &strings.Builder{}
+	defaultMetrics.WriteTo(metrics)
+	if !strings.Contains(metrics.String(), `search_dataset_size{dataset="small"} 3`+"\n") {
+		t.Errorf("metrics miss small dataset size:\n%s", metrics)
+	}
+}
+
+// выгрузка, запись и админские ручки клиента с Dataset идут в именованный датасет, а не в основной
+func TestDatasetsPersonsExportAdmin(t *testing.T) {
+	useDatasetCopy(t)
+	useTenants(t, DatasetConfig{Name: "small", Path: writeSmallDataset(t, 3), Tokens: []string{"bob"}})
+	useWriteTokens(t, "bob")
+	useAdminTokens(t, "admin")
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	ctx := context.Background()
+	before, _ := defaultDatasets.Get()
+
+	small := &SearchClient{AccessToken: "bob", URL: server.URL, Dataset: "small"}
+	created, err := small.CreatePerson(ctx, Person{FirstName: "Boyd", Age: 22, Gender: "male", IsActive: "true"})
+	if err != nil || created.ID != 3 {
+		t.Fatalf("create in small: %+v, %v", created, err)
+	}
+	if _, err := small.GetPerson(ctx, created.ID); err != nil {
+		t.Errorf("get from small: %v", err)
+	}
+	exported := 0
+	for _, err := range small.ExportUsers(ctx, "") {
+		if err != nil {
+			t.Fatalf("export small: %v", err)
+		}
+		exported++
+	}
+	if exported != 4 {
+		t.Errorf("exported %d users from small, want 4", exported)
+	}
+
+	status := adminStatus{}
+	if code := adminRequest(t, server, http.MethodGet, "/datasets/small/admin", "admin", &status); code != http.StatusOK || status.Dataset.Records != 4 {
+		t.Errorf("small admin status: %d, %+v", code, status.Dataset)
+	}
+	if code := adminRequest(t, server, http.MethodGet, "/datasets/small/admin", "bob", nil); code != http.StatusNotFound {
+		t.Errorf("small admin status for bob: want 404, got %d", code)
+	}
+	if after, _ := defaultDatasets.Get(); after.Snapshot != before.Snapshot {
+		t.Errorf("default dataset changed: %s -> %s", before.Snapshot, after.Snapshot)
+	}
+
+	missing := &SearchClient{AccessToken: "bob", URL: server.URL, Dataset: "missing"}
+	if _, err := missing.GetPerson(ctx, 0); !errors.Is(err, ErrDatasetNotFound) {
+		t.Errorf("get from missing: want ErrDatasetNotFound, got %v", err)
+	}
+	for _, err := range missing.ExportUsers(ctx, "") {
+		if !errors.Is(err, ErrDatasetNotFound) {
+			t.Errorf("export missing: want ErrDatasetNotFound, got %v", err)
+		}
+	}
+}
+
+func TestDefaultDatasetIsolation(t *testing.T) {
+	useTenants(t, DatasetConfig{Name: "small", Path: writeSmallDataset(t, 3), Tokens: []string{"bob"}})
+	server := httptest.NewServer(NewSearchMux())
+	defer server.Close()
+	find := func(token string) error {
+		_, err := (&SearchClient{AccessToken: token, URL: server.URL}).FindUsers(SearchRequest{Limit: 1})
+		return err
+	}
+
+	// без DefaultTokens основной датасет открыт всем, кроме токенов именованных датасетов
+	if err := find("123"); err != nil {
+		t.Errorf("unscoped token: %v", err)
+	}
+	if err := find("bob"); !errors.Is(err, ErrDatasetNotFound) {
+		t.Errorf("tenant token: want ErrDatasetNotFound, got %v", err)
+	}
+	if _, err := (&SearchClient{AccessToken: "bob", URL: server.URL}).Facets(context.Background(), SearchRequest{}); !errors.Is(err, ErrDatasetNotFound) {
+		t.Errorf("tenant token facets: want ErrDatasetNotFound, got %v", err)
+	}
+
+	useDefaultTokens(t, "carol")
+	if err := find("carol"); err != nil {
+		t.Errorf("default token: %v", err)
+	}
+	if err := find("123"); !errors.Is(err, ErrDatasetNotFound) {
+		t.Errorf("token outside DefaultTokens: want ErrDatasetNotFound, got %v", err)
+	}
+}
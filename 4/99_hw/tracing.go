@@ -0,0 +1,94 @@
+package hw4
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const TraceParentHeader = "traceparent"
+
+// TraceContext - контекст трассировки в формате W3C Trace Context
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// NewTraceContext начинает новую трассу
+func NewTraceContext() TraceContext {
+	tc := TraceContext{Flags: 1}
+	rand.Read(tc.TraceID[:])
+	rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// Child - новый спан в той же трассе
+func (tc TraceContext) Child() TraceContext {
+	child := TraceContext{TraceID: tc.TraceID, Flags: tc.Flags}
+	rand.Read(child.SpanID[:])
+	return child
+}
+
+func (tc TraceContext) TraceIDString() string {
+	return hex.EncodeToString(tc.TraceID[:])
+}
+
+func (tc TraceContext) SpanIDString() string {
+	return hex.EncodeToString(tc.SpanID[:])
+}
+
+// String отдаёт значение для заголовка traceparent
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceIDString(), tc.SpanIDString(), tc.Flags)
+}
+
+// ParseTraceParent разбирает заголовок traceparent версии 00, нулевые trace-id и parent-id невалидны
+func ParseTraceParent(value string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return TraceContext{}, false
+	}
+	if parts[1] != strings.ToLower(parts[1]) || parts[2] != strings.ToLower(parts[2]) {
+		return TraceContext{}, false
+	}
+
+	tc := TraceContext{}
+	var flags [1]byte
+	if _, err := hex.Decode(tc.TraceID[:], []byte(parts[1])); err != nil {
+		return TraceContext{}, false
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(parts[2])); err != nil {
+		return TraceContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return TraceContext{}, false
+	}
+	tc.Flags = flags[0]
+
+	if tc.TraceID == [16]byte{} || tc.SpanID == [8]byte{} {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
+
+type traceContextKey struct{}
+
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// outgoingTrace - спан для исходящего запроса: дочерний к текущему или новая трасса
+func outgoingTrace(ctx context.Context) TraceContext {
+	if tc, ok := TraceFromContext(ctx); ok {
+		return tc.Child()
+	}
+	return NewTraceContext()
+}
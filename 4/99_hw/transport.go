@@ -0,0 +1,137 @@
+package hw4
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxIdleConnsPerHost = 64
+	defaultIdleConnTimeout     = 90 * time.Second
+	// DefaultMaxResponseBytes - больше этого ответ на FindUsers, Facets и запросы /persons не читается
+	DefaultMaxResponseBytes = 8 << 20
+	// сколько дочитывать из недочитанного тела, чтобы соединение вернулось в пул, а не закрылось
+	maxDrainBytes = 64 << 10
+)
+
+var ErrResponseTooLarge = errors.New("SearchServer response too large")
+
+// TransportConfig - настройки транспорта SearchClient, нулевые поля - значения по умолчанию
+type TransportConfig struct {
+	// HTTP/2 без TLS (h2c) для http:// адресов, https идёт по обычному HTTP/2.
+	// Сервер должен его понимать, см. ServerConfig.H2C
+	H2C bool
+	// сколько простаивающих соединений держать на хост, 0 - 64.
+	// У http.DefaultTransport их 2, и под нагрузкой остальные соединения закрываются и открываются заново
+	MaxIdleConnsPerHost int
+	// не больше стольких соединений на хост, лишние запросы ждут свободного, 0 - без ограничения
+	MaxConnsPerHost int
+	// через сколько закрывать простаивающее соединение, 0 - 90 секунд
+	IdleConnTimeout time.Duration
+	// таймаут всего запроса вместе с чтением тела для NewHTTPClient, 0 - без таймаута
+	Timeout time.Duration
+}
+
+// NewTransport - транспорт на основе http.DefaultTransport с ограниченным пулом соединений
+func NewTransport(cfg TransportConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost <= 0 {
+		transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	// общий лимит не должен срезать пул одного хоста
+	transport.MaxIdleConns = max(transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if cfg.H2C {
+		// без HTTP1 транспорт идёт на http:// адреса сразу по HTTP/2, не пытаясь апгрейдить соединение
+		transport.Protocols = &http.Protocols{}
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
+
+// NewHTTPClient - клиент для SearchClient.HTTPClient
+func NewHTTPClient(cfg TransportConfig) *http.Client {
+	return &http.Client{Transport: NewTransport(cfg), Timeout: cfg.Timeout}
+}
+
+// общий транспорт клиентов по умолчанию, чтобы FindUsers, выгрузка и подписки делили один пул
+var defaultTransport = NewTransport(TransportConfig{})
+
+// httpClient - клиент для коротких запросов
+func (srv *SearchClient) httpClient() *http.Client {
+	if srv.HTTPClient != nil {
+		return srv.HTTPClient
+	}
+	return client
+}
+
+// streamClient - клиент для выгрузки и подписок: тот же транспорт, но без общего таймаута,
+// такие запросы отменяются через контекст
+func (srv *SearchClient) streamClient(fallback *http.Client) *http.Client {
+	if srv.HTTPClient == nil {
+		return fallback
+	}
+	c := *srv.HTTPClient
+	c.Timeout = 0
+	return &c
+}
+
+func (srv *SearchClient) maxResponseBytes() int64 {
+	if srv.MaxResponseBytes == 0 {
+		return DefaultMaxResponseBytes
+	}
+	return srv.MaxResponseBytes
+}
+
+// limitBody не даёт прочитать из тела ответа больше n байт: дальше - ErrResponseTooLarge. n < 0 - без ограничения
+func limitBody(resp *http.Response, n int64) io.Reader {
+	if n < 0 {
+		return resp.Body
+	}
+	if resp.ContentLength > n {
+		return errReader{ErrResponseTooLarge}
+	}
+	return &limitedReader{r: resp.Body, n: n}
+}
+
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// лимит исчерпан: ошибка, только если тело на самом деле длиннее
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrResponseTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// drainAndClose дочитывает немного хвоста, чтобы соединение HTTP/1.1 можно было переиспользовать,
+// а длинный хвост не читает - такое соединение дешевле закрыть
+func drainAndClose(body io.ReadCloser) {
+	io.CopyN(io.Discard, body, maxDrainBytes)
+	body.Close()
+}
@@ -0,0 +1,237 @@
+package hw4
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingListener считает принятые соединения
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func startCountingServer(tb testing.TB, h2c bool, handler http.Handler) (string, *countingListener) {
+	server := httptest.NewUnstartedServer(handler)
+	ln := &countingListener{Listener: server.Listener}
+	server.Listener = ln
+	if h2c {
+		server.Config.Protocols = &http.Protocols{}
+		server.Config.Protocols.SetHTTP1(true)
+		server.Config.Protocols.SetUnencryptedHTTP2(true)
+	}
+	server.Start()
+	tb.Cleanup(server.Close)
+	return server.URL, ln
+}
+
+func TestTransportH2C(t *testing.T) {
+	var proto atomic.Int64
+	url, ln := startCountingServer(t, true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto.Store(int64(r.ProtoMajor))
+		SearchServer(w, r)
+	}))
+
+	client := &SearchClient{URL: url, AccessToken: "123", HTTPClient: NewHTTPClient(TransportConfig{H2C: true, Timeout: time.Second})}
+	for i := 0; i < 5; i++ {
+		result, err := client.FindUsers(SearchRequest{Limit: 5, Offset: i})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(result.Users) != 5 {
+			t.Fatalf("expected 5 users, got %d", len(result.Users))
+		}
+	}
+	if proto.Load() != 2 {
+		t.Errorf("expected HTTP/2 request, got HTTP/%d", proto.Load())
+	}
+	if n := ln.accepted.Load(); n != 1 {
+		t.Errorf("expected all requests over 1 connection, got %d", n)
+	}
+}
+
+func TestResponseTooLarge(t *testing.T) {
+	users := make([]User, 1000)
+	for i := range users {
+		users[i] = User{Id: i, Name: "Boyd Wolf", About: strings.Repeat("x", 100)}
+	}
+	body, _ := json.Marshal(users)
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"content-length", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+		}},
+		{"chunked", func(w http.ResponseWriter, r *http.Request) {
+			for chunk := range slices.Chunk(body, 4096) {
+				w.Write(chunk)
+				w.(http.Flusher).Flush()
+			}
+		}},
+	}
+	for _, c := range cases {
+		server := httptest.NewServer(c.handler)
+		client := &SearchClient{URL: server.URL, AccessToken: "123", MaxResponseBytes: 64 << 10}
+		_, err := client.FindUsers(SearchRequest{Limit: 10})
+		if !errors.Is(err, ErrResponseTooLarge) {
+			t.Errorf("[%s] expected ErrResponseTooLarge, got %v", c.name, err)
+		}
+
+		client.MaxResponseBytes = -1
+		if _, err := client.FindUsers(SearchRequest{Limit: 10}); err != nil {
+			t.Errorf("[%s] unexpected error without limit: %v", c.name, err)
+		}
+		server.Close()
+	}
+}
+
+// Timeout клиента, сработавший посреди тела, - тоже ErrTimeout, а не ошибка разбора
+func TestTimeoutWhileDecoding(t *testing.T) {
+	stop := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Id": 1}, `))
+		w.(http.Flusher).Flush()
+		<-stop
+	}))
+	defer server.Close()
+	defer close(stop)
+
+	client := &SearchClient{URL: server.URL, AccessToken: "123", HTTPClient: NewHTTPClient(TransportConfig{Timeout: 100 * time.Millisecond})}
+	_, err := client.FindUsers(SearchRequest{Limit: 5})
+	if !errors.Is(err, ErrTimeout) || !strings.HasPrefix(err.Error(), "timeout for ") {
+		t.Errorf("expected ErrTimeout, got %v", err)
+	}
+}
+
+// ответы с ошибкой и недочитанный хвост json не должны закрывать соединение
+func TestTransportReusesConnections(t *testing.T) {
+	var calls atomic.Int64
+	url, ln := startCountingServer(t, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) % 3 {
+		case 0:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": "Bad AccessToken"}`))
+		case 1:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "ErrorBadOrderField"}`))
+		default:
+			w.Write([]byte("[]\n\n\n"))
+		}
+	}))
+
+	client := &SearchClient{URL: url, AccessToken: "123"}
+	for i := 0; i < 9; i++ {
+		client.FindUsers(SearchRequest{Limit: 5})
+	}
+	if n := ln.accepted.Load(); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+}
+
+func TestDecodeUsersStream(t *testing.T) {
+	users := []User{{Id: 1, Name: "Boyd Wolf"}, {Id: 2, Name: "Hilda Mayer", Email: "hilda@example.com"}}
+	for _, enc := range userEncodings[:2] {
+		data, err := enc.marshal(users)
+		if err != nil {
+			t.Fatalf("[%s] cant marshal: %v", enc.name, err)
+		}
+		decoded, err := enc.decodeFrom(io.MultiReader(bytes.NewReader(data[:7]), bytes.NewReader(data[7:])))
+		if err != nil {
+			t.Fatalf("[%s] unexpected error: %v", enc.name, err)
+		}
+		if len(decoded) != 2 || decoded[1] != users[1] {
+			t.Errorf("[%s] wrong users %#v", enc.name, decoded)
+		}
+	}
+
+	if _, err := decodeUsersJSON(strings.NewReader(`[] []`)); err == nil {
+		t.Errorf("expected error for trailing data")
+	}
+}
+
+// go test -run XXX -bench FindUsersParallel -benchmem
+// conns - сколько соединений открыл клиент за весь прогон
+func BenchmarkFindUsersParallel(b *testing.B) {
+	clients := []struct {
+		name   string
+		h2c    bool
+		client *http.Client
+	}{
+		// как было: пул из 2 простаивающих соединений на хост, как у http.DefaultTransport
+		{"default-transport", false, &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone(), Timeout: time.Second}},
+		{"pooled", false, NewHTTPClient(TransportConfig{Timeout: time.Second})},
+		{"h2c", true, NewHTTPClient(TransportConfig{H2C: true, Timeout: time.Second})},
+	}
+	for _, c := range clients {
+		b.Run(c.name, func(b *testing.B) {
+			url, ln := startCountingServer(b, c.h2c, http.HandlerFunc(SearchServer))
+			client := &SearchClient{URL: url, AccessToken: "123", HTTPClient: c.client}
+			// горутин больше, чем простаивающих соединений в пуле http.DefaultTransport
+			b.SetParallelism(16)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.FindUsers(SearchRequest{Limit: 25, Query: "a"}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(ln.accepted.Load()), "conns")
+			c.client.CloseIdleConnections()
+		})
+	}
+}
+
+// go test -run XXX -bench DecodeUsers -benchmem
+// stream не держит в памяти всё тело ответа и тратит меньше B/op, но json.Decoder
+// медленнее json.Unmarshal: на 25 пользователях stream на 30-55% дольше на op
+func BenchmarkDecodeUsers(b *testing.B) {
+	users := make([]User, 25)
+	for i := range users {
+		users[i] = User{Id: i, Name: "Boyd Wolf", Age: 22, About: strings.Repeat("Nulla cillum enim voluptate ", 20), Gender: "male"}
+	}
+	data, _ := json.Marshal(users)
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			body, _ := io.ReadAll(bytes.NewReader(data))
+			var decoded []User
+			if err := json.Unmarshal(body, &decoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := decodeUsersJSON(bytes.NewReader(data)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}